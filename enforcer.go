@@ -430,16 +430,42 @@ func (e *Enforcer) BuildIncrementalRoleLinks(op model.PolicyOp, ptype string, ru
 	return e.model.BuildIncrementalRoleLinks(e.rmMap, op, "g", ptype, rules)
 }
 
+// ErrEnforcePanicked is matched with errors.Is by the error of an enforcement which panicked, like with a custom
+// function of the matcher panicking.
+var ErrEnforcePanicked = errors.New("the enforcement panicked")
+
+// panicError is the error of an enforcement which recovered from a panic.
+type panicError struct {
+	value interface{}
+}
+
+func (e panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// Is reports whether target is ErrEnforcePanicked.
+func (e panicError) Is(target error) bool {
+	return target == ErrEnforcePanicked
+}
+
 // enforce use a custom matcher to decides whether a "subject" can access a "object" with the operation "action", input parameters are usually: (matcher, sub, obj, act), use model matcher by default when matcher is "".
-func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interface{}) (ok bool, err error) {
+func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interface{}) (bool, error) {
+	result, _, err := e.enforceEffects(matcher, explains, rvals...)
+	return result, err
+}
+
+// enforceEffects is like enforce, and also returns the effects of the policy rules merged into the decision,
+// effect.Indeterminate for the ones which don't match. The rules after the first match of "priority(p_eft) || deny"
+// aren't evaluated and left as the zero effect.
+func (e *Enforcer) enforceEffects(matcher string, explains *[]string, rvals ...interface{}) (ok bool, policyEffects []effect.Effect, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("panic: %v", r)
+			err = panicError{value: r}
 		}
 	}()
 
 	if !e.enabled {
-		return true, nil
+		return true, nil, nil
 	}
 
 	functions := e.fm.GetFunctions()
@@ -462,7 +488,7 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 	if !hasEval {
 		expression, err = govaluate.NewEvaluableExpressionWithFunctions(expString, functions)
 		if err != nil {
-			return false, err
+			return false, nil, err
 		}
 	}
 
@@ -482,14 +508,13 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 		pTokens: pTokens,
 	}
 
-	var policyEffects []effect.Effect
 	var matcherResults []float64
 
 	if policyLen := len(e.model["p"]["p"].Policy); policyLen != 0 {
 		policyEffects = make([]effect.Effect, policyLen)
 		matcherResults = make([]float64, policyLen)
 		if len(e.model["r"]["r"].Tokens) != len(rvals) {
-			return false, fmt.Errorf(
+			return false, nil, fmt.Errorf(
 				"invalid request size: expected %d, got %d, rvals: %v",
 				len(e.model["r"]["r"].Tokens),
 				len(rvals),
@@ -498,7 +523,7 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 		for i, pvals := range e.model["p"]["p"].Policy {
 			// log.LogPrint("Policy Rule: ", pvals)
 			if len(e.model["p"]["p"].Tokens) != len(pvals) {
-				return false, fmt.Errorf(
+				return false, nil, fmt.Errorf(
 					"invalid policy size: expected %d, got %d, pvals: %v",
 					len(e.model["p"]["p"].Tokens),
 					len(pvals),
@@ -515,13 +540,13 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 						rule := util.EscapeAssertion(pvals[j])
 						replacements[ruleName] = rule
 					} else {
-						return false, errors.New("please make sure rule exists in policy when using eval() in matcher")
+						return false, nil, errors.New("please make sure rule exists in policy when using eval() in matcher")
 					}
 				}
 				expWithRule := util.ReplaceEvalWithMap(expString, replacements)
 				expression, err = govaluate.NewEvaluableExpressionWithFunctions(expWithRule, functions)
 				if err != nil {
-					return false, fmt.Errorf("p.sub_rule should satisfy the syntax of matcher: %s", err)
+					return false, nil, fmt.Errorf("p.sub_rule should satisfy the syntax of matcher: %s", err)
 				}
 			}
 
@@ -529,7 +554,7 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 			// log.LogPrint("Result: ", result)
 
 			if err != nil {
-				return false, err
+				return false, nil, err
 			}
 
 			switch result := result.(type) {
@@ -546,7 +571,7 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 					matcherResults[i] = result
 				}
 			default:
-				return false, errors.New("matcher result should be bool, int or float")
+				return false, nil, errors.New("matcher result should be bool, int or float")
 			}
 
 			if j, ok := parameters.pTokens["p_eft"]; ok {
@@ -569,7 +594,7 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 		}
 	} else {
 		if hasEval && len(e.model["p"]["p"].Policy) == 0 {
-			return false, errors.New("please make sure rule exists in policy when using eval() in matcher")
+			return false, nil, errors.New("please make sure rule exists in policy when using eval() in matcher")
 		}
 
 		policyEffects = make([]effect.Effect, 1)
@@ -580,7 +605,7 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 		result, err := expression.Eval(parameters)
 
 		if err != nil {
-			return false, err
+			return false, nil, err
 		}
 
 		if result.(bool) {
@@ -592,7 +617,7 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 
 	result, explainIndex, err := e.eft.MergeEffects(e.model["e"]["e"].Value, policyEffects, matcherResults)
 	if err != nil {
-		return false, err
+		return false, nil, err
 	}
 
 	var logExplains [][]string
@@ -606,7 +631,7 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 
 	e.logger.LogEnforce(expString, rvals, result, logExplains)
 
-	return result, nil
+	return result, policyEffects, nil
 }
 
 // Enforce decides whether a "subject" can access a "object" with the operation "action", input parameters are usually: (sub, obj, act).
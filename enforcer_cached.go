@@ -15,35 +15,149 @@
 package casbin
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Knetic/govaluate"
+	"github.com/casbin/casbin/v2/effect"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/casbin/casbin/v2/persist/cache"
+	"github.com/casbin/casbin/v2/rbac"
+	defaultrolemanager "github.com/casbin/casbin/v2/rbac/default-role-manager"
+	"golang.org/x/sync/singleflight"
 )
 
 // CachedEnforcer wraps Enforcer and provides decision cache
 type CachedEnforcer struct {
+	// stats is accessed atomically and kept first for 64-bit alignment on 32-bit platforms.
+	stats CacheStats
+	// missLatency holds the counts of the buckets of MissLatencyHistogram, it is accessed atomically.
+	missLatency [len(missLatencyBounds) + 1]uint64
 	*Enforcer
-	m           map[string]bool
-	enableCache int32
-	locker      *sync.RWMutex
+	// expireTime and cache are guarded by locker.
+	expireTime uint
+	cache      persist.Cache
+	// explainCache holds the decisions of EnforceEx along with their explanations.
+	explainCache *cache.TypedDefaultCache[explainedDecision]
+	enableCache  int32
+	// nonStringPolicy is a NonStringPolicy accessed atomically.
+	nonStringPolicy int32
+	// skipNegativeResults is accessed atomically.
+	skipNegativeResults int32
+	// failClosed is accessed atomically.
+	failClosed int32
+	// defaultDecision is accessed atomically.
+	defaultDecision int32
+	// slidingExpiration is accessed atomically.
+	slidingExpiration int32
+	// readOnly is accessed atomically.
+	readOnly int32
+	// closed is accessed atomically.
+	closed int32
+	// sampleRate is accessed atomically.
+	sampleRate int32
+	// reWarmOnClear is accessed atomically.
+	reWarmOnClear int32
+	keySeparator  string
+	// maxKeyLength and keyOverflow are set with SetMaxKeyLength and SetKeyOverflow.
+	maxKeyLength int
+	keyOverflow  KeyOverflow
+	// invalidationBatchSize is set with SetInvalidationBatchSize.
+	invalidationBatchSize int
+	// domainIndex is the position of the domain in the requests, for InvalidateCacheForDomain.
+	domainIndex int
+	// namespace is the prefix of all the keys, followed by a colon, or empty.
+	namespace       string
+	keyFunc         func(rvals ...interface{}) (string, bool)
+	keyHasher       func(key string) string
+	resultCacheable func(rvals []interface{}) bool
+	onMiss          func(key string, rvals []interface{})
+	onDrift         func(key string, cached, live bool)
+	transform       func(rvals []interface{}, raw bool) bool
+	observer        CacheObserver
+	// bypassSubjects holds a map[string]struct{} of the subjects bypassing the cache.
+	bypassSubjects atomic.Value
+	// ttlJitter holds the ttlJitter of the survival times, see SetTTLJitter.
+	ttlJitter atomic.Value
+	// verifyRate holds the float64 probability of a hit to be verified, see VerifyCache.
+	verifyRate atomic.Value
+	// lastPolicyChange holds the time.Time of the last change of the policy, see LastPolicyChange.
+	lastPolicyChange atomic.Value
+	// reWarmKeys holds the []string of the keys captured by the last InvalidateCache, until they are re-warmed.
+	reWarmKeys atomic.Value
+	// flights runs the evaluations of concurrent misses once per key.
+	flights singleflight.Group
+	// evictionBase holds the eviction counters of the cache when the stats were last reset, it is guarded by locker.
+	evictionBase persist.EvictionStats
+	locker       *sync.RWMutex
 }
 
+// ErrEnforcerNotInitialized is returned by a CachedEnforcer which was not created with NewCachedEnforcer,
+// like the zero value, instead of panicking on its missing enforcer or cache. Its methods without an error do nothing
+// and return zero values instead, but SetCache, which panics with it. The methods of the embedded Enforcer still panic.
+var ErrEnforcerNotInitialized = errors.New("the cached enforcer is not initialized, create it with NewCachedEnforcer")
+
+// ErrEnforcerClosed is returned by CachedEnforcer.Close when the enforcer is already closed.
+var ErrEnforcerClosed = errors.New("the cached enforcer is already closed")
+
+// ErrRequestNotCacheable is returned by CachedEnforcer.Preset for a request which doesn't go through the cache,
+// like the requests of the subjects bypassing it.
+var ErrRequestNotCacheable = errors.New("the request is not cacheable")
+
 // NewCachedEnforcer creates a cached enforcer via file or DB.
 func NewCachedEnforcer(params ...interface{}) (*CachedEnforcer, error) {
-	e := &CachedEnforcer{}
-	var err error
-	e.Enforcer, err = NewEnforcer(params...)
+	enforcer, err := NewEnforcer(params...)
 	if err != nil {
 		return nil, err
 	}
+	return NewCachedEnforcerFrom(enforcer)
+}
 
+// NewCachedEnforcerFrom creates a cached enforcer on top of an existing enforcer, with its adapter, role manager
+// and policy, instead of creating a new one. A watcher set on enforcer is set again with SetWatcher of the cached
+// enforcer, so that its notifications also invalidate the cached decisions. The policy must then only be changed
+// through the cached enforcer, the changes made directly to enforcer don't invalidate the cached decisions.
+func NewCachedEnforcerFrom(enforcer *Enforcer) (*CachedEnforcer, error) {
+	if enforcer == nil {
+		return nil, errors.New("the enforcer to cache must not be nil")
+	}
+	e := &CachedEnforcer{Enforcer: enforcer}
 	e.enableCache = 1
-	e.m = make(map[string]bool)
+	e.cache = cache.NewDefaultCache()
+	e.explainCache = cache.NewTypedDefaultCache[explainedDecision]()
+	e.keySeparator = DefaultKeySeparator
+	e.domainIndex = 1
+	e.lastPolicyChange.Store(time.Now())
 	e.locker = new(sync.RWMutex)
+	if enforcer.watcher != nil {
+		if err := e.SetWatcher(enforcer.watcher); err != nil {
+			return nil, err
+		}
+	}
 	return e, nil
 }
 
 // EnableCache determines whether to enable cache on Enforce(). When enableCache is enabled, cached result (true | false) will be returned for previous decisions.
+// Disabling the cache doesn't delete the cached decisions, they are returned again once it is enabled, unless
+// InvalidateCache is called in between.
 func (e *CachedEnforcer) EnableCache(enableCache bool) {
 	var enabled int32
 	if enableCache {
@@ -52,51 +166,2494 @@ func (e *CachedEnforcer) EnableCache(enableCache bool) {
 	atomic.StoreInt32(&e.enableCache, enabled)
 }
 
+// EnableCacheWithOptions is like EnableCache, but with flushOnDisable, disabling the cache also deletes the cached
+// decisions of the namespace, so that enabling it again starts with an empty cache.
+// The decisions are not re-warmed, even with SetReWarmOnClear.
+func (e *CachedEnforcer) EnableCacheWithOptions(enable bool, flushOnDisable bool) error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	e.EnableCache(enable)
+	if enable || !flushOnDisable {
+		return nil
+	}
+	return e.clearCache()
+}
+
+// IsCacheEnabled reports whether the cache is enabled, see EnableCache.
+func (e *CachedEnforcer) IsCacheEnabled() bool {
+	return atomic.LoadInt32(&e.enableCache) != 0
+}
+
+// EnableNonStringKeys determines whether to cache decisions for requests with non-string values, like ABAC structs or numeric IDs.
+// It is disabled by default, so such requests bypass the cache. When enabled, a non-string value is keyed by
+// fmt's Go-syntax representation of its type and value, pointers being followed to the value they point to.
+// Two different values only share a key if they print the same, which can happen for types with a custom GoString
+// method, or for structs holding pointers, maps or channels which print as their address rather than their content.
+// A string value spelling out such a representation, like "int(1)", also shares the key of that value.
+// It is the same as SetNonStringPolicy with NonStringCoerce, or NonStringBypass when disabled.
+func (e *CachedEnforcer) EnableNonStringKeys(enable bool) {
+	policy := NonStringBypass
+	if enable {
+		policy = NonStringCoerce
+	}
+	e.SetNonStringPolicy(policy)
+}
+
+// NonStringPolicy tells how the requests with non-string values are cached, see SetNonStringPolicy.
+type NonStringPolicy int32
+
+const (
+	// NonStringBypass evaluates the requests with non-string values without the cache, which is the default.
+	NonStringBypass NonStringPolicy = iota
+	// NonStringCoerce caches the decisions of the requests with non-string values, see EnableNonStringKeys.
+	NonStringCoerce
+	// NonStringError rejects the requests with non-string values with ErrNonStringRequestValue, to catch the calls
+	// passing the wrong type to a model expecting strings.
+	NonStringError
+)
+
+// ErrNonStringRequestValue is returned for a request with a non-string value with NonStringError,
+// it is wrapped with the position and the type of the value.
+var ErrNonStringRequestValue = errors.New("the request value is not a string")
+
+// SetNonStringPolicy sets how the requests with non-string values are cached, NonStringBypass by default.
+// It doesn't apply to the keys of SetKeyFunc and EnforceWithKey, nor with the cache disabled.
+func (e *CachedEnforcer) SetNonStringPolicy(policy NonStringPolicy) {
+	atomic.StoreInt32(&e.nonStringPolicy, int32(policy))
+}
+
+// checkNonStringParams returns ErrNonStringRequestValue for the first non-string value of params with NonStringError.
+func (e *CachedEnforcer) checkNonStringParams(params ...interface{}) error {
+	if NonStringPolicy(atomic.LoadInt32(&e.nonStringPolicy)) != NonStringError || e.keyFunc != nil {
+		return nil
+	}
+	for i, param := range params {
+		if _, ok := param.(string); !ok {
+			return fmt.Errorf("%w: value %d is a %T", ErrNonStringRequestValue, i, param)
+		}
+	}
+	return nil
+}
+
+// CacheNegativeResults determines whether to cache the decisions denying a request, which is the default.
+// When disabled, only the grants are cached and the denials are always evaluated again, so a grant
+// never waits for a cached denial to expire; this suits workloads where denials are rare.
+func (e *CachedEnforcer) CacheNegativeResults(enable bool) {
+	var skip int32
+	if !enable {
+		skip = 1
+	}
+	atomic.StoreInt32(&e.skipNegativeResults, skip)
+}
+
+// shouldStore reports whether a decision is to be cached.
+func (e *CachedEnforcer) shouldStore(res bool) bool {
+	return res || atomic.LoadInt32(&e.skipNegativeResults) == 0
+}
+
+// SetCacheReadOnly makes Enforce, its variants and BatchEnforce look up the cache without ever storing the decisions
+// they evaluate on a miss, for a replica sharing a cache, like a Redis one, which another enforcer populates.
+// WarmCache still stores the decisions, being called on purpose, and so do the ones of EnforceEx, which are
+// kept apart in memory. It is disabled by default.
+func (e *CachedEnforcer) SetCacheReadOnly(readOnly bool) {
+	var enabled int32
+	if readOnly {
+		enabled = 1
+	}
+	atomic.StoreInt32(&e.readOnly, enabled)
+}
+
+// writesBack reports whether the decisions evaluated on a miss are stored, unless SetCacheReadOnly is set.
+func (e *CachedEnforcer) writesBack() bool {
+	return atomic.LoadInt32(&e.readOnly) == 0
+}
+
+// SetCacheSampleRate makes only about 1 in n of the decisions evaluated on a miss stored, to bound the memory and
+// the lock time spent caching a traffic whose requests hardly ever recur. The decisions are sampled by a hash of
+// their key, so a request is either always stored or never. n of 1 or less stores them all, which is the default.
+// The decisions of WarmCache are not sampled.
+func (e *CachedEnforcer) SetCacheSampleRate(n int) {
+	if n > math.MaxInt32 {
+		n = math.MaxInt32
+	}
+	atomic.StoreInt32(&e.sampleRate, int32(n))
+}
+
+// sampled reports whether the decision for key is in the sample to store.
+func (e *CachedEnforcer) sampled(key string) bool {
+	n := atomic.LoadInt32(&e.sampleRate)
+	if n <= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()%uint64(n) == 0
+}
+
+// FailOpenOnCacheError controls whether a decision is still evaluated when the cache fails to look it up or
+// to store it, like a remote cache during an outage, which is the default. The failure is then counted in
+// CacheStats.Errors instead of being returned, so that a cache outage doesn't become an authorization outage.
+// With false, the error of the cache is returned as a *CacheError.
+func (e *CachedEnforcer) FailOpenOnCacheError(enable bool) {
+	if enable {
+		atomic.StoreInt32(&e.failClosed, 0)
+	} else {
+		atomic.StoreInt32(&e.failClosed, 1)
+	}
+}
+
+// SetDefaultDecisionOnError sets the decision returned along with the error when Enforce can't decide at all:
+// the cache fails, and the decision can't be evaluated either, because the evaluation fails too or
+// FailOpenOnCacheError(false) is set. The default is false, denying the request, some internal tools may prefer
+// true to stay available. The decision of an evaluation failing with a healthy cache is still false.
+func (e *CachedEnforcer) SetDefaultDecisionOnError(d bool) {
+	var decision int32
+	if d {
+		decision = 1
+	}
+	atomic.StoreInt32(&e.defaultDecision, decision)
+}
+
+// defaultDecisionOnError returns the decision set with SetDefaultDecisionOnError.
+func (e *CachedEnforcer) defaultDecisionOnError() bool {
+	return atomic.LoadInt32(&e.defaultDecision) != 0
+}
+
+// failOnCacheError reports whether an error of the cache fails the decision, or else counts it.
+func (e *CachedEnforcer) failOnCacheError() bool {
+	if atomic.LoadInt32(&e.failClosed) != 0 {
+		return true
+	}
+	atomic.AddUint64(&e.stats.Errors, 1)
+	return false
+}
+
 // Enforce decides whether a "subject" can access a "object" with the operation "action", input parameters are usually: (sub, obj, act).
 // if rvals is not string , ingore the cache
 func (e *CachedEnforcer) Enforce(rvals ...interface{}) (bool, error) {
+	return e.EnforceCtx(context.Background(), rvals...)
+}
+
+// EnforceCtx is like Enforce, but gives up with the error of ctx once it is done, before the cache lookup and before the evaluation.
+// Caches implementing persist.ContextCache are also given ctx to abort their own work.
+// With a persist.GetOrSetCache, the evaluation may be shared with concurrent callers, so ctx is only checked before the lookup.
+func (e *CachedEnforcer) EnforceCtx(ctx context.Context, rvals ...interface{}) (bool, error) {
+	res, _, err := e.cachedEnforce(ctx, enforceOptions{}, rvals...)
+	return res, err
+}
+
+// EnforceWithCacheInfo is like Enforce, but also reports whether the decision was returned from the cache.
+func (e *CachedEnforcer) EnforceWithCacheInfo(rvals ...interface{}) (bool, bool, error) {
+	res, outcome, err := e.cachedEnforce(context.Background(), enforceOptions{}, rvals...)
+	return res, outcome == cacheHit, err
+}
+
+// EnforceDetailed is like EnforceWithCacheInfo, but also reports whether the request bypassed the cache, so that
+// a miss, whose decision is then cached, can be told apart from a request which never went through the cache,
+// like one with non-string values, of a subject bypassing the cache, or with the cache disabled.
+// hit and bypassed are never both true.
+func (e *CachedEnforcer) EnforceDetailed(rvals ...interface{}) (res bool, hit bool, bypassed bool, err error) {
+	res, outcome, err := e.cachedEnforce(context.Background(), enforceOptions{}, rvals...)
+	return res, outcome == cacheHit, outcome == cacheBypass || outcome == cacheDisabled, err
+}
+
+// EnforceSafe is like Enforce, but also recovers from the panics outside the evaluation, like a custom cache, key func
+// or hook panicking, instead of crashing the caller. The error of a panic, of the evaluation too, matches
+// ErrEnforcePanicked and comes with the decision of SetDefaultDecisionOnError.
+// The concurrent callers sharing the evaluation of a panicking miss panic too, unless they also use EnforceSafe.
+func (e *CachedEnforcer) EnforceSafe(rvals ...interface{}) (res bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError{value: r}
+		}
+		if errors.Is(err, ErrEnforcePanicked) {
+			res = e.defaultDecisionOnError()
+		}
+	}()
+	res, _, err = e.cachedEnforce(context.Background(), enforceOptions{}, rvals...)
+	return res, err
+}
+
+// EnforceWithMatcher uses a custom matcher to decide whether a "subject" can access a "object" with the operation "action",
+// the decisions are cached with a hash of matcher in their keys, so different matchers don't share decisions.
+func (e *CachedEnforcer) EnforceWithMatcher(matcher string, rvals ...interface{}) (bool, error) {
+	res, _, err := e.cachedEnforce(context.Background(), enforceOptions{matcher: matcher}, rvals...)
+	return res, err
+}
+
+// EnforceWithTTL is like Enforce, but caches the decision for ttl seconds instead of the survival time set with
+// SetExpireTime, 0 means it never expires. A cached decision is returned whatever its survival time.
+// The decision is cached with the ttl of the caller evaluating it if concurrent callers miss it together.
+func (e *CachedEnforcer) EnforceWithTTL(ttl uint, rvals ...interface{}) (bool, error) {
+	res, _, err := e.cachedEnforce(context.Background(), enforceOptions{ttl: ttl, hasTTL: true}, rvals...)
+	return res, err
+}
+
+// EnforceCtxTTL combines EnforceCtx and EnforceWithTTL: it gives up with the error of ctx once it is done, before
+// the cache lookup and before the evaluation, and caches a miss for ttl seconds, 0 means it never expires.
+// The evaluation itself isn't interrupted, a decision evaluated while ctx gets done is still returned and cached,
+// unless the cache implements persist.ContextCache and gives up storing it with ctx.
+// With a persist.GetOrSetCache, the evaluation may be shared with concurrent callers, so ctx is only checked before the lookup.
+func (e *CachedEnforcer) EnforceCtxTTL(ctx context.Context, ttl uint, rvals ...interface{}) (bool, error) {
+	res, _, err := e.cachedEnforce(ctx, enforceOptions{ttl: ttl, hasTTL: true}, rvals...)
+	return res, err
+}
+
+// EnforceWithKey is like Enforce, but caches the decision under key instead of building the key from rvals,
+// for callers which already hold a canonical identity of the request, rvals are only evaluated on a miss.
+// The caller is responsible for key being unique to the request: requests sharing a key share the cached decision.
+// key is prefixed with the namespace like the built-in keys, but the key separator, key func and key hashing
+// don't apply, while the subjects bypassing the cache and SetResultCacheable still do.
+func (e *CachedEnforcer) EnforceWithKey(key string, rvals ...interface{}) (bool, error) {
+	res, _, err := e.cachedEnforce(context.Background(), enforceOptions{key: key, hasKey: true}, rvals...)
+	return res, err
+}
+
+// AgeNotCached is the age reported by EnforceWithAge for a decision evaluated without the cache,
+// because the cache is disabled or the request is not cacheable.
+const AgeNotCached time.Duration = -1
+
+// AgeUnknown is the age reported by EnforceWithAge for a cached decision whose age the cache can't report,
+// because it doesn't implement persist.AgeCache.
+const AgeUnknown time.Duration = -2
+
+// EnforceWithAge is like Enforce, but also reports how long ago the decision was cached, to re-check a decision
+// which is too old for a sensitive operation for instance. The age is 0 for a decision evaluated on a miss,
+// and AgeNotCached for a decision evaluated without the cache. The cache must implement persist.AgeCache
+// for the age of a hit to be known, the hits of another cache are reported with AgeUnknown, the caller may then
+// evaluate the request again with Enforcer.Enforce if it needs a fresh decision.
+func (e *CachedEnforcer) EnforceWithAge(rvals ...interface{}) (bool, time.Duration, error) {
+	res, outcome, err := e.cachedEnforce(context.Background(), enforceOptions{}, rvals...)
+	if err != nil {
+		return res, 0, err
+	}
+	switch outcome {
+	case cacheDisabled, cacheBypass:
+		return res, AgeNotCached, nil
+	case cacheMiss:
+		return res, 0, nil
+	}
+	if key, ok := e.getKey(rvals...); ok {
+		if age, err := e.getCachedAge(key); err == nil {
+			return res, age, nil
+		}
+	}
+	return res, AgeUnknown, nil
+}
+
+// getCachedAge returns how long ago the decision for key was cached.
+func (e *CachedEnforcer) getCachedAge(key string) (time.Duration, error) {
+	defer e.lockCache()()
+	c, ok := e.cache.(persist.AgeCache)
+	if !ok {
+		return 0, persist.ErrNoSuchKey
+	}
+	return c.Age(key)
+}
+
+// CacheError is returned by CachedEnforcer when the decision cache failed, rather than the evaluation of the policy,
+// so the decision may be trustworthy if it is evaluated again, without the cache for instance.
+// The failures of the cache are only returned when FailOpenOnCacheError is disabled.
+type CacheError struct {
+	// Op is the failed operation of the cache, like "get", "set" or "invalidate".
+	Op  string
+	Err error
+}
+
+func (e *CacheError) Error() string {
+	return "decision cache " + e.Op + " failed: " + e.Err.Error()
+}
+
+// Unwrap returns the error of the cache.
+func (e *CacheError) Unwrap() error {
+	return e.Err
+}
+
+// wrapCacheError returns err in a *CacheError for op, unless it is nil or already one.
+func wrapCacheError(op string, err error) error {
+	var cacheErr *CacheError
+	if err == nil || errors.As(err, &cacheErr) {
+		return err
+	}
+	return &CacheError{Op: op, Err: err}
+}
+
+// StaleDecisionError is returned by EnforceWithFallback along with a cached decision, when the evaluation of the
+// request failed with Err.
+type StaleDecisionError struct {
+	Err error
+}
+
+func (e *StaleDecisionError) Error() string {
+	return "the evaluation failed, returning the cached decision: " + e.Err.Error()
+}
+
+// Unwrap returns the error of the evaluation.
+func (e *StaleDecisionError) Unwrap() error {
+	return e.Err
+}
+
+// EnforceWithFallback is like Enforce, but if the evaluation fails on a miss, the cached decision is returned
+// if the cache still has it, even past its survival time, along with a *StaleDecisionError wrapping the error.
+// This favors availability over consistency, the caller decides whether to trust the decision.
+// The expired decisions are found if the cache implements persist.StaleCache and has not reclaimed them,
+// so the cache is looked up before the evaluation.
+func (e *CachedEnforcer) EnforceWithFallback(rvals ...interface{}) (bool, error) {
+	if err := e.checkInitialized(); err != nil {
+		return false, err
+	}
+	stale, staleErr := e.getStaleResult(rvals...)
+	res, _, err := e.cachedEnforce(context.Background(), enforceOptions{}, rvals...)
+	if err == nil || staleErr != nil {
+		return res, err
+	}
+	return stale, &StaleDecisionError{Err: err}
+}
+
+// getStaleResult returns the cached decision of a request, even past its survival time with a persist.StaleCache.
+func (e *CachedEnforcer) getStaleResult(rvals ...interface{}) (bool, error) {
 	if atomic.LoadInt32(&e.enableCache) == 0 {
-		return e.Enforcer.Enforce(rvals...)
+		return false, persist.ErrNoSuchKey
+	}
+	key, ok := e.getKey(rvals...)
+	if !ok {
+		return false, persist.ErrNoSuchKey
+	}
+	defer e.lockCache()()
+	if c, ok := e.cache.(persist.StaleCache); ok {
+		return c.GetStale(key)
 	}
+	return e.cache.Get(key)
+}
 
-	var key strings.Builder
-	for _, rval := range rvals {
-		if val, ok := rval.(string); ok {
-			key.WriteString(val)
-			key.WriteString("$$")
-		} else {
-			return e.Enforcer.Enforce(rvals...)
+// enforceOptions are the options of a call of cachedEnforce.
+type enforceOptions struct {
+	// matcher is the matcher to enforce with, the one of the model if empty.
+	matcher string
+	// ttl is the survival time of the decision if hasTTL is set, instead of the one of the enforcer.
+	ttl    uint
+	hasTTL bool
+	// key is the cache key of the request if hasKey is set, instead of the built-in one.
+	key    string
+	hasKey bool
+}
+
+// expireTime returns the survival time of the decision, expireTime is the one of the enforcer.
+func (o enforceOptions) expireTime(expireTime uint) uint {
+	if o.hasTTL {
+		return o.ttl
+	}
+	return expireTime
+}
+
+// cacheOutcome tells how the cache took part in a decision.
+type cacheOutcome int
+
+const (
+	// cacheDisabled means the decision was evaluated with the cache disabled.
+	cacheDisabled cacheOutcome = iota
+	// cacheBypass means the decision was evaluated without the cache because the request is not cacheable.
+	cacheBypass
+	// cacheHit means the decision was returned from the cache.
+	cacheHit
+	// cacheMiss means the decision was evaluated because it was not cached.
+	cacheMiss
+)
+
+// cachedEnforce enforces rvals with the options of the call.
+func (e *CachedEnforcer) cachedEnforce(ctx context.Context, opts enforceOptions, rvals ...interface{}) (bool, cacheOutcome, error) {
+	if err := e.checkInitialized(); err != nil {
+		return false, cacheDisabled, err
+	}
+	if err := ctx.Err(); err != nil {
+		return false, cacheDisabled, err
+	}
+
+	if atomic.LoadInt32(&e.enableCache) == 0 {
+		res, err := e.evaluate(opts.matcher, rvals...)
+		return res, cacheDisabled, err
+	}
+	if !opts.hasKey {
+		if err := e.checkNonStringParams(rvals...); err != nil {
+			return false, cacheBypass, err
 		}
 	}
 
-	if res, ok := e.getCachedResult(key.String()); ok {
+	key, ok := e.requestKey(opts, rvals...)
+	if !ok {
+		atomic.AddUint64(&e.stats.Bypasses, 1)
+		res, err := e.evaluate(opts.matcher, rvals...)
+		return res, cacheBypass, err
+	}
+	if opts.matcher != "" {
+		key += matcherKeySuffix(opts.matcher)
+	}
+	e.startReWarm()
+
+	if c, expireTime, ok := e.getOrSetCache(); ok {
+		return e.getOrSetEnforce(ctx, c, opts.expireTime(expireTime), key, opts.matcher, rvals...)
+	}
+
+	cacheFailed := false
+	if res, loaded, err := e.getCachedResult(ctx, key); err == nil && loaded {
+		// The cache evaluated the decision itself, see persist.LoadingCache.
+		e.miss(key, rvals)
+		return res, cacheMiss, nil
+	} else if err == nil {
+		e.hit(key)
+		e.touchCachedResult(key, opts)
+		return e.verifyHit(ctx, key, res, opts, rvals...), cacheHit, nil
+	} else if !errors.Is(err, persist.ErrNoSuchKey) {
+		if e.failOnCacheError() {
+			return e.defaultDecisionOnError(), cacheMiss, wrapCacheError("get", err)
+		}
+		cacheFailed = true
+	}
+
+	if err := ctx.Err(); err != nil {
+		return false, cacheMiss, err
+	}
+	// Concurrent misses of key share a single evaluation, the callers joining it count as hits.
+	outcome := cacheHit
+	v, err, _ := e.flights.Do(key, func() (interface{}, error) {
+		outcome = cacheMiss
+		e.miss(key, rvals)
+		start := time.Now()
+		res, err := e.evaluate(opts.matcher, rvals...)
+		cost := time.Since(start)
+		e.observeMissLatency(cost)
+		if err != nil {
+			return nil, err
+		}
+		decision := flightDecision{res: res}
+		if e.writesBack() && e.shouldStore(res) && e.sampled(key) {
+			decision.storeErr = e.setCachedResult(ctx, key, res, opts, cost)
+			if errors.Is(decision.storeErr, persist.ErrCacheFull) {
+				// The decision is still valid, it is only not cached.
+				atomic.AddUint64(&e.stats.Rejections, 1)
+				decision.storeErr = nil
+			} else if decision.storeErr == nil {
+				e.stored(key)
+			} else if !e.failOnCacheError() {
+				decision.storeErr = nil
+			}
+		}
+		return decision, nil
+	})
+	if err != nil && cacheFailed {
+		return e.defaultDecisionOnError(), outcome, err
+	} else if err != nil {
+		return false, outcome, err
+	}
+	decision := v.(flightDecision)
+	if outcome == cacheHit {
+		e.hit(key)
+		// The decision is valid even if the caller evaluating it failed to store it.
+		return decision.res, cacheHit, nil
+	}
+	return decision.res, cacheMiss, wrapCacheError("set", decision.storeErr)
+}
+
+// flightDecision is the result of an evaluation shared by concurrent misses.
+type flightDecision struct {
+	res      bool
+	storeErr error
+}
+
+// getOrSetCache returns the cache and the survival time of the decisions if the cache can compute them with
+// persist.GetOrSetCache. The cache must also be a persist.ConcurrentCache, as it is called without the lock
+// so that the evaluation doesn't hold it, and the denials must be cached, the decisions not sampled and the cache
+// not read-only, as GetOrSet stores every decision.
+func (e *CachedEnforcer) getOrSetCache() (persist.GetOrSetCache, uint, bool) {
+	if atomic.LoadInt32(&e.skipNegativeResults) != 0 || atomic.LoadInt32(&e.sampleRate) > 1 || !e.writesBack() {
+		return nil, 0, false
+	}
+	e.locker.RLock()
+	defer e.locker.RUnlock()
+	c, ok := e.cache.(persist.GetOrSetCache)
+	if !ok {
+		return nil, 0, false
+	}
+	if cc, ok := e.cache.(persist.ConcurrentCache); !ok || !cc.IsConcurrent() {
+		return nil, 0, false
+	}
+	return c, e.expireTime, true
+}
+
+// getOrSetEnforce enforces rvals through c.GetOrSet, so that concurrent misses of key are evaluated once.
+// The evaluation gives up if ctx is done, but GetOrSet itself can't be, as persist.GetOrSetCache takes no context.
+func (e *CachedEnforcer) getOrSetEnforce(ctx context.Context, c persist.GetOrSetCache, expireTime uint, key string, matcher string, rvals ...interface{}) (bool, cacheOutcome, error) {
+	outcome := cacheHit
+	// extra is passed to GetOrSet as is, so that the cost of the evaluation filled in by compute reaches the Set of
+	// the cache after it, like the cost passed to setCachedResult on the other path.
+	extra := []interface{}{e.jitteredTTL(key, expireTime), time.Duration(0)}
+	res, err := c.GetOrSet(key, func() (bool, error) {
+		outcome = cacheMiss
+		e.miss(key, rvals)
+		if err := ctx.Err(); err != nil {
+			return false, evalError{err}
+		}
+		start := time.Now()
+		res, err := e.evaluate(matcher, rvals...)
+		cost := time.Since(start)
+		e.observeMissLatency(cost)
+		extra[1] = cost
+		if err != nil {
+			return false, evalError{err}
+		}
 		return res, nil
+	}, extra...)
+	var evalErr evalError
+	if errors.Is(err, persist.ErrCacheFull) {
+		atomic.AddUint64(&e.stats.Rejections, 1)
+		return res, cacheMiss, nil
+	} else if errors.As(err, &evalErr) {
+		if outcome == cacheHit && isContextError(evalErr.err) && ctx.Err() == nil {
+			// The evaluation shared with a concurrent caller gave up with the context of that caller, not this one.
+			res, err := e.evaluate(matcher, rvals...)
+			return res, cacheMiss, err
+		}
+		return false, outcome, evalErr.err
+	} else if err != nil {
+		if e.failOnCacheError() {
+			return e.defaultDecisionOnError(), outcome, wrapCacheError("get or set", err)
+		}
+		// The cache failed rather than the evaluation, the decision is evaluated without it.
+		res, err := e.evaluate(matcher, rvals...)
+		if err != nil {
+			return e.defaultDecisionOnError(), cacheMiss, err
+		}
+		return res, cacheMiss, nil
+	}
+	if outcome == cacheHit {
+		// The decision was cached, or evaluated by a concurrent caller.
+		e.hit(key)
+		opts := enforceOptions{matcher: matcher, ttl: expireTime, hasTTL: true}
+		e.touchCachedResult(key, opts)
+		res = e.verifyHit(ctx, key, res, opts, rvals...)
+	} else {
+		e.stored(key)
+	}
+	return res, outcome, nil
+}
+
+// evalError wraps the error of an evaluation run by GetOrSet, to tell it apart from the errors of the cache
+// even if the cache wraps it, or returns it to the concurrent callers sharing the evaluation.
+type evalError struct {
+	err error
+}
+
+func (e evalError) Error() string {
+	return e.err.Error()
+}
+
+func (e evalError) Unwrap() error {
+	return e.err
+}
+
+// isContextError reports whether err is the error of a done context.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+type explainedDecision struct {
+	res     bool
+	explain []string
+	reason  Reason
+}
+
+// EnforceEx explains enforcement by informing matched rules, the decisions and their explanations are cached
+// apart from the ones of Enforce, keyed the same way.
+func (e *CachedEnforcer) EnforceEx(rvals ...interface{}) (bool, []string, error) {
+	decision, err := e.enforceExplained(rvals...)
+	return decision.res, decision.explain, err
+}
+
+// enforceExplained enforces rvals through the cache of EnforceEx, the explanation returned is a copy.
+func (e *CachedEnforcer) enforceExplained(rvals ...interface{}) (explainedDecision, error) {
+	if err := e.checkInitialized(); err != nil {
+		return explainedDecision{}, err
+	}
+	if atomic.LoadInt32(&e.enableCache) == 0 {
+		return e.evaluateEx(rvals...)
+	}
+
+	key, ok := e.getKey(rvals...)
+	if !ok {
+		atomic.AddUint64(&e.stats.Bypasses, 1)
+		return e.evaluateEx(rvals...)
+	}
+
+	if decision, err := e.explainCache.Get(key); err == nil {
+		e.hit(key)
+		decision.explain = append([]string(nil), decision.explain...)
+		return decision, nil
 	}
-	res, err := e.Enforcer.Enforce(rvals...)
+	e.miss(key, rvals)
+
+	decision, err := e.evaluateEx(rvals...)
 	if err != nil {
-		return false, err
+		return explainedDecision{explain: decision.explain}, err
+	}
+	if !e.shouldStore(decision.res) || !e.sampled(key) {
+		return decision, nil
+	}
+	stored := decision
+	stored.explain = append([]string(nil), decision.explain...)
+	if err := e.explainCache.Set(key, stored, e.jitteredTTL(key, e.ExpireTime())); err != nil {
+		return decision, wrapCacheError("set", err)
+	}
+	e.stored(key)
+	return decision, nil
+}
+
+// Reason tells why a decision of EnforceWithReason was made.
+type Reason int
+
+const (
+	// ReasonAllow means the request is allowed.
+	ReasonAllow Reason = iota
+	// ReasonNoMatch means the request is denied because no policy rule matches it.
+	ReasonNoMatch
+	// ReasonDeny means the request is denied by a matching policy rule, like an explicit deny rule.
+	ReasonDeny
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonAllow:
+		return "allow"
+	case ReasonNoMatch:
+		return "no matching policy"
+	case ReasonDeny:
+		return "deny"
+	}
+	return "Reason(" + strconv.Itoa(int(r)) + ")"
+}
+
+// EnforceWithReason is like Enforce, but also tells why the decision was made.
+// The reasons are derived from the effects of the policy rules matching the request, whatever the policy effect
+// and whether it explains the decision, and are cached along with the decisions of EnforceEx.
+// A denial without any matching deny rule, like the one of a decision transform, is a ReasonNoMatch.
+func (e *CachedEnforcer) EnforceWithReason(rvals ...interface{}) (bool, Reason, error) {
+	decision, err := e.enforceExplained(rvals...)
+	if err != nil {
+		return false, ReasonNoMatch, err
+	}
+	return decision.res, decision.reason, nil
+}
+
+// decisionReason tells why res was decided with the effects of the policy rules merged into it.
+func decisionReason(res bool, effects []effect.Effect) Reason {
+	if res {
+		return ReasonAllow
+	}
+	for _, eft := range effects {
+		if eft == effect.Deny {
+			return ReasonDeny
+		}
+	}
+	return ReasonNoMatch
+}
+
+// BatchEnforce enforces the requests in batches, only the requests missing from the cache are evaluated.
+// The cache is locked once for all the lookups and once for all the stores, which go through persist.BatchCache
+// if the cache implements it. The results are in the order of the requests.
+func (e *CachedEnforcer) BatchEnforce(requests [][]interface{}) ([]bool, error) {
+	if err := e.checkInitialized(); err != nil {
+		return nil, err
+	}
+	if atomic.LoadInt32(&e.enableCache) == 0 {
+		results, err := e.Enforcer.BatchEnforce(requests)
+		if err != nil {
+			return nil, err
+		}
+		for i := range results {
+			results[i] = e.transformDecision(requests[i], results[i])
+		}
+		return results, nil
+	}
+
+	results := make([]bool, len(requests))
+	keys := make([]string, len(requests))
+	cacheable := make([]bool, len(requests))
+	var lookups []string
+	for i, rvals := range requests {
+		if err := e.checkNonStringParams(rvals...); err != nil {
+			return nil, err
+		}
+		keys[i], cacheable[i] = e.getKey(rvals...)
+		if cacheable[i] {
+			lookups = append(lookups, keys[i])
+		}
+	}
+
+	unlock := e.lockCache()
+	cached, err := persist.GetMany(e.cache, lookups)
+	unlock()
+	if err != nil {
+		if e.failOnCacheError() {
+			return nil, wrapCacheError("get", err)
+		}
+		// All the requests are evaluated.
+		cached = nil
+	}
+
+	// pending are the requests to evaluate, a request missing with the key of an earlier one reuses its result.
+	var pending []int
+	pendingKeys := make(map[string]int)
+	var duplicates []int
+	for i := range requests {
+		if !cacheable[i] {
+			atomic.AddUint64(&e.stats.Bypasses, 1)
+			pending = append(pending, i)
+			continue
+		}
+		if res, ok := cached[keys[i]]; ok {
+			e.hit(keys[i])
+			results[i] = res
+			continue
+		}
+		e.miss(keys[i], requests[i])
+		if _, ok := pendingKeys[keys[i]]; ok {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		pendingKeys[keys[i]] = i
+		pending = append(pending, i)
+	}
+
+	if len(pending) == 0 {
+		return results, nil
+	}
+	misses := make([][]interface{}, len(pending))
+	for j, i := range pending {
+		misses[j] = requests[i]
+	}
+	missResults, err := e.Enforcer.BatchEnforce(misses)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]bool, len(pendingKeys))
+	for j, i := range pending {
+		results[i] = e.transformDecision(requests[i], missResults[j])
+		if cacheable[i] && e.writesBack() && e.shouldStore(results[i]) && e.sampled(keys[i]) {
+			entries[keys[i]] = results[i]
+		}
 	}
+	for _, i := range duplicates {
+		results[i] = results[pendingKeys[keys[i]]]
+	}
+	if len(entries) == 0 {
+		return results, nil
+	}
+	if err := e.setCachedResults(entries, enforceOptions{}); err != nil && e.failOnCacheError() {
+		return nil, wrapCacheError("set", err)
+	}
+	return results, nil
+}
 
-	e.setCachedResult(key.String(), res)
-	return res, nil
+// EnforceMatrix decides whether each of subs can access each of objs with act, for an access matrix for instance.
+// The result in row i and column j is the decision for subs[i] and objs[j], the requests are enforced together
+// with BatchEnforce, so only the decisions missing from the cache are evaluated.
+func (e *CachedEnforcer) EnforceMatrix(subs []string, objs []string, act string) ([][]bool, error) {
+	requests := make([][]interface{}, 0, len(subs)*len(objs))
+	for _, sub := range subs {
+		for _, obj := range objs {
+			requests = append(requests, []interface{}{sub, obj, act})
+		}
+	}
+	results, err := e.BatchEnforce(requests)
+	if err != nil {
+		return nil, err
+	}
+	matrix := make([][]bool, len(subs))
+	for i := range subs {
+		matrix[i] = results[i*len(objs) : (i+1)*len(objs)]
+	}
+	return matrix, nil
 }
 
-func (e *CachedEnforcer) getCachedResult(key string) (res bool, ok bool) {
+// WarmCache evaluates requests and caches their decisions, so that they are served from the cache right away,
+// for instance for the most common requests at startup. The requests which are not cacheable are skipped, like the
+// ones already cached if the cache implements persist.HasCache.
+// The requests are all evaluated even if some fail, the first error is returned.
+func (e *CachedEnforcer) WarmCache(requests [][]interface{}) error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
 	e.locker.RLock()
-	defer e.locker.RUnlock()
-	res, ok = e.m[key]
-	return
+	_, hasCache := e.cache.(persist.HasCache)
+	e.locker.RUnlock()
+	var firstErr error
+	entries := make(map[string]bool, len(requests))
+	for _, rvals := range requests {
+		key, ok := e.getKey(rvals...)
+		if !ok {
+			continue
+		}
+		if _, ok := entries[key]; ok || hasCache && e.isCached(key) {
+			continue
+		}
+		res, err := e.evaluate("", rvals...)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if e.shouldStore(res) {
+			entries[key] = res
+		}
+	}
+	if len(entries) == 0 {
+		return firstErr
+	}
+
+	if err := e.setCachedResults(entries, enforceOptions{}); err != nil && firstErr == nil {
+		firstErr = wrapCacheError("set", err)
+	}
+	return firstErr
 }
 
-func (e *CachedEnforcer) setCachedResult(key string, res bool) {
-	e.locker.Lock()
-	defer e.locker.Unlock()
-	e.m[key] = res
+// Preset caches decision for rvals without evaluating it, for deterministic tests of the cached paths or to import
+// the decisions computed elsewhere for instance. The decision is cached for ttl seconds, 0 means it never expires.
+// It returns ErrRequestNotCacheable if rvals don't go through the cache, see CacheKey.
+func (e *CachedEnforcer) Preset(rvals []interface{}, decision bool, ttl uint) error {
+	return e.PresetMany([][]interface{}{rvals}, []bool{decision}, ttl)
 }
 
-// InvalidateCache deletes all the existing cached decisions.
-func (e *CachedEnforcer) InvalidateCache() {
-	e.locker.Lock()
-	defer e.locker.Unlock()
-	e.m = make(map[string]bool)
+// PresetMany is like Preset for many requests, decisions[i] being the decision of requests[i]. Nothing is cached
+// if any request doesn't go through the cache.
+func (e *CachedEnforcer) PresetMany(requests [][]interface{}, decisions []bool, ttl uint) error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	if len(requests) != len(decisions) {
+		return fmt.Errorf("%d requests preset with %d decisions", len(requests), len(decisions))
+	}
+	entries := make(map[string]bool, len(requests))
+	for i, rvals := range requests {
+		key, ok := e.getKey(rvals...)
+		if !ok {
+			return fmt.Errorf("%w: %v", ErrRequestNotCacheable, rvals)
+		}
+		entries[key] = decisions[i]
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return wrapCacheError("set", e.setCachedResults(entries, enforceOptions{ttl: ttl, hasTTL: true}))
+}
+
+// SetOnMiss sets a hook called on every cache miss with the key and the request, before the request is evaluated,
+// to trace or prefetch for instance. It is called without holding any lock, so it may call back into the enforcer.
+// nil removes the hook. Call it before enforcing.
+func (e *CachedEnforcer) SetOnMiss(fn func(key string, rvals []interface{})) {
+	e.onMiss = fn
+}
+
+// SetDecisionTransform sets a hook transforming the evaluated decisions before they are returned and cached, to deny
+// the requests allowed with a warning during a freeze for instance. The cache hits return the transformed decision
+// as cached, so fn must be pure: the same request and raw decision must always give the same decision, or the cached
+// decisions go stale until they are invalidated. The decisions set with Preset are cached as they are.
+// nil removes the hook. Call it before enforcing.
+func (e *CachedEnforcer) SetDecisionTransform(fn func(rvals []interface{}, raw bool) bool) {
+	e.transform = fn
+}
+
+// transformDecision returns the decision res for rvals transformed by the hook set with SetDecisionTransform, if any.
+func (e *CachedEnforcer) transformDecision(rvals []interface{}, res bool) bool {
+	if e.transform == nil {
+		return res
+	}
+	return e.transform(rvals, res)
+}
+
+// evaluate enforces rvals with matcher, or the one of the model if empty, without the cache, and transforms the decision.
+func (e *CachedEnforcer) evaluate(matcher string, rvals ...interface{}) (bool, error) {
+	res, err := e.Enforcer.EnforceWithMatcher(matcher, rvals...)
+	if err != nil {
+		return res, err
+	}
+	return e.transformDecision(rvals, res), nil
+}
+
+// evaluateEx is like evaluate, with the explanation and the reason of the decision.
+func (e *CachedEnforcer) evaluateEx(rvals ...interface{}) (explainedDecision, error) {
+	explain := []string{}
+	res, effects, err := e.Enforcer.enforceEffects("", &explain, rvals...)
+	if err != nil {
+		return explainedDecision{res: res, explain: explain}, err
+	}
+	res = e.transformDecision(rvals, res)
+	return explainedDecision{res: res, explain: explain, reason: decisionReason(res, effects)}, nil
+}
+
+// hit counts a cache hit and notifies the observer, if any.
+func (e *CachedEnforcer) hit(key string) {
+	atomic.AddUint64(&e.stats.Hits, 1)
+	if e.observer != nil {
+		e.observer.OnHit(key)
+	}
+}
+
+// miss counts a cache miss, calls the miss hook and notifies the observer, if any.
+func (e *CachedEnforcer) miss(key string, rvals []interface{}) {
+	atomic.AddUint64(&e.stats.Misses, 1)
+	if e.onMiss != nil {
+		e.onMiss(key, rvals)
+	}
+	if e.observer != nil {
+		e.observer.OnMiss(key)
+	}
+}
+
+// stored notifies the observer, if any, that the decision for key is stored, it must be called without the lock.
+func (e *CachedEnforcer) stored(key string) {
+	if e.observer != nil {
+		e.observer.OnSet(key)
+	}
+}
+
+// CacheObserver is notified of the events of the decision cache of CachedEnforcer, to export metrics for instance.
+// Its methods are called without holding the lock of the enforcer, except OnEvict which the cache calls
+// with its own lock held, so OnEvict must not call back into the cache.
+type CacheObserver interface {
+	// OnHit is called when the decision for key is returned from the cache.
+	OnHit(key string)
+	// OnMiss is called when the decision for key is missing from the cache, before it is evaluated.
+	OnMiss(key string)
+	// OnSet is called when the decision for key is stored in the cache.
+	OnSet(key string)
+	// OnEvict is called when the cache evicts the decision for key to make room for another.
+	// It is only called by caches implementing persist.EvictNotifyCache.
+	OnEvict(key string)
+}
+
+// SetObserver sets the observer of the decision cache, nil removes it. Call it before enforcing.
+// The observer is kept when the cache is replaced with SetCache.
+func (e *CachedEnforcer) SetObserver(o CacheObserver) {
+	if e.checkInitialized() != nil {
+		return
+	}
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	e.observer = o
+	e.notifyEvictions()
+}
+
+// notifyEvictions passes the evictions of the cache on to the observer, it must be called with the write lock.
+func (e *CachedEnforcer) notifyEvictions() {
+	c, ok := e.cache.(persist.EvictNotifyCache)
+	if !ok {
+		return
+	}
+	if e.observer == nil {
+		c.SetOnEvict(nil)
+		return
+	}
+	c.SetOnEvict(e.observer.OnEvict)
+}
+
+// lockCache locks the cache for a lookup or a store and returns the function unlocking it.
+// A persist.ConcurrentCache only needs the read lock, which keeps it from being invalidated meanwhile.
+// Any other cache needs the write lock, even for a lookup, the cache may drop an expired item on Get.
+// Either lock also guards e.cache and e.expireTime, which are only replaced under the write lock.
+func (e *CachedEnforcer) lockCache() func() {
+	e.locker.RLock()
+	if c, ok := e.cache.(persist.ConcurrentCache); ok && c.IsConcurrent() {
+		return e.locker.RUnlock
+	}
+	e.locker.RUnlock()
+	e.locker.Lock()
+	return e.locker.Unlock
+}
+
+// EnableSlidingExpiration makes a cache hit reset the survival time of the decision, so that the decisions which
+// keep being hit stay cached while the idle ones expire. The cache must implement persist.TouchCache, the decisions
+// of other caches expire as usual. It is disabled by default.
+func (e *CachedEnforcer) EnableSlidingExpiration(enable bool) {
+	var enabled int32
+	if enable {
+		enabled = 1
+	}
+	atomic.StoreInt32(&e.slidingExpiration, enabled)
+}
+
+// VerifyCache makes a cache hit also evaluate the request again with probability sampleRate, from 0, which is the
+// default and never verifies, to 1, which verifies every hit. When the cached decision differs from the evaluated one,
+// the drift is reported to the hook set with SetOnDrift, the evaluated decision is returned and replaces the cached one.
+// It is a safety net against the gaps of the invalidation, at the cost of the evaluations of the verified hits.
+func (e *CachedEnforcer) VerifyCache(sampleRate float64) {
+	e.verifyRate.Store(sampleRate)
+}
+
+// SetOnDrift sets a hook called when VerifyCache finds a cached decision differing from the evaluated one, to alarm
+// on a missed invalidation for instance. It is called without holding any lock, so it may call back into the enforcer.
+// nil removes the hook. Call it before enforcing.
+func (e *CachedEnforcer) SetOnDrift(fn func(key string, cached, live bool)) {
+	e.onDrift = fn
+}
+
+// deleteCachedResult deletes the decision of key, ignoring the errors.
+func (e *CachedEnforcer) deleteCachedResult(key string) {
+	defer e.lockCache()()
+	_ = e.cache.Delete(key)
+}
+
+// verifyHit evaluates the request of a hit again if it is sampled by VerifyCache, and returns the decision to return,
+// correcting the cached one if they differ. The cached decision is returned if the evaluation fails.
+func (e *CachedEnforcer) verifyHit(ctx context.Context, key string, cached bool, opts enforceOptions, rvals ...interface{}) bool {
+	if rate, _ := e.verifyRate.Load().(float64); rate <= 0 || rate < 1 && rand.Float64() >= rate {
+		return cached
+	}
+	start := time.Now()
+	live, err := e.evaluate(opts.matcher, rvals...)
+	cost := time.Since(start)
+	if err != nil || live == cached {
+		return cached
+	}
+	if e.onDrift != nil {
+		e.onDrift(key, cached, live)
+	}
+	if !e.writesBack() {
+		return live
+	}
+	// The cached decision is deleted if the evaluated one is not to be cached, like a denial.
+	if e.shouldStore(live) && e.setCachedResult(ctx, key, live, opts, cost) == nil {
+		e.stored(key)
+	} else {
+		e.deleteCachedResult(key)
+	}
+	return live
+}
+
+// touchCachedResult resets the survival time of the decision hit for key with sliding expiration.
+func (e *CachedEnforcer) touchCachedResult(key string, opts enforceOptions) {
+	if atomic.LoadInt32(&e.slidingExpiration) == 0 {
+		return
+	}
+	defer e.lockCache()()
+	if c, ok := e.cache.(persist.TouchCache); ok {
+		// The decision is still valid if it can't be touched.
+		_ = c.Touch(key, e.jitteredTTL(key, opts.expireTime(e.expireTime)))
+	}
+}
+
+// getCachedResult returns the decision cached for key, and whether the cache evaluated it for this call.
+func (e *CachedEnforcer) getCachedResult(ctx context.Context, key string) (res bool, loaded bool, err error) {
+	defer e.lockCache()()
+	if c, ok := e.cache.(persist.LoadingCache); ok {
+		return c.GetLoad(ctx, key)
+	}
+	if c, ok := e.cache.(persist.ContextCache); ok {
+		res, err = c.GetCtx(ctx, key)
+		return res, false, err
+	}
+	res, err = e.cache.Get(key)
+	return res, false, err
+}
+
+// setCachedResults stores the decisions of entries with the current survival time, the decisions being grouped
+// by their survival time with SetTTLJitter.
+func (e *CachedEnforcer) setCachedResults(entries map[string]bool, opts enforceOptions) error {
+	unlock := e.lockCache()
+	expireTime := opts.expireTime(e.expireTime)
+	var err error
+	if jitter, _ := e.ttlJitter.Load().(ttlJitter); jitter.fraction > 0 && expireTime > 0 {
+		groups := make(map[uint]map[string]bool)
+		for key, res := range entries {
+			ttl := jitter.apply(key, expireTime)
+			if groups[ttl] == nil {
+				groups[ttl] = make(map[string]bool)
+			}
+			groups[ttl][key] = res
+		}
+		for ttl, group := range groups {
+			// A failure of the cache is reported rather than a decision which doesn't fit.
+			if groupErr := persist.SetMany(e.cache, group, ttl); groupErr != nil && (err == nil || errors.Is(err, persist.ErrCacheFull)) {
+				err = groupErr
+			}
+		}
+	} else {
+		err = persist.SetMany(e.cache, entries, expireTime)
+	}
+	unlock()
+	if errors.Is(err, persist.ErrCacheFull) {
+		// Which decisions were not cached is not known, so none is reported to the observer.
+		atomic.AddUint64(&e.stats.Rejections, 1)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if e.observer != nil {
+		for key := range entries {
+			e.observer.OnSet(key)
+		}
+	}
+	return nil
+}
+
+// setCachedResult stores the decision for key with the survival time of opts, and with the time it took to evaluate
+// as its cost, for caches like cache.CostCache. Set is called even on a persist.SetWithTTLCache, whose SetWithTTL has
+// no room for the cost, so that the cost reaches a cache.CostCache wrapped in a cache.ShardedCache for instance.
+func (e *CachedEnforcer) setCachedResult(ctx context.Context, key string, res bool, opts enforceOptions, cost time.Duration) error {
+	defer e.lockCache()()
+	expireTime := e.jitteredTTL(key, opts.expireTime(e.expireTime))
+	if c, ok := e.cache.(persist.ContextCache); ok {
+		return c.SetCtx(ctx, key, res, expireTime, cost)
+	}
+	return e.cache.Set(key, res, expireTime, cost)
+}
+
+// SetExpireTime sets the survival time in seconds of the cached decisions, 0 means they never expire.
+// It is safe to call while enforcing, the decisions already cached keep their survival time.
+func (e *CachedEnforcer) SetExpireTime(expireTime uint) {
+	if e.checkInitialized() != nil {
+		return
+	}
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	e.expireTime = expireTime
+}
+
+// SetTTLJitter spreads the survival times of the cached decisions randomly within ±fraction of their survival time,
+// so that the decisions cached together, by WarmCache for instance, don't all expire at once and miss together.
+// A fraction of 0, the default, disables it, and the decisions which never expire are never jittered.
+// The survival times are whole seconds of at least 1, so a survival time of a few seconds is barely spread.
+// It is safe to call while enforcing, the decisions already cached keep their survival time.
+func (e *CachedEnforcer) SetTTLJitter(fraction float64) {
+	if e.checkInitialized() != nil {
+		return
+	}
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	jitter, _ := e.ttlJitter.Load().(ttlJitter)
+	jitter.fraction = fraction
+	e.ttlJitter.Store(jitter)
+}
+
+// SetTTLJitterSeed makes the jitter of SetTTLJitter deterministic per key, derived from the key and seed,
+// for reproducible tests for instance, instead of random.
+func (e *CachedEnforcer) SetTTLJitterSeed(seed uint64) {
+	if e.checkInitialized() != nil {
+		return
+	}
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	jitter, _ := e.ttlJitter.Load().(ttlJitter)
+	jitter.seed, jitter.seeded = seed, true
+	e.ttlJitter.Store(jitter)
+}
+
+// ttlJitter is the jitter of the survival times set with SetTTLJitter and SetTTLJitterSeed.
+type ttlJitter struct {
+	fraction float64
+	seed     uint64
+	seeded   bool
+}
+
+// apply returns the survival time ttl of key, jittered.
+func (j ttlJitter) apply(key string, ttl uint) uint {
+	var r float64
+	if j.seeded {
+		h := fnv.New64a()
+		var seed [8]byte
+		binary.LittleEndian.PutUint64(seed[:], j.seed)
+		_, _ = h.Write(seed[:])
+		_, _ = h.Write([]byte(key))
+		r = float64(h.Sum64()>>11) / (1 << 53)
+	} else {
+		r = rand.Float64()
+	}
+	jittered := math.Round(float64(ttl) * (1 + j.fraction*(2*r-1)))
+	if jittered < 1 {
+		return 1
+	} else if jittered > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint(jittered)
+}
+
+// jitteredTTL returns the survival time ttl of key, jittered with SetTTLJitter.
+func (e *CachedEnforcer) jitteredTTL(key string, ttl uint) uint {
+	if jitter, _ := e.ttlJitter.Load().(ttlJitter); jitter.fraction > 0 && ttl > 0 {
+		return jitter.apply(key, ttl)
+	}
+	return ttl
+}
+
+// ExpireTime returns the survival time in seconds of the cached decisions set with SetExpireTime,
+// 0 means they never expire, which is the default.
+func (e *CachedEnforcer) ExpireTime() uint {
+	if e.checkInitialized() != nil {
+		return 0
+	}
+	e.locker.RLock()
+	defer e.locker.RUnlock()
+	return e.expireTime
+}
+
+// SetCache replaces the decision cache, DefaultCache is used by default. c must not be nil and the enforcer must be
+// initialized, SetCache panics otherwise, see SetCacheSafe for an error instead.
+// It is safe to call while enforcing, the decisions of the previous cache are not carried over.
+func (e *CachedEnforcer) SetCache(c persist.Cache) {
+	if err := e.SetCacheSafe(c); err != nil {
+		panic(err)
+	}
+}
+
+// SetCacheSafe is like SetCache, but returns an error if c is nil, or a nil pointer, leaving the cache unchanged.
+func (e *CachedEnforcer) SetCacheSafe(c persist.Cache) error {
+	_, err := e.SwapCache(c)
+	return err
+}
+
+// SwapCache replaces the decision cache by c under the write lock and returns the previous one, to close it for
+// instance, so that a cache warmed in the background can take over without the misses of clearing the live one.
+// Each lookup or store of a concurrent enforcement uses either the previous cache or c, a decision missed in the
+// previous cache may be stored in c.
+// It returns an error if c is nil, or a nil pointer, leaving the cache unchanged.
+func (e *CachedEnforcer) SwapCache(c persist.Cache) (persist.Cache, error) {
+	if err := e.checkInitialized(); err != nil {
+		return nil, err
+	}
+	if isNilCache(c) {
+		return nil, errors.New("the decision cache must not be nil")
+	}
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	old := e.cache
+	e.cache = c
+	e.evictionBase = persist.EvictionStats{}
+	if e.observer != nil {
+		e.notifyEvictions()
+	}
+	return old, nil
+}
+
+func isNilCache(c persist.Cache) bool {
+	if c == nil {
+		return true
+	}
+	v := reflect.ValueOf(c)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// DefaultKeySeparator is the separator of the request values in the cache keys.
+const DefaultKeySeparator = persist.DefaultKeySeparator
+
+// SetKeySeparator sets the separator of the request values in the cache keys, DefaultKeySeparator is used by default.
+// Each value is prefixed with its length, so keys of different requests never collide whatever the separator.
+// The decisions cached with the previous separator are no longer hit, call it before enforcing.
+func (e *CachedEnforcer) SetKeySeparator(sep string) {
+	e.keySeparator = sep
+}
+
+// SetCacheNamespace prefixes the keys of all the cached decisions with ns followed by a colon, so that enforcers
+// of different models can share a cache, like a Redis one, without reading each other's decisions.
+// InvalidateCache then only deletes the keys of the namespace if the cache implements persist.PrefixCache
+// or persist.KeysCache, it clears the whole cache otherwise. An empty ns removes the prefix.
+// The decisions cached in the previous namespace are no longer hit, call it before enforcing.
+func (e *CachedEnforcer) SetCacheNamespace(ns string) {
+	if ns == "" {
+		e.namespace = ""
+		return
+	}
+	e.namespace = ns + ":"
+}
+
+// SetKeyFunc replaces the built-in cache key of a request by the one of fn, nil restores the built-in key.
+// A request for which fn returns false is not cacheable and bypasses the cache.
+// Requests sharing a key share the cached decision, so fn must only map together requests with the same decision.
+func (e *CachedEnforcer) SetKeyFunc(fn func(rvals ...interface{}) (string, bool)) {
+	e.keyFunc = fn
+}
+
+// HashKeys makes the cache store and look up the decisions by hasher(key) instead of their key, nil disables hashing.
+// This saves memory when the request values are long, at the risk of two requests sharing a decision if their keys
+// collide, which is negligible with SHA256KeyHasher. Hashed keys have no subject prefix, so InvalidateCacheForSubject
+// deletes all the cached decisions. The decisions cached before the change are no longer hit, call it before enforcing.
+func (e *CachedEnforcer) HashKeys(hasher func(key string) string) {
+	e.keyHasher = hasher
+}
+
+// KeyOverflow tells what is done with the requests whose key is longer than the length set with SetMaxKeyLength.
+type KeyOverflow int
+
+const (
+	// KeyOverflowBypass evaluates the requests with a long key without the cache, which is the default.
+	KeyOverflowBypass KeyOverflow = iota
+	// KeyOverflowHash caches the decisions of the requests with a long key by the SHA256KeyHasher hash of their key.
+	KeyOverflowHash
+)
+
+// SetMaxKeyLength limits the length of the keys built from the request values to n bytes, to protect the cache
+// from the huge keys of long request values, 0 means no limit, which is the default. The requests with a longer key
+// are handled according to SetKeyOverflow, unless the keys are hashed with HashKeys, which already bounds them.
+// The limit applies to the keys of SetKeyFunc but not to the ones of EnforceWithKey. Call it before enforcing.
+func (e *CachedEnforcer) SetMaxKeyLength(n int) {
+	e.maxKeyLength = n
+}
+
+// SetKeyOverflow sets what is done with the requests whose key is longer than the limit of SetMaxKeyLength,
+// KeyOverflowBypass by default. The hashed keys have no subject prefix like with HashKeys, so with KeyOverflowHash,
+// InvalidateCacheForSubject and the other partial invalidations delete all the cached decisions. Call it before enforcing.
+func (e *CachedEnforcer) SetKeyOverflow(overflow KeyOverflow) {
+	e.keyOverflow = overflow
+}
+
+// hashesKeys reports whether some keys may be hashed, with HashKeys or KeyOverflowHash.
+func (e *CachedEnforcer) hashesKeys() bool {
+	return e.keyHasher != nil || e.maxKeyLength > 0 && e.keyOverflow == KeyOverflowHash
+}
+
+// SHA256KeyHasher is a key hasher for HashKeys, returning the hex-encoded SHA-256 digest of key.
+func SHA256KeyHasher(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// BypassCacheForSubjects makes the requests whose first value is one of subs bypass the cache, so their decisions
+// are always evaluated fresh. It replaces the subjects set by a previous call, none means no subject bypasses the cache.
+func (e *CachedEnforcer) BypassCacheForSubjects(subs ...string) {
+	set := make(map[string]struct{}, len(subs))
+	for _, sub := range subs {
+		set[sub] = struct{}{}
+	}
+	e.bypassSubjects.Store(set)
+}
+
+// bypassesCache reports whether the subject of a request bypasses the cache.
+func (e *CachedEnforcer) bypassesCache(params ...interface{}) bool {
+	if len(params) == 0 {
+		return false
+	}
+	sub, ok := params[0].(string)
+	if !ok {
+		return false
+	}
+	set, _ := e.bypassSubjects.Load().(map[string]struct{})
+	_, ok = set[sub]
+	return ok
+}
+
+// SetResultCacheable sets a function telling whether the decision of a request may be cached, nil caches them all.
+// A request for which fn returns false bypasses the cache, like a request whose decision changes over time because
+// the matcher calls a time-based function added with AddFunction: caching such a decision would serve it stale.
+// The matcher is not inspected, so fn must flag these requests itself. Call it before enforcing.
+func (e *CachedEnforcer) SetResultCacheable(fn func(rvals []interface{}) bool) {
+	e.resultCacheable = fn
+}
+
+// CacheKey returns the key under which the decision of a request is cached by Enforce, or false if the request
+// bypasses the cache, according to the key separator, the key func and the key hashing. It is meant for debugging.
+// The decisions of EnforceWithMatcher are cached under the key followed by a suffix for the matcher.
+func (e *CachedEnforcer) CacheKey(rvals ...interface{}) (string, bool) {
+	return e.getKey(rvals...)
+}
+
+// IsCached reports whether the decision of a request is in the cache, without evaluating it.
+// The cache is probed with persist.HasCache if implemented, so that an LRU cache for instance doesn't count it as a use.
+func (e *CachedEnforcer) IsCached(rvals ...interface{}) bool {
+	key, ok := e.getKey(rvals...)
+	if !ok {
+		return false
+	}
+	return e.isCached(key)
+}
+
+// isCached reports whether key is in the cache.
+func (e *CachedEnforcer) isCached(key string) bool {
+	if e.checkInitialized() != nil {
+		return false
+	}
+	defer e.lockCache()()
+	if c, ok := e.cache.(persist.HasCache); ok {
+		return c.Has(key)
+	}
+	_, err := e.cache.Get(key)
+	return err == nil
+}
+
+// IsCacheable reports whether the decision of a request would go through the cache rather than bypass it,
+// according to the request values, the key func, the subjects bypassing the cache and SetResultCacheable.
+func (e *CachedEnforcer) IsCacheable(rvals ...interface{}) bool {
+	_, ok := e.getKey(rvals...)
+	return ok
+}
+
+// requestKey returns the key of the call of cachedEnforce, or false if the request bypasses the cache.
+func (e *CachedEnforcer) requestKey(opts enforceOptions, rvals ...interface{}) (string, bool) {
+	if !opts.hasKey {
+		return e.getKey(rvals...)
+	}
+	if !e.cacheable(rvals...) {
+		return "", false
+	}
+	return e.namespace + opts.key, true
+}
+
+// cacheable reports whether a request goes through the cache according to the subjects bypassing the cache
+// and SetResultCacheable.
+func (e *CachedEnforcer) cacheable(params ...interface{}) bool {
+	if e.bypassesCache(params...) {
+		return false
+	}
+	return e.resultCacheable == nil || e.resultCacheable(params)
+}
+
+func (e *CachedEnforcer) getKey(params ...interface{}) (string, bool) {
+	if !e.cacheable(params...) {
+		return "", false
+	}
+	key, ok := e.buildKey(params...)
+	if !ok {
+		return "", false
+	}
+	if e.maxKeyLength > 0 && len(key) > e.maxKeyLength && e.keyHasher == nil {
+		if e.keyOverflow != KeyOverflowHash {
+			return "", false
+		}
+		key = SHA256KeyHasher(key)
+	} else if e.keyHasher != nil {
+		key = e.keyHasher(key)
+	}
+	return e.namespace + key, true
+}
+
+func (e *CachedEnforcer) buildKey(params ...interface{}) (string, bool) {
+	if e.keyFunc != nil {
+		return e.keyFunc(params...)
+	}
+	var key strings.Builder
+	for _, param := range params {
+		if val, ok := param.(string); ok {
+			e.writeKeySegment(&key, val)
+		} else if NonStringPolicy(atomic.LoadInt32(&e.nonStringPolicy)) == NonStringCoerce {
+			e.writeKeySegment(&key, serializeParam(param))
+		} else {
+			return "", false
+		}
+	}
+	return key.String(), true
+}
+
+// writeKeySegment writes a request value to the key as "<length>:<value><separator>".
+func (e *CachedEnforcer) writeKeySegment(key *strings.Builder, segment string) {
+	key.WriteString(strconv.Itoa(len(segment)))
+	key.WriteByte(':')
+	key.WriteString(segment)
+	key.WriteString(e.keySeparator)
+}
+
+// matcherKeySuffix returns the suffix of the keys of the decisions made with matcher, as "#<FNV-1a hash of matcher>".
+// Segments of built-in keys start with their length, so the suffix can't be mistaken for a request value,
+// and InvalidateCacheForSubject still matches the keys by their prefix.
+func matcherKeySuffix(matcher string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(matcher))
+	return "#" + strconv.FormatUint(h.Sum64(), 16)
+}
+
+// serializeParam returns the Go-syntax representation of a non-string request value, following pointers.
+func serializeParam(param interface{}) string {
+	v := reflect.ValueOf(param)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%T(%#v)", v.Interface(), v.Interface())
+}
+
+// CacheLen returns the number of cached decisions, or -1 if the cache does not implement persist.LenCache.
+func (e *CachedEnforcer) CacheLen() int {
+	if e.checkInitialized() != nil {
+		return 0
+	}
+	e.locker.RLock()
+	defer e.locker.RUnlock()
+	if c, ok := e.cache.(persist.LenCache); ok {
+		return c.Len()
+	}
+	return -1
+}
+
+// CacheStats holds the counters of the decision cache of CachedEnforcer.
+type CacheStats struct {
+	// Hits is the number of decisions returned from the cache.
+	Hits uint64
+	// Misses is the number of decisions evaluated because they were not cached.
+	Misses uint64
+	// Bypasses is the number of decisions evaluated without the cache because the request was not cacheable,
+	// like a request with a non-string value or a subject bypassing the cache.
+	Bypasses uint64
+	// Evictions is the number of decisions the cache dropped to make room for new ones.
+	// It is only counted by caches implementing persist.EvictionStatsCache.
+	Evictions uint64
+	// Expirations is the number of expired decisions the cache reclaimed.
+	// It is only counted by caches implementing persist.EvictionStatsCache.
+	Expirations uint64
+	// Errors is the number of lookups and stores the cache failed while the decisions were evaluated regardless,
+	// see FailOpenOnCacheError.
+	Errors uint64
+	// Rejections is the number of times decisions were not cached because the cache was full,
+	// see persist.ErrCacheFull.
+	Rejections uint64
+}
+
+// missLatencyBounds are the upper bounds of the buckets of MissLatencyHistogram but the last one, which has none.
+var missLatencyBounds = [...]time.Duration{
+	10 * time.Microsecond, 50 * time.Microsecond, 100 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second,
+}
+
+// Bucket is a bucket of the histogram returned by MissLatencyHistogram.
+type Bucket struct {
+	// UpperBound is the longest duration counted in the bucket, the durations up to the bound of the previous bucket
+	// being counted there. It is 0 for the last bucket, which counts the durations longer than all the bounds.
+	UpperBound time.Duration
+	// Count is the number of durations counted in the bucket.
+	Count uint64
+}
+
+// MissLatencyHistogram returns a snapshot of the histogram of how long the decisions missed in the cache took to be
+// evaluated, failed evaluations included, to choose their survival time for instance. The buckets are sorted by
+// bound, from 10µs to 1s, followed by the unbounded one. It is reset with ResetCacheStats.
+func (e *CachedEnforcer) MissLatencyHistogram() []Bucket {
+	buckets := make([]Bucket, len(e.missLatency))
+	for i := range buckets {
+		if i < len(missLatencyBounds) {
+			buckets[i].UpperBound = missLatencyBounds[i]
+		}
+		buckets[i].Count = atomic.LoadUint64(&e.missLatency[i])
+	}
+	return buckets
+}
+
+// observeMissLatency counts the evaluation time of a miss in its bucket.
+func (e *CachedEnforcer) observeMissLatency(d time.Duration) {
+	i := 0
+	for i < len(missLatencyBounds) && d > missLatencyBounds[i] {
+		i++
+	}
+	atomic.AddUint64(&e.missLatency[i], 1)
+}
+
+// CacheStats returns a snapshot of the decision cache counters.
+func (e *CachedEnforcer) CacheStats() CacheStats {
+	stats := CacheStats{
+		Hits:       atomic.LoadUint64(&e.stats.Hits),
+		Misses:     atomic.LoadUint64(&e.stats.Misses),
+		Bypasses:   atomic.LoadUint64(&e.stats.Bypasses),
+		Errors:     atomic.LoadUint64(&e.stats.Errors),
+		Rejections: atomic.LoadUint64(&e.stats.Rejections),
+	}
+	if e.checkInitialized() != nil {
+		return stats
+	}
+	e.locker.RLock()
+	defer e.locker.RUnlock()
+	if c, ok := e.cache.(persist.EvictionStatsCache); ok {
+		evictions := c.EvictionStats()
+		stats.Evictions = evictions.Evictions - e.evictionBase.Evictions
+		stats.Expirations = evictions.Expirations - e.evictionBase.Expirations
+	}
+	return stats
+}
+
+// ResetCacheStats sets all the decision cache counters back to 0, along with the histogram of MissLatencyHistogram.
+func (e *CachedEnforcer) ResetCacheStats() {
+	atomic.StoreUint64(&e.stats.Hits, 0)
+	atomic.StoreUint64(&e.stats.Misses, 0)
+	atomic.StoreUint64(&e.stats.Bypasses, 0)
+	atomic.StoreUint64(&e.stats.Errors, 0)
+	atomic.StoreUint64(&e.stats.Rejections, 0)
+	for i := range e.missLatency {
+		atomic.StoreUint64(&e.missLatency[i], 0)
+	}
+	if e.checkInitialized() != nil {
+		return
+	}
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	if c, ok := e.cache.(persist.EvictionStatsCache); ok {
+		e.evictionBase = c.EvictionStats()
+	}
+}
+
+// AddPolicy adds an authorization rule to the current policy, the cached decisions are invalidated if the rule is added.
+func (e *CachedEnforcer) AddPolicy(params ...interface{}) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.AddPolicy(params...)
+	})
+}
+
+// AddPolicies adds authorization rules to the current policy, the cached decisions are invalidated if the rules are added.
+func (e *CachedEnforcer) AddPolicies(rules [][]string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.AddPolicies(rules)
+	})
+}
+
+// RemovePolicy removes an authorization rule from the current policy, the cached decisions are invalidated if the rule is removed.
+// A cached decision is keyed by the request rather than by the rules it depends on, so all of them are invalidated.
+func (e *CachedEnforcer) RemovePolicy(params ...interface{}) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.RemovePolicy(params...)
+	})
+}
+
+// RemovePolicies removes authorization rules from the current policy, the cached decisions are invalidated if the rules are removed.
+func (e *CachedEnforcer) RemovePolicies(rules [][]string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.RemovePolicies(rules)
+	})
+}
+
+// UpdatePolicy updates an authorization rule of the current policy, the cached decisions are invalidated if the rule is updated.
+func (e *CachedEnforcer) UpdatePolicy(oldPolicy []string, newPolicy []string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.UpdatePolicy(oldPolicy, newPolicy)
+	})
+}
+
+// UpdatePolicies updates authorization rules of the current policy, the cached decisions are invalidated if the rules are updated.
+func (e *CachedEnforcer) UpdatePolicies(oldPolices [][]string, newPolicies [][]string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.UpdatePolicies(oldPolices, newPolicies)
+	})
+}
+
+// RemoveFilteredPolicy removes authorization rules matching the field filters from the current policy,
+// the cached decisions are invalidated if any rule is removed.
+func (e *CachedEnforcer) RemoveFilteredPolicy(fieldIndex int, fieldValues ...string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.RemoveFilteredPolicy(fieldIndex, fieldValues...)
+	})
+}
+
+// AddNamedPolicy adds an authorization rule to the named policy ptype, the cached decisions are invalidated if the rule is added.
+func (e *CachedEnforcer) AddNamedPolicy(ptype string, params ...interface{}) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.AddNamedPolicy(ptype, params...)
+	})
+}
+
+// AddNamedPolicies adds authorization rules to the named policy ptype, the cached decisions are invalidated if the rules are added.
+func (e *CachedEnforcer) AddNamedPolicies(ptype string, rules [][]string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.AddNamedPolicies(ptype, rules)
+	})
+}
+
+// RemoveNamedPolicy removes an authorization rule from the named policy ptype, the cached decisions are invalidated if the rule is removed.
+func (e *CachedEnforcer) RemoveNamedPolicy(ptype string, params ...interface{}) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.RemoveNamedPolicy(ptype, params...)
+	})
+}
+
+// RemoveNamedPolicies removes authorization rules from the named policy ptype, the cached decisions are invalidated if the rules are removed.
+func (e *CachedEnforcer) RemoveNamedPolicies(ptype string, rules [][]string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.RemoveNamedPolicies(ptype, rules)
+	})
+}
+
+// UpdateNamedPolicy updates an authorization rule of the named policy ptype, the cached decisions are invalidated if the rule is updated.
+func (e *CachedEnforcer) UpdateNamedPolicy(ptype string, oldPolicy []string, newPolicy []string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.UpdateNamedPolicy(ptype, oldPolicy, newPolicy)
+	})
+}
+
+// UpdateNamedPolicies updates authorization rules of the named policy ptype, the cached decisions are invalidated if the rules are updated.
+func (e *CachedEnforcer) UpdateNamedPolicies(ptype string, oldPolices [][]string, newPolicies [][]string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.UpdateNamedPolicies(ptype, oldPolices, newPolicies)
+	})
+}
+
+// RemoveFilteredNamedPolicy removes the authorization rules of ptype matching the filter, the cached decisions are invalidated if any rule is removed.
+func (e *CachedEnforcer) RemoveFilteredNamedPolicy(ptype string, fieldIndex int, fieldValues ...string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.RemoveFilteredNamedPolicy(ptype, fieldIndex, fieldValues...)
+	})
+}
+
+// AddGroupingPolicy adds a role inheritance rule to the current policy, the cached decisions are invalidated if the rule is added.
+func (e *CachedEnforcer) AddGroupingPolicy(params ...interface{}) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.AddGroupingPolicy(params...)
+	})
+}
+
+// AddGroupingPolicies adds role inheritance rules to the current policy, the cached decisions are invalidated if the rules are added.
+func (e *CachedEnforcer) AddGroupingPolicies(rules [][]string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.AddGroupingPolicies(rules)
+	})
+}
+
+// RemoveGroupingPolicy removes a role inheritance rule from the current policy, the cached decisions are invalidated if the rule is removed.
+func (e *CachedEnforcer) RemoveGroupingPolicy(params ...interface{}) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.RemoveGroupingPolicy(params...)
+	})
+}
+
+// RemoveGroupingPolicies removes role inheritance rules from the current policy, the cached decisions are invalidated if the rules are removed.
+func (e *CachedEnforcer) RemoveGroupingPolicies(rules [][]string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.RemoveGroupingPolicies(rules)
+	})
+}
+
+// UpdateGroupingPolicy updates a role inheritance rule of the current policy, the cached decisions are invalidated if the rule is updated.
+func (e *CachedEnforcer) UpdateGroupingPolicy(oldRule []string, newRule []string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.UpdateGroupingPolicy(oldRule, newRule)
+	})
+}
+
+// RemoveFilteredGroupingPolicy removes the role inheritance rules matching the filter, the cached decisions are invalidated if any rule is removed.
+func (e *CachedEnforcer) RemoveFilteredGroupingPolicy(fieldIndex int, fieldValues ...string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.RemoveFilteredGroupingPolicy(fieldIndex, fieldValues...)
+	})
+}
+
+// AddNamedGroupingPolicy adds a role inheritance rule to the named grouping policy ptype, the cached decisions are invalidated if the rule is added.
+func (e *CachedEnforcer) AddNamedGroupingPolicy(ptype string, params ...interface{}) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.AddNamedGroupingPolicy(ptype, params...)
+	})
+}
+
+// AddNamedGroupingPolicies adds role inheritance rules to the named grouping policy ptype, the cached decisions are invalidated if the rules are added.
+func (e *CachedEnforcer) AddNamedGroupingPolicies(ptype string, rules [][]string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.AddNamedGroupingPolicies(ptype, rules)
+	})
+}
+
+// RemoveNamedGroupingPolicy removes a role inheritance rule from the named grouping policy ptype, the cached decisions are invalidated if the rule is removed.
+func (e *CachedEnforcer) RemoveNamedGroupingPolicy(ptype string, params ...interface{}) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.RemoveNamedGroupingPolicy(ptype, params...)
+	})
+}
+
+// RemoveNamedGroupingPolicies removes role inheritance rules from the named grouping policy ptype, the cached decisions are invalidated if the rules are removed.
+func (e *CachedEnforcer) RemoveNamedGroupingPolicies(ptype string, rules [][]string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.RemoveNamedGroupingPolicies(ptype, rules)
+	})
+}
+
+// UpdateNamedGroupingPolicy updates a role inheritance rule of the named grouping policy ptype, the cached decisions are invalidated if the rule is updated.
+func (e *CachedEnforcer) UpdateNamedGroupingPolicy(ptype string, oldRule []string, newRule []string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.UpdateNamedGroupingPolicy(ptype, oldRule, newRule)
+	})
+}
+
+// RemoveFilteredNamedGroupingPolicy removes the role inheritance rules of ptype matching the filter, the cached decisions are invalidated if any rule is removed.
+func (e *CachedEnforcer) RemoveFilteredNamedGroupingPolicy(ptype string, fieldIndex int, fieldValues ...string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.RemoveFilteredNamedGroupingPolicy(ptype, fieldIndex, fieldValues...)
+	})
+}
+
+// checkInitialized returns ErrEnforcerNotInitialized if e was not created with NewCachedEnforcer.
+func (e *CachedEnforcer) checkInitialized() error {
+	if e.Enforcer == nil || e.locker == nil {
+		return ErrEnforcerNotInitialized
+	}
+	return nil
+}
+
+// LoadPolicy reloads the policy from file/database and invalidates the cached decisions.
+func (e *CachedEnforcer) LoadPolicy() error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	// The policy may have been partially loaded even on error.
+	return e.invalidatePolicy(e.Enforcer.LoadPolicy())
+}
+
+// LoadFilteredPolicy reloads a filtered policy from file/database and invalidates the cached decisions.
+func (e *CachedEnforcer) LoadFilteredPolicy(filter interface{}) error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	return e.invalidatePolicy(e.Enforcer.LoadFilteredPolicy(filter))
+}
+
+// LoadIncrementalFilteredPolicy appends a filtered policy from file/database and invalidates the cached decisions.
+func (e *CachedEnforcer) LoadIncrementalFilteredPolicy(filter interface{}) error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	return e.invalidatePolicy(e.Enforcer.LoadIncrementalFilteredPolicy(filter))
+}
+
+// ClearPolicy clears all policy and invalidates the cached decisions.
+// The methods without an error, like this one, ignore a failure to invalidate the cache.
+func (e *CachedEnforcer) ClearPolicy() {
+	if e.checkInitialized() != nil {
+		return
+	}
+	e.Enforcer.ClearPolicy()
+	_ = e.invalidatePolicy(nil)
+}
+
+// LoadModel reloads the model from the model CONF file and invalidates the cached decisions.
+// The policy is emptied with the model, reload it with LoadPolicy.
+func (e *CachedEnforcer) LoadModel() error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	return e.invalidatePolicy(e.Enforcer.LoadModel())
+}
+
+// SetModel sets the current model and invalidates the cached decisions.
+func (e *CachedEnforcer) SetModel(m model.Model) {
+	if e.checkInitialized() != nil {
+		return
+	}
+	e.Enforcer.SetModel(m)
+	_ = e.invalidatePolicy(nil)
+}
+
+// SetRoleManager sets the current role manager and invalidates the cached decisions.
+func (e *CachedEnforcer) SetRoleManager(rm rbac.RoleManager) {
+	if e.checkInitialized() != nil {
+		return
+	}
+	e.Enforcer.SetRoleManager(rm)
+	_ = e.invalidatePolicy(nil)
+}
+
+// SetEffector sets the current effector and invalidates the cached decisions.
+func (e *CachedEnforcer) SetEffector(eft effect.Effector) {
+	if e.checkInitialized() != nil {
+		return
+	}
+	e.Enforcer.SetEffector(eft)
+	_ = e.invalidatePolicy(nil)
+}
+
+// EnableEnforce changes the enforcing state of Casbin and invalidates the cached decisions, so that the decisions
+// cached while all access was allowed aren't returned once enforcing is enabled again.
+func (e *CachedEnforcer) EnableEnforce(enable bool) {
+	if e.checkInitialized() != nil {
+		return
+	}
+	e.Enforcer.EnableEnforce(enable)
+	_ = e.invalidatePolicy(nil)
+}
+
+// BuildRoleLinks manually rebuilds the role inheritance relations and invalidates the cached decisions.
+func (e *CachedEnforcer) BuildRoleLinks() error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	return e.invalidatePolicy(e.Enforcer.BuildRoleLinks())
+}
+
+// BuildIncrementalRoleLinks incrementally builds the role inheritance relations and invalidates the cached decisions.
+func (e *CachedEnforcer) BuildIncrementalRoleLinks(op model.PolicyOp, ptype string, rules [][]string) error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	return e.invalidatePolicy(e.Enforcer.BuildIncrementalRoleLinks(op, ptype, rules))
+}
+
+// AddFunction adds a customized function and invalidates the cached decisions, the matcher may call it by name.
+func (e *CachedEnforcer) AddFunction(name string, function govaluate.ExpressionFunction) {
+	if e.checkInitialized() != nil {
+		return
+	}
+	e.Enforcer.AddFunction(name, function)
+	_ = e.invalidatePolicy(nil)
+}
+
+// AddNamedMatchingFunc adds a MatchingFunc to the role manager of ptype, the cached decisions are invalidated
+// if it is added.
+func (e *CachedEnforcer) AddNamedMatchingFunc(ptype, name string, fn defaultrolemanager.MatchingFunc) bool {
+	added, _ := e.changePolicy(func() (bool, error) {
+		return e.Enforcer.AddNamedMatchingFunc(ptype, name, fn), nil
+	})
+	return added
+}
+
+// AddNamedDomainMatchingFunc adds a domain MatchingFunc to the role manager of ptype, the cached decisions are
+// invalidated if it is added.
+func (e *CachedEnforcer) AddNamedDomainMatchingFunc(ptype, name string, fn defaultrolemanager.MatchingFunc) bool {
+	added, _ := e.changePolicy(func() (bool, error) {
+		return e.Enforcer.AddNamedDomainMatchingFunc(ptype, name, fn), nil
+	})
+	return added
+}
+
+// SetWatcher sets the current watcher, its notifications reload the policy with LoadPolicy of CachedEnforcer,
+// so that the cached decisions are invalidated too.
+func (e *CachedEnforcer) SetWatcher(watcher persist.Watcher) error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	e.watcher = watcher
+	return watcher.SetUpdateCallback(func(string) { _ = e.LoadPolicy() })
+}
+
+// EnableDistributedInvalidation makes the policy changes of every enforcer sharing w invalidate the cached decisions
+// of all of them. The changes made by e are published to w, and the ones received from w reload the policy of e with
+// LoadPolicy, invalidating its cache, so that its next misses are evaluated with the changed policy rather than the
+// one it holds. The enforcers must share the storage of the policy through their adapters. It replaces the watcher.
+func (e *CachedEnforcer) EnableDistributedInvalidation(w persist.WatcherEx) error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	e.watcher = w
+	return w.SetUpdateCallback(func(string) { _ = e.LoadPolicy() })
+}
+
+// LastPolicyChange returns when the policy last changed: when it was loaded, with LoadPolicy or when the enforcer was
+// created, or when it was changed through the enforcer or by another one with EnableDistributedInvalidation.
+// A decision cached before it was made with a previous policy, so it is suspect if the invalidation failed,
+// compare it with the age reported by EnforceWithAge. It returns the zero time if the enforcer is not initialized.
+func (e *CachedEnforcer) LastPolicyChange() time.Time {
+	t, _ := e.lastPolicyChange.Load().(time.Time)
+	return t
+}
+
+// changePolicy applies change to the policy and invalidates the cached decisions if the policy changed.
+// The policy may have changed even if an error is reported, like a failure to notify the watcher.
+func (e *CachedEnforcer) changePolicy(change func() (bool, error)) (bool, error) {
+	if err := e.checkInitialized(); err != nil {
+		return false, err
+	}
+	changed, err := change()
+	if !changed {
+		return changed, err
+	}
+	return changed, e.invalidatePolicy(err)
+}
+
+// invalidatePolicy records a change of the policy, or of anything else the decisions depend on, and invalidates the
+// cached decisions. It returns err, the error of the change, or else the one of the invalidation.
+func (e *CachedEnforcer) invalidatePolicy(err error) error {
+	e.lastPolicyChange.Store(time.Now())
+	if cacheErr := e.InvalidateCache(); err == nil {
+		err = wrapCacheError("invalidate", cacheErr)
+	}
+	return err
+}
+
+// InvalidateCacheForSubject deletes the cached decisions of the requests whose first value is sub.
+// The cache deletes them with persist.PrefixCache if implemented, or else by scanning the keys of persist.KeysCache,
+// see SetInvalidationBatchSize.
+// All the cached decisions are deleted if the cache implements neither, or if a custom key func or key hashing is set.
+func (e *CachedEnforcer) InvalidateCacheForSubject(sub string) error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	if e.keyFunc != nil || e.hashesKeys() {
+		return e.InvalidateCache()
+	}
+	var prefix strings.Builder
+	prefix.WriteString(e.namespace)
+	e.writeKeySegment(&prefix, sub)
+	return e.invalidateCachePrefix(prefix.String())
+}
+
+// InvalidateCacheForObject deletes the cached decisions of the requests whose second value is obj.
+// They are deleted by the cache with persist.SegmentCache if implemented and the key separator and namespace are the
+// default ones, or else by scanning the keys of persist.KeysCache, see SetInvalidationBatchSize. All the cached
+// decisions are deleted if neither applies, or if a custom key func or key hashing is set.
+func (e *CachedEnforcer) InvalidateCacheForObject(obj string) error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	if e.keyFunc != nil || e.hashesKeys() {
+		return e.InvalidateCache()
+	}
+	return e.invalidateCacheSegment(1, obj)
+}
+
+// InvalidateCacheForDomain deletes the cached decisions of the requests of domain dom, when a tenant is reconfigured
+// for instance. The domain is the value at the position set with SetDomainIndex, the second one by default.
+// The decisions are deleted like with InvalidateCacheForObject.
+func (e *CachedEnforcer) InvalidateCacheForDomain(dom string) error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	if e.keyFunc != nil || e.hashesKeys() {
+		return e.InvalidateCache()
+	}
+	return e.invalidateCacheSegment(e.domainIndex, dom)
+}
+
+// SetDomainIndex sets the position of the domain in the requests for InvalidateCacheForDomain, starting at 0,
+// 1 by default as in "sub, dom, obj, act". Call it before enforcing.
+func (e *CachedEnforcer) SetDomainIndex(i int) {
+	e.domainIndex = i
+}
+
+func (e *CachedEnforcer) invalidateCacheSegment(index int, value string) error {
+	// The native deletion only parses the keys made with the default separator and without a namespace.
+	e.locker.Lock()
+	if c, ok := e.cache.(persist.SegmentCache); ok && e.namespace == "" && e.keySeparator == persist.DefaultKeySeparator {
+		defer e.locker.Unlock()
+		_ = e.explainCache.DeleteBySegment(index, value)
+		return c.DeleteBySegment(index, value)
+	}
+	e.locker.Unlock()
+
+	explainKeys := e.keysWithSegment(e.explainCache.Keys(), index, value)
+	unlock := e.lockCache()
+	if c, ok := e.cache.(persist.KeysCache); ok {
+		keys := e.keysWithSegment(c.Keys(), index, value)
+		unlock()
+		return e.deleteCachedResults(keys, explainKeys)
+	}
+	unlock()
+
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	_ = e.explainCache.DeleteMany(explainKeys)
+	if e.namespace != "" {
+		if c, ok := e.cache.(persist.PrefixCache); ok {
+			return c.DeletePrefix(e.namespace)
+		}
+	}
+	return e.cache.Clear()
+}
+
+// keysWithSegment returns the keys of the namespace whose segment at index equals value.
+func (e *CachedEnforcer) keysWithSegment(keys []string, index int, value string) []string {
+	var matched []string
+	for _, key := range keys {
+		if !strings.HasPrefix(key, e.namespace) {
+			continue
+		}
+		if segment, ok := persist.KeySegment(key[len(e.namespace):], index, e.keySeparator); ok && segment == value {
+			matched = append(matched, key)
+		}
+	}
+	return matched
+}
+
+// FilterCache deletes the cached decisions for which keep returns false, like all the denials, or the decisions of
+// the keys decoded as of a tenant, along with the ones of EnforceEx. Only the decisions of the namespace are given
+// to keep, with their full key.
+// The cache is iterated with persist.Range, keep is called with the lock of the enforcer held, so it must not
+// call back into the enforcer, and the decisions are then deleted in batches, see SetInvalidationBatchSize.
+// All the cached decisions are deleted if the cache can't be iterated.
+func (e *CachedEnforcer) FilterCache(keep func(key string, value bool) bool) error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	unlock := e.lockCache()
+	var keys []string
+	err := persist.Range(e.cache, func(key string, value bool) bool {
+		if strings.HasPrefix(key, e.namespace) && !keep(key, value) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	unlock()
+	if errors.Is(err, persist.ErrRangeUnsupported) {
+		return e.InvalidateCache()
+	} else if err != nil {
+		return err
+	}
+	var explainKeys []string
+	_ = e.explainCache.Range(func(key string, decision explainedDecision) bool {
+		if strings.HasPrefix(key, e.namespace) && !keep(key, decision.res) {
+			explainKeys = append(explainKeys, key)
+		}
+		return true
+	})
+	return e.deleteCachedResults(keys, explainKeys)
+}
+
+// DefaultInvalidationBatchSize is the number of decisions deleted per batch by the invalidations scanning the cache,
+// see SetInvalidationBatchSize.
+const DefaultInvalidationBatchSize = 1000
+
+// SetInvalidationBatchSize sets the number of decisions deleted per batch by the invalidations scanning the keys
+// of the cache: FilterCache and the partial invalidations like InvalidateCacheForObject, unless the cache deletes
+// the keys itself with persist.SegmentCache or persist.PrefixCache under the write lock. They take a snapshot of the
+// keys to delete with the read lock of the enforcer, then delete them in batches of n keys, each taking the write
+// lock briefly, so that the enforces go on during the invalidation of a large cache. A decision cached while the keys
+// are deleted survives the invalidation even if it matches. n <= 0 means DefaultInvalidationBatchSize, which is
+// the default. Call it before enforcing.
+func (e *CachedEnforcer) SetInvalidationBatchSize(n int) {
+	e.invalidationBatchSize = n
+}
+
+// deleteCachedResults deletes the decisions of keys and the ones of EnforceEx of explainKeys in batches,
+// each taking the write lock, see SetInvalidationBatchSize.
+func (e *CachedEnforcer) deleteCachedResults(keys []string, explainKeys []string) error {
+	size := e.invalidationBatchSize
+	if size <= 0 {
+		size = DefaultInvalidationBatchSize
+	}
+	_ = e.explainCache.DeleteMany(explainKeys)
+	for len(keys) > 0 {
+		n := size
+		if n > len(keys) {
+			n = len(keys)
+		}
+		e.locker.Lock()
+		err := persist.DeleteMany(e.cache, keys[:n])
+		e.locker.Unlock()
+		if err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+func (e *CachedEnforcer) invalidateCachePrefix(prefix string) error {
+	unlock := e.lockCache()
+	if _, ok := e.cache.(persist.PrefixCache); !ok {
+		if c, ok := e.cache.(persist.KeysCache); ok {
+			var keys []string
+			for _, key := range c.Keys() {
+				if strings.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+			}
+			unlock()
+			_ = e.explainCache.DeletePrefix(prefix)
+			return e.deleteCachedResults(keys, nil)
+		}
+	}
+	unlock()
+
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	_ = e.explainCache.DeletePrefix(prefix)
+	if c, ok := e.cache.(persist.PrefixCache); ok {
+		return c.DeletePrefix(prefix)
+	}
+	return e.cache.Clear()
+}
+
+// savedCacheVersion is the version of the format written by SaveCache.
+const savedCacheVersion = 1
+
+type savedCache struct {
+	Version int               `json:"version"`
+	Entries []savedCacheEntry `json:"entries"`
+}
+
+type savedCacheEntry struct {
+	Key   string `json:"key"`
+	Value bool   `json:"value"`
+	// ExpiresAt is nil for the decisions which never expire.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// SaveCache writes the cached decisions to w as JSON, for LoadCache to restore them, in a later run for instance.
+// The cache must implement persist.EntriesCache. The keys are saved as they are, so they are only valid
+// for an enforcer with the same key separator and key func.
+func (e *CachedEnforcer) SaveCache(w io.Writer) error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	e.locker.RLock()
+	c, ok := e.cache.(persist.EntriesCache)
+	if !ok {
+		e.locker.RUnlock()
+		return errors.New("the cache does not implement persist.EntriesCache")
+	}
+	entries, err := c.Entries()
+	e.locker.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	saved := savedCache{Version: savedCacheVersion, Entries: make([]savedCacheEntry, 0, len(entries))}
+	for key, entry := range entries {
+		item := savedCacheEntry{Key: key, Value: entry.Value}
+		if entry.TTL > 0 {
+			expiresAt := now.Add(entry.TTL).UTC()
+			item.ExpiresAt = &expiresAt
+		}
+		saved.Entries = append(saved.Entries, item)
+	}
+	sort.Slice(saved.Entries, func(i, j int) bool { return saved.Entries[i].Key < saved.Entries[j].Key })
+	return json.NewEncoder(w).Encode(saved)
+}
+
+// DumpCache writes the cached decisions to w as a table of their key, value and remaining survival time sorted
+// by key, for a support bundle for instance. The cache must implement persist.EntriesCache, unlike the remote caches
+// which can't list their items cheaply. Use SaveCache to restore the decisions later.
+func (e *CachedEnforcer) DumpCache(w io.Writer) error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	e.locker.RLock()
+	c, ok := e.cache.(persist.EntriesCache)
+	if !ok {
+		e.locker.RUnlock()
+		return errors.New("the cache does not implement persist.EntriesCache")
+	}
+	entries, err := c.Entries()
+	e.locker.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tTTL")
+	for _, key := range keys {
+		entry := entries[key]
+		ttl := "never"
+		if entry.TTL > 0 {
+			ttl = entry.TTL.String()
+		}
+		fmt.Fprintf(tw, "%s\t%t\t%s\n", key, entry.Value, ttl)
+	}
+	return tw.Flush()
+}
+
+// CloneCache returns a new DefaultCache holding a copy of the cached decisions with their survival time,
+// to warm up another enforcer with SetCache for instance. The cache must implement persist.EntriesCache.
+func (e *CachedEnforcer) CloneCache() (persist.Cache, error) {
+	if err := e.checkInitialized(); err != nil {
+		return nil, err
+	}
+	e.locker.RLock()
+	defer e.locker.RUnlock()
+	c, ok := e.cache.(persist.EntriesCache)
+	if !ok {
+		return nil, errors.New("the cache does not implement persist.EntriesCache")
+	}
+	entries, err := c.Entries()
+	if err != nil {
+		return nil, err
+	}
+	return cache.NewDefaultCacheFromEntries(entries), nil
+}
+
+// LoadCache adds the decisions written by SaveCache from r to the cache, the expired ones are dropped.
+// The survival time of a decision is rounded down to the second, dropping the ones with less than a second left.
+// Nothing is loaded if the input is not valid.
+func (e *CachedEnforcer) LoadCache(r io.Reader) error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	var saved savedCache
+	if err := json.NewDecoder(r).Decode(&saved); err != nil {
+		return fmt.Errorf("invalid saved cache: %w", err)
+	}
+	if saved.Version != savedCacheVersion {
+		return fmt.Errorf("invalid saved cache: unsupported version %d", saved.Version)
+	}
+
+	now := time.Now()
+	// The decisions are grouped by survival time in seconds, 0 for the ones which never expire.
+	byTTL := make(map[uint]map[string]bool)
+	for _, item := range saved.Entries {
+		if item.Key == "" {
+			return errors.New("invalid saved cache: empty key")
+		}
+		var ttl uint
+		if item.ExpiresAt != nil {
+			ttl = uint(item.ExpiresAt.Sub(now) / time.Second)
+			if ttl == 0 || !item.ExpiresAt.After(now) {
+				continue
+			}
+		}
+		if byTTL[ttl] == nil {
+			byTTL[ttl] = make(map[string]bool)
+		}
+		byTTL[ttl][item.Key] = item.Value
+	}
+
+	defer e.lockCache()()
+	for ttl, entries := range byTTL {
+		if err := persist.SetMany(e.cache, entries, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InvalidateCache deletes all the existing cached decisions, only the ones of the namespace if SetCacheNamespace is set.
+func (e *CachedEnforcer) InvalidateCache() error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	if atomic.LoadInt32(&e.reWarmOnClear) != 0 {
+		e.captureReWarmKeys()
+	}
+	return e.clearCache()
+}
+
+// clearCache deletes all the cached decisions of the namespace, without capturing them for SetReWarmOnClear.
+func (e *CachedEnforcer) clearCache() error {
+	if e.namespace != "" {
+		return e.invalidateCachePrefix(e.namespace)
+	}
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	_ = e.explainCache.Clear()
+	return e.cache.Clear()
+}
+
+// SetReWarmOnClear makes InvalidateCache capture the keys of the cached decisions before deleting them, and the first
+// Enforce after it evaluate them again on a goroutine, so that the decisions are cached again before the traffic asks
+// for them. Neither InvalidateCache nor Enforce waits for the re-warming, which stops once the enforcer is closed.
+// Only the keys of persist.KeysCache are captured, and only the built-in keys of requests without a custom matcher
+// can be evaluated again, so nothing is re-warmed with a custom key func, key hashing or NonStringCoerce.
+// Disabling it drops the keys not re-warmed yet.
+func (e *CachedEnforcer) SetReWarmOnClear(reWarm bool) {
+	var enabled int32
+	if reWarm {
+		enabled = 1
+	}
+	atomic.StoreInt32(&e.reWarmOnClear, enabled)
+	if !reWarm {
+		e.reWarmKeys.Store([]string(nil))
+	}
+}
+
+// captureReWarmKeys saves the keys of the namespace for startReWarm.
+func (e *CachedEnforcer) captureReWarmKeys() {
+	if !e.rebuildsRequests() {
+		return
+	}
+	e.locker.RLock()
+	c, ok := e.cache.(persist.KeysCache)
+	var keys []string
+	if ok {
+		for _, key := range c.Keys() {
+			if strings.HasPrefix(key, e.namespace) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	e.locker.RUnlock()
+	e.reWarmKeys.Store(keys)
+}
+
+// startReWarm re-warms the captured keys on a goroutine, once.
+func (e *CachedEnforcer) startReWarm() {
+	if keys, _ := e.reWarmKeys.Load().([]string); len(keys) == 0 {
+		return
+	}
+	if keys, _ := e.reWarmKeys.Swap([]string(nil)).([]string); len(keys) > 0 {
+		go e.reWarm(keys)
+	}
+}
+
+// reWarm evaluates and caches again the requests of keys, until the enforcer is closed.
+func (e *CachedEnforcer) reWarm(keys []string) {
+	for _, key := range keys {
+		if atomic.LoadInt32(&e.closed) != 0 {
+			return
+		}
+		if rvals, ok := e.keyRequest(key); ok {
+			_ = e.WarmCache([][]interface{}{rvals})
+		}
+	}
+}
+
+// EvaluateKey evaluates the decision of a built-in key without the cache, for caches evaluating the decisions
+// themselves on a miss, like the groupcache one. It returns persist.ErrNoSuchKey if the request can't be rebuilt
+// from key: a key of another namespace, with a matcher suffix, or with a custom key func, key hashing or NonStringCoerce.
+func (e *CachedEnforcer) EvaluateKey(key string) (bool, error) {
+	if err := e.checkInitialized(); err != nil {
+		return false, err
+	}
+	if !e.rebuildsRequests() {
+		return false, persist.ErrNoSuchKey
+	}
+	if !strings.HasPrefix(key, e.namespace) {
+		return false, persist.ErrNoSuchKey
+	}
+	rvals, ok := e.keyRequest(key)
+	if !ok {
+		return false, persist.ErrNoSuchKey
+	}
+	return e.evaluate("", rvals...)
+}
+
+// rebuildsRequests reports whether the requests can be rebuilt from their keys with keyRequest, which is not the case
+// with a custom key func, with hashed keys or when the non-string values are coerced into keys.
+func (e *CachedEnforcer) rebuildsRequests() bool {
+	return e.keyFunc == nil && !e.hashesKeys() && NonStringPolicy(atomic.LoadInt32(&e.nonStringPolicy)) != NonStringCoerce
+}
+
+// keyRequest returns the request values of a built-in key, or false if key is not one, like a key with a matcher suffix.
+func (e *CachedEnforcer) keyRequest(key string) ([]interface{}, bool) {
+	key = strings.TrimPrefix(key, e.namespace)
+	var rvals []interface{}
+	var rebuilt strings.Builder
+	for i := 0; ; i++ {
+		segment, ok := persist.KeySegment(key, i, e.keySeparator)
+		if !ok {
+			break
+		}
+		rvals = append(rvals, segment)
+		e.writeKeySegment(&rebuilt, segment)
+	}
+	return rvals, len(rvals) > 0 && rebuilt.String() == key
+}
+
+// InvalidateCacheAsync is like InvalidateCache, but deletes the cached decisions on a goroutine, so that the caller
+// doesn't wait for a slow cache like a remote one. The returned channel receives the error of the deletion, nil on
+// success, and is then closed. The deletion holds the write lock like InvalidateCache: the decisions stored before it
+// takes the lock are deleted with the others, none is stored while it runs, and the ones stored after it are kept.
+// With a namespace and a cache whose keys are scanned, the keys are deleted in batches, see SetInvalidationBatchSize.
+func (e *CachedEnforcer) InvalidateCacheAsync() <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- e.InvalidateCache()
+		close(done)
+	}()
+	return done
+}
+
+// Close releases the decision cache, closing it if it implements io.Closer, like a DefaultCache stopping its sweeper
+// or a cache flushing its pending writes. It returns ErrEnforcerClosed if the enforcer is already closed.
+// The enforcer must not be used once it is closed.
+func (e *CachedEnforcer) Close() error {
+	if err := e.checkInitialized(); err != nil {
+		return err
+	}
+	if !atomic.CompareAndSwapInt32(&e.closed, 0, 1) {
+		return ErrEnforcerClosed
+	}
+	e.locker.RLock()
+	c := e.cache
+	e.locker.RUnlock()
+	if closer, ok := c.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
 }
@@ -16,7 +16,10 @@ package casbin
 
 import (
 	"fmt"
+	"sync"
 	"testing"
+
+	"github.com/casbin/casbin/v2/persist/cache"
 )
 
 func BenchmarkCachedRaw(b *testing.B) {
@@ -34,6 +37,27 @@ func BenchmarkCachedBasicModel(b *testing.B) {
 	}
 }
 
+func BenchmarkCachedBasicModelNopCache(b *testing.B) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv", false)
+	e.SetCache(cache.NopCache{})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = e.Enforce("alice", "data1", "read")
+	}
+}
+
+// BenchmarkCachedBasicModelWithKey compares with BenchmarkCachedBasicModel, which builds the key of every hit.
+func BenchmarkCachedBasicModelWithKey(b *testing.B) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv", false)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = e.EnforceWithKey("alice/data1/read", "alice", "data1", "read")
+	}
+}
+
 func BenchmarkCachedRBACModel(b *testing.B) {
 	e, _ := NewCachedEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv", false)
 
@@ -210,3 +234,68 @@ func BenchmarkCachedRBACModelMediumParallel(b *testing.B) {
 		}
 	})
 }
+
+func benchmarkCachedBatchRequests() [][]interface{} {
+	requests := make([][]interface{}, 0, 100)
+	for i := 0; i < 100; i++ {
+		requests = append(requests, []interface{}{fmt.Sprintf("user%d", i%50), "data1", "read"})
+	}
+	return requests
+}
+
+func BenchmarkCachedBatchEnforce(b *testing.B) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv", false)
+	requests := benchmarkCachedBatchRequests()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%10 == 0 {
+			_ = e.InvalidateCache()
+		}
+		_, _ = e.BatchEnforce(requests)
+	}
+}
+
+func BenchmarkCachedBatchEnforceLoop(b *testing.B) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv", false)
+	requests := benchmarkCachedBatchRequests()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%10 == 0 {
+			_ = e.InvalidateCache()
+		}
+		for _, request := range requests {
+			_, _ = e.Enforce(request...)
+		}
+	}
+}
+
+// benchmarkCachedStampede runs a herd of identical requests against a cold cache per iteration.
+func benchmarkCachedStampede(b *testing.B, e *CachedEnforcer) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = e.InvalidateCache()
+		var wg sync.WaitGroup
+		for j := 0; j < 16; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _ = e.Enforce("user5001", "data150", "read")
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func BenchmarkCachedStampede(b *testing.B) {
+	e, _ := NewCachedEnforcer("examples/rbac_model.conf", false)
+	for i := 0; i < 1000; i++ {
+		_, _ = e.AddPolicy(fmt.Sprintf("group%d", i), fmt.Sprintf("data%d", i/10), "read")
+	}
+	for i := 0; i < 10000; i++ {
+		_, _ = e.AddGroupingPolicy(fmt.Sprintf("user%d", i), fmt.Sprintf("group%d", i/10))
+	}
+	e.SetCache(cache.NewLRUCache(100))
+	benchmarkCachedStampede(b, e)
+}
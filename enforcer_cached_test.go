@@ -14,7 +14,28 @@
 
 package casbin
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2/effect"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/casbin/casbin/v2/persist/cache"
+	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
+	defaultrolemanager "github.com/casbin/casbin/v2/rbac/default-role-manager"
+	"github.com/casbin/casbin/v2/util"
+)
 
 func testEnforceCache(t *testing.T, e *CachedEnforcer, sub string, obj interface{}, act string, res bool) {
 	t.Helper()
@@ -32,9 +53,9 @@ func TestCache(t *testing.T) {
 	testEnforceCache(t, e, "alice", "data2", "read", false)
 	testEnforceCache(t, e, "alice", "data2", "write", false)
 
-	// The cache is enabled, so even if we remove a policy rule, the decision
-	// for ("alice", "data1", "read") will still be true, as it uses the cached result.
-	_, _ = e.RemovePolicy("alice", "data1", "read")
+	// The cache is enabled, so even if we remove a policy rule behind the back of the cached enforcer,
+	// the decision for ("alice", "data1", "read") will still be true, as it uses the cached result.
+	_, _ = e.Enforcer.RemovePolicy("alice", "data1", "read")
 
 	testEnforceCache(t, e, "alice", "data1", "read", true)
 	testEnforceCache(t, e, "alice", "data1", "write", false)
@@ -43,10 +64,3518 @@ func TestCache(t *testing.T) {
 
 	// Now we invalidate the cache, then all first-coming Enforce() has to be evaluated in real-time.
 	// The decision for ("alice", "data1", "read") will be false now.
-	e.InvalidateCache()
+	_ = e.InvalidateCache()
 
 	testEnforceCache(t, e, "alice", "data1", "read", false)
 	testEnforceCache(t, e, "alice", "data1", "write", false)
 	testEnforceCache(t, e, "alice", "data2", "read", false)
 	testEnforceCache(t, e, "alice", "data2", "write", false)
 }
+
+func TestCacheExpireTime(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	if ttl := e.ExpireTime(); ttl != 0 {
+		t.Errorf("ExpireTime: %d, supposed to be 0 by default", ttl)
+	}
+	e.SetExpireTime(1)
+	if ttl := e.ExpireTime(); ttl != 1 {
+		t.Errorf("ExpireTime: %d, supposed to be 1", ttl)
+	}
+
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+
+	// Bypass the cached enforcer so the cached decision is not touched.
+	_, _ = e.Enforcer.RemovePolicy("alice", "data1", "read")
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+
+	// Once the survival time has passed, the stale decision is no longer served.
+	time.Sleep(1100 * time.Millisecond)
+	testEnforceCache(t, e, "alice", "data1", "read", false)
+}
+
+func TestCacheWithLRUCache(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.SetCache(cache.NewLRUCache(1))
+
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	_, _ = e.Enforcer.RemovePolicy("alice", "data1", "read")
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+
+	// The capacity is 1, so this decision evicts the cached one for alice.
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+	testEnforceCache(t, e, "alice", "data1", "read", false)
+	if stats := e.CacheStats(); stats.Evictions != 2 || stats.Expirations != 0 {
+		t.Errorf("stats: %+v, supposed to have 2 evictions", stats)
+	}
+
+	e.ResetCacheStats()
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+	if stats := e.CacheStats(); stats.Evictions != 1 {
+		t.Errorf("stats: %+v, supposed to have 1 eviction since the reset", stats)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+	testEnforceCache(t, e, "alice", 1, "read", false)
+
+	if stats := e.CacheStats(); stats != (CacheStats{Hits: 2, Misses: 2, Bypasses: 1}) {
+		t.Errorf("stats: %+v, supposed to be %+v", stats, CacheStats{Hits: 2, Misses: 2, Bypasses: 1})
+	}
+
+	e.ResetCacheStats()
+	if stats := e.CacheStats(); stats != (CacheStats{}) {
+		t.Errorf("stats: %+v, supposed to be %+v", stats, CacheStats{})
+	}
+}
+
+// testLoadingCache evaluates the decisions of its misses with the enforcer, like the groupcache one.
+type testLoadingCache struct {
+	persist.Cache
+	e *CachedEnforcer
+}
+
+func (c *testLoadingCache) GetLoad(ctx context.Context, key string) (bool, bool, error) {
+	if res, err := c.Cache.Get(key); err == nil {
+		return res, false, nil
+	}
+	res, err := c.e.EvaluateKey(key)
+	if err != nil {
+		return false, false, err
+	}
+	return res, true, c.Cache.Set(key, res)
+}
+
+func TestCacheStatsLoadingCache(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	var _ persist.LoadingCache = &testLoadingCache{}
+	e.SetCache(&testLoadingCache{Cache: cache.NewDefaultCache(), e: e})
+	o := &testObserver{}
+	e.SetObserver(o)
+
+	// The decisions evaluated by the cache are misses.
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+
+	if stats := e.CacheStats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("stats: %+v, supposed to have 1 hit and 2 misses", stats)
+	}
+	alice, bob := "5:alice$$5:data1$$4:read$$", "3:bob$$5:data2$$5:write$$"
+	want := []string{"miss " + alice, "hit " + alice, "miss " + bob}
+	if strings.Join(o.events, "|") != strings.Join(want, "|") {
+		t.Errorf("events: %v, supposed to be %v", o.events, want)
+	}
+}
+
+func TestCacheNonStringKeys(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/abac_model.conf")
+	data1 := newTestResource("data1", "alice")
+	data2 := newTestResource("data2", "bob")
+
+	// Non-string values bypass the cache by default.
+	testEnforceCache(t, e, "alice", data1, "read", true)
+	if stats := e.CacheStats(); stats.Bypasses != 1 {
+		t.Errorf("bypasses: %d, supposed to be 1", stats.Bypasses)
+	}
+
+	e.EnableNonStringKeys(true)
+	key1, ok1 := e.getKey("alice", data1, "read")
+	key2, ok2 := e.getKey("alice", data2, "read")
+	if !ok1 || !ok2 {
+		t.Fatal("non-string values should be cacheable")
+	}
+	if key1 == key2 {
+		t.Errorf("distinct structs share the key %s", key1)
+	}
+	// Pointers are keyed by the value they point to.
+	if key, _ := e.getKey("alice", &data1, "read"); key != key1 {
+		t.Errorf("pointer key %s, supposed to be %s", key, key1)
+	}
+	// Values of different types which print the same don't collide.
+	intKey, _ := e.getKey("alice", 1, "read")
+	if strKey, _ := e.getKey("alice", "1", "read"); intKey == strKey {
+		t.Errorf("int key %s collides with the string one", intKey)
+	}
+
+	testEnforceCache(t, e, "alice", data1, "read", true)
+	testEnforceCache(t, e, "alice", data2, "read", false)
+	testEnforceCache(t, e, "alice", &data1, "read", true)
+	if stats := e.CacheStats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("stats: %+v, supposed to have 1 hit and 2 misses", stats)
+	}
+}
+
+func TestCacheSetNonStringPolicy(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/abac_model.conf")
+	data1 := newTestResource("data1", "alice")
+
+	e.SetNonStringPolicy(NonStringBypass)
+	testEnforceWithCacheInfo(t, e, "alice", data1, "read", true, false)
+	testEnforceWithCacheInfo(t, e, "alice", data1, "read", true, false)
+	if stats := e.CacheStats(); stats.Bypasses != 2 {
+		t.Errorf("bypasses: %d, supposed to be 2", stats.Bypasses)
+	}
+
+	e.SetNonStringPolicy(NonStringError)
+	if _, err := e.Enforce("alice", data1, "read"); !errors.Is(err, ErrNonStringRequestValue) || !strings.Contains(err.Error(), "value 1") {
+		t.Errorf("Enforce returned %v, supposed to be %v for the value 1", err, ErrNonStringRequestValue)
+	}
+	if _, err := e.BatchEnforce([][]interface{}{{"alice", data1, "read"}}); !errors.Is(err, ErrNonStringRequestValue) {
+		t.Errorf("BatchEnforce returned %v, supposed to be %v", err, ErrNonStringRequestValue)
+	}
+	if err := e.checkNonStringParams("alice", "data1", "read"); err != nil {
+		t.Errorf("string values are rejected with %v", err)
+	}
+
+	e.SetNonStringPolicy(NonStringCoerce)
+	testEnforceWithCacheInfo(t, e, "alice", data1, "read", true, false)
+	testEnforceWithCacheInfo(t, e, "alice", data1, "read", true, true)
+}
+
+func TestCacheAddPolicy(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	testEnforceCache(t, e, "alice", "data1", "write", false)
+	testEnforceCache(t, e, "alice", "data2", "read", false)
+
+	// The cached denials are invalidated by the added rules.
+	_, _ = e.AddPolicy("alice", "data1", "write")
+	testEnforceCache(t, e, "alice", "data1", "write", true)
+
+	_, _ = e.AddPolicies([][]string{{"alice", "data2", "read"}})
+	testEnforceCache(t, e, "alice", "data2", "read", true)
+}
+
+func TestCacheRemovePolicy(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+
+	// The cached decisions are invalidated by the removed rules.
+	_, _ = e.RemovePolicy("alice", "data1", "read")
+	testEnforceCache(t, e, "alice", "data1", "read", false)
+
+	_, _ = e.RemovePolicies([][]string{{"bob", "data2", "write"}})
+	testEnforceCache(t, e, "bob", "data2", "write", false)
+}
+
+func TestCacheUpdatePolicy(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	_, _ = e.UpdatePolicy([]string{"alice", "data1", "read"}, []string{"alice", "data1", "write"})
+	testEnforceCache(t, e, "alice", "data1", "read", false)
+
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+	_, _ = e.UpdatePolicies([][]string{{"bob", "data2", "write"}}, [][]string{{"bob", "data2", "read"}})
+	testEnforceCache(t, e, "bob", "data2", "write", false)
+
+	testEnforceCache(t, e, "alice", "data1", "write", true)
+	_, _ = e.RemoveFilteredPolicy(0, "alice")
+	testEnforceCache(t, e, "alice", "data1", "write", false)
+
+	// LoadPolicy brings back the rules from the file.
+	_ = e.LoadPolicy()
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+}
+
+func TestCacheGroupingPolicy(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+
+	testEnforceCache(t, e, "bob", "data2", "read", false)
+	_, _ = e.AddGroupingPolicy("bob", "data2_admin")
+	testEnforceCache(t, e, "bob", "data2", "read", true)
+
+	_, _ = e.RemoveGroupingPolicy("bob", "data2_admin")
+	testEnforceCache(t, e, "bob", "data2", "read", false)
+
+	_, _ = e.AddGroupingPolicies([][]string{{"bob", "data2_admin"}})
+	testEnforceCache(t, e, "bob", "data2", "read", true)
+
+	_, _ = e.RemoveGroupingPolicies([][]string{{"bob", "data2_admin"}})
+	testEnforceCache(t, e, "bob", "data2", "read", false)
+
+	testEnforceCache(t, e, "alice", "data2", "read", true)
+	_, _ = e.UpdateGroupingPolicy([]string{"alice", "data2_admin"}, []string{"bob", "data2_admin"})
+	testEnforceCache(t, e, "alice", "data2", "read", false)
+	testEnforceCache(t, e, "bob", "data2", "read", true)
+
+	_, _ = e.RemoveFilteredGroupingPolicy(1, "data2_admin")
+	testEnforceCache(t, e, "bob", "data2", "read", false)
+
+	_, _ = e.AddGroupingPolicy("alice", "data2_admin")
+	testEnforceCache(t, e, "alice", "data2", "read", true)
+	_, _ = e.RemoveFilteredNamedGroupingPolicy("g", 0, "alice")
+	testEnforceCache(t, e, "alice", "data2", "read", false)
+}
+
+func TestCacheNamedPolicy(t *testing.T) {
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+p2 = sub
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act && !blocked(r.sub)
+`)
+	e, _ := NewCachedEnforcer(m, fileadapter.NewAdapter("examples/basic_policy.csv"))
+	// The decisions depend on the secondary policy p2 of the blocked subjects.
+	e.AddFunction("blocked", func(args ...interface{}) (interface{}, error) {
+		return e.HasNamedPolicy("p2", args[0].(string)), nil
+	})
+
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	_, _ = e.AddNamedPolicy("p2", "alice")
+	testEnforceCache(t, e, "alice", "data1", "read", false)
+	_, _ = e.RemoveNamedPolicy("p2", "alice")
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+	_, _ = e.AddNamedPolicies("p2", [][]string{{"alice"}, {"bob"}})
+	testEnforceCache(t, e, "alice", "data1", "read", false)
+	testEnforceCache(t, e, "bob", "data2", "write", false)
+	_, _ = e.RemoveNamedPolicies("p2", [][]string{{"bob"}})
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+
+	_, _ = e.UpdateNamedPolicy("p2", []string{"alice"}, []string{"bob"})
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "bob", "data2", "write", false)
+	_, _ = e.UpdateNamedPolicies("p2", [][]string{{"bob"}}, [][]string{{"alice"}})
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+	testEnforceCache(t, e, "alice", "data1", "read", false)
+	_, _ = e.RemoveFilteredNamedPolicy("p2", 0, "alice")
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+
+	// The named edits of the primary policy invalidate the cache too.
+	_, _ = e.RemoveNamedPolicy("p", "alice", "data1", "read")
+	testEnforceCache(t, e, "alice", "data1", "read", false)
+}
+
+func TestCacheNamedGroupingPolicy(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+
+	testEnforceCache(t, e, "bob", "data2", "read", false)
+	_, _ = e.AddNamedGroupingPolicy("g", "bob", "data2_admin")
+	testEnforceCache(t, e, "bob", "data2", "read", true)
+	_, _ = e.RemoveNamedGroupingPolicy("g", "bob", "data2_admin")
+	testEnforceCache(t, e, "bob", "data2", "read", false)
+
+	_, _ = e.AddNamedGroupingPolicies("g", [][]string{{"bob", "data2_admin"}})
+	testEnforceCache(t, e, "bob", "data2", "read", true)
+	_, _ = e.RemoveNamedGroupingPolicies("g", [][]string{{"bob", "data2_admin"}})
+	testEnforceCache(t, e, "bob", "data2", "read", false)
+
+	testEnforceCache(t, e, "alice", "data2", "read", true)
+	_, _ = e.UpdateNamedGroupingPolicy("g", []string{"alice", "data2_admin"}, []string{"bob", "data2_admin"})
+	testEnforceCache(t, e, "alice", "data2", "read", false)
+	testEnforceCache(t, e, "bob", "data2", "read", true)
+}
+
+// testPolicyChange is a change of the policy named name, changing the decision of rvals from before to after.
+type testPolicyChange struct {
+	name          string
+	change        func(e *CachedEnforcer) (bool, error)
+	rvals         []interface{}
+	before, after bool
+}
+
+// testCachePolicyChanges applies each change to a new enforcer between two enforcements of its rvals, the decision
+// cached by the first one is supposed to be invalidated by the change.
+func testCachePolicyChanges(t *testing.T, newEnforcer func() *CachedEnforcer, tests []testPolicyChange) {
+	t.Helper()
+	for _, tt := range tests {
+		e := newEnforcer()
+		if res, _ := e.Enforce(tt.rvals...); res != tt.before {
+			t.Errorf("%s: %v: %t before the change, supposed to be %t", tt.name, tt.rvals, res, tt.before)
+		}
+		if changed, err := tt.change(e); !changed || err != nil {
+			t.Errorf("%s: changed %t, %v, supposed to change the policy", tt.name, changed, err)
+		}
+		if res, _ := e.Enforce(tt.rvals...); res != tt.after {
+			t.Errorf("%s: %v: %t after the change, supposed to be %t", tt.name, tt.rvals, res, tt.after)
+		}
+	}
+}
+
+func TestCacheRolesForUser(t *testing.T) {
+	testCachePolicyChanges(t, func() *CachedEnforcer {
+		e, _ := NewCachedEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+		return e
+	}, []testPolicyChange{
+		{"AddRoleForUser", func(e *CachedEnforcer) (bool, error) {
+			return e.AddRoleForUser("bob", "data2_admin")
+		}, []interface{}{"bob", "data2", "read"}, false, true},
+		{"AddRolesForUser", func(e *CachedEnforcer) (bool, error) {
+			return e.AddRolesForUser("bob", []string{"data2_admin"})
+		}, []interface{}{"bob", "data2", "read"}, false, true},
+		{"DeleteRoleForUser", func(e *CachedEnforcer) (bool, error) {
+			return e.DeleteRoleForUser("alice", "data2_admin")
+		}, []interface{}{"alice", "data2", "read"}, true, false},
+		{"DeleteRolesForUser", func(e *CachedEnforcer) (bool, error) {
+			return e.DeleteRolesForUser("alice")
+		}, []interface{}{"alice", "data2", "read"}, true, false},
+	})
+}
+
+func TestCacheDeleteUserAndRole(t *testing.T) {
+	testCachePolicyChanges(t, func() *CachedEnforcer {
+		e, _ := NewCachedEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+		return e
+	}, []testPolicyChange{
+		{"DeleteUser", func(e *CachedEnforcer) (bool, error) {
+			return e.DeleteUser("alice")
+		}, []interface{}{"alice", "data1", "read"}, true, false},
+		{"DeleteRole", func(e *CachedEnforcer) (bool, error) {
+			return e.DeleteRole("data2_admin")
+		}, []interface{}{"alice", "data2", "read"}, true, false},
+	})
+}
+
+func TestCachePermissionsForUser(t *testing.T) {
+	testCachePolicyChanges(t, func() *CachedEnforcer {
+		e, _ := NewCachedEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+		return e
+	}, []testPolicyChange{
+		{"DeletePermission", func(e *CachedEnforcer) (bool, error) {
+			return e.DeletePermission("data1", "read")
+		}, []interface{}{"alice", "data1", "read"}, true, false},
+		{"AddPermissionForUser", func(e *CachedEnforcer) (bool, error) {
+			return e.AddPermissionForUser("bob", "data1", "read")
+		}, []interface{}{"bob", "data1", "read"}, false, true},
+		{"DeletePermissionForUser", func(e *CachedEnforcer) (bool, error) {
+			return e.DeletePermissionForUser("alice", "data1", "read")
+		}, []interface{}{"alice", "data1", "read"}, true, false},
+		{"DeletePermissionsForUser", func(e *CachedEnforcer) (bool, error) {
+			return e.DeletePermissionsForUser("alice")
+		}, []interface{}{"alice", "data1", "read"}, true, false},
+	})
+}
+
+func TestCacheRolesForUserInDomain(t *testing.T) {
+	testCachePolicyChanges(t, func() *CachedEnforcer {
+		e, _ := NewCachedEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+		return e
+	}, []testPolicyChange{
+		{"AddRoleForUserInDomain", func(e *CachedEnforcer) (bool, error) {
+			return e.AddRoleForUserInDomain("bob", "admin", "domain1")
+		}, []interface{}{"bob", "domain1", "data1", "read"}, false, true},
+		{"DeleteRoleForUserInDomain", func(e *CachedEnforcer) (bool, error) {
+			return e.DeleteRoleForUserInDomain("alice", "admin", "domain1")
+		}, []interface{}{"alice", "domain1", "data1", "read"}, true, false},
+		{"DeleteRolesForUserInDomain", func(e *CachedEnforcer) (bool, error) {
+			return e.DeleteRolesForUserInDomain("alice", "domain1")
+		}, []interface{}{"alice", "domain1", "data1", "read"}, true, false},
+	})
+}
+
+// testDenyEffector denies all the requests.
+type testDenyEffector struct{}
+
+func (testDenyEffector) MergeEffects(expr string, effects []effect.Effect, results []float64) (bool, int, error) {
+	return false, -1, nil
+}
+
+// testChanged adapts a change without a result to testPolicyChange.
+func testChanged(change func(e *CachedEnforcer) error) func(e *CachedEnforcer) (bool, error) {
+	return func(e *CachedEnforcer) (bool, error) {
+		return true, change(e)
+	}
+}
+
+func TestCacheModelChanges(t *testing.T) {
+	testCachePolicyChanges(t, func() *CachedEnforcer {
+		e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+		return e
+	}, []testPolicyChange{
+		{"ClearPolicy", testChanged(func(e *CachedEnforcer) error {
+			e.ClearPolicy()
+			return nil
+		}), []interface{}{"bob", "data2", "write"}, true, false},
+		// The reloaded model has no policy.
+		{"LoadModel", testChanged(func(e *CachedEnforcer) error {
+			return e.LoadModel()
+		}), []interface{}{"bob", "data2", "write"}, true, false},
+		{"SetModel", testChanged(func(e *CachedEnforcer) error {
+			m, err := model.NewModelFromFile("examples/basic_model.conf")
+			e.SetModel(m)
+			return err
+		}), []interface{}{"bob", "data2", "write"}, true, false},
+		{"SetEffector", testChanged(func(e *CachedEnforcer) error {
+			e.SetEffector(testDenyEffector{})
+			return nil
+		}), []interface{}{"bob", "data2", "write"}, true, false},
+		{"EnableEnforce", testChanged(func(e *CachedEnforcer) error {
+			e.EnableEnforce(false)
+			return nil
+		}), []interface{}{"bob", "data1", "read"}, false, true},
+	})
+}
+
+func TestCacheRoleLinkChanges(t *testing.T) {
+	testCachePolicyChanges(t, func() *CachedEnforcer {
+		e, _ := NewCachedEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+		return e
+	}, []testPolicyChange{
+		// The role links are rebuilt from a rule added to the model behind the back of the enforcer.
+		{"BuildRoleLinks", testChanged(func(e *CachedEnforcer) error {
+			e.GetModel().AddPolicy("g", "g", []string{"bob", "data2_admin"})
+			return e.BuildRoleLinks()
+		}), []interface{}{"bob", "data2", "read"}, false, true},
+		{"BuildIncrementalRoleLinks", testChanged(func(e *CachedEnforcer) error {
+			return e.BuildIncrementalRoleLinks(model.PolicyAdd, "g", [][]string{{"bob", "data2_admin"}})
+		}), []interface{}{"bob", "data2", "read"}, false, true},
+	})
+}
+
+func TestCacheFilteredPolicy(t *testing.T) {
+	testCachePolicyChanges(t, func() *CachedEnforcer {
+		e, _ := NewCachedEnforcer("examples/rbac_with_domains_model.conf",
+			fileadapter.NewFilteredAdapter("examples/rbac_with_domains_policy.csv"))
+		_ = e.LoadPolicy()
+		return e
+	}, []testPolicyChange{
+		{"LoadFilteredPolicy", testChanged(func(e *CachedEnforcer) error {
+			return e.LoadFilteredPolicy(&fileadapter.Filter{P: []string{"", "domain1"}, G: []string{"", "", "domain1"}})
+		}), []interface{}{"bob", "domain2", "data2", "read"}, true, false},
+	})
+
+	testCachePolicyChanges(t, func() *CachedEnforcer {
+		e, _ := NewCachedEnforcer("examples/rbac_with_domains_model.conf",
+			fileadapter.NewFilteredAdapter("examples/rbac_with_domains_policy.csv"))
+		_ = e.LoadFilteredPolicy(&fileadapter.Filter{P: []string{"", "domain1"}, G: []string{"", "", "domain1"}})
+		return e
+	}, []testPolicyChange{
+		{"LoadIncrementalFilteredPolicy", testChanged(func(e *CachedEnforcer) error {
+			return e.LoadIncrementalFilteredPolicy(&fileadapter.Filter{P: []string{"", "domain2"}, G: []string{"", "", "domain2"}})
+		}), []interface{}{"bob", "domain2", "data2", "read"}, false, true},
+	})
+}
+
+func TestCacheFunctionChanges(t *testing.T) {
+	// The functions may change the decisions the next time they are evaluated, or once the role links are rebuilt.
+	e, _ := NewCachedEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+	rvals := []interface{}{"alice", "domain1", "data1", "read"}
+	tests := []struct {
+		name   string
+		change func()
+	}{
+		{"AddFunction", func() {
+			e.AddFunction("allowed", func(args ...interface{}) (interface{}, error) { return true, nil })
+		}},
+		{"AddNamedMatchingFunc", func() { e.AddNamedMatchingFunc("g", "KeyMatch", util.KeyMatch) }},
+		{"AddNamedDomainMatchingFunc", func() { e.AddNamedDomainMatchingFunc("g", "KeyMatch", util.KeyMatch) }},
+		{"SetRoleManager", func() { e.SetRoleManager(defaultrolemanager.NewRoleManager(10)) }},
+	}
+	for _, tt := range tests {
+		if res, _ := e.Enforce(rvals...); !res {
+			t.Fatalf("%s: %v is denied, supposed to be allowed", tt.name, rvals)
+		}
+		tt.change()
+		if e.IsCached(rvals...) {
+			t.Errorf("%s: the decision of %v is still cached", tt.name, rvals)
+		}
+	}
+}
+
+type testContextCache struct {
+	persist.Cache
+	ctxs []context.Context
+}
+
+func (c *testContextCache) SetCtx(ctx context.Context, key string, value bool, extra ...interface{}) error {
+	c.ctxs = append(c.ctxs, ctx)
+	return c.Cache.Set(key, value, extra...)
+}
+
+func (c *testContextCache) GetCtx(ctx context.Context, key string) (bool, error) {
+	c.ctxs = append(c.ctxs, ctx)
+	return c.Cache.Get(key)
+}
+
+func TestCacheEnforceCtx(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if res, err := e.EnforceCtx(ctx, "alice", "data1", "read"); res || err != context.Canceled {
+		t.Errorf("EnforceCtx returned %t, %v, supposed to be false, %v", res, err, context.Canceled)
+	}
+	if stats := e.CacheStats(); stats != (CacheStats{}) {
+		t.Errorf("cancelled EnforceCtx should not touch the cache, stats: %+v", stats)
+	}
+
+	// A context-aware cache is given the context of the request.
+	c := &testContextCache{Cache: cache.NewDefaultCache()}
+	e.SetCache(c)
+	ctx = context.WithValue(context.Background(), testContextKey{}, "request")
+	if res, err := e.EnforceCtx(ctx, "alice", "data1", "read"); !res || err != nil {
+		t.Errorf("EnforceCtx returned %t, %v, supposed to be true, nil", res, err)
+	}
+	if len(c.ctxs) != 2 || c.ctxs[0] != ctx || c.ctxs[1] != ctx {
+		t.Errorf("cache was given %d contexts, supposed to be the request one for GetCtx and SetCtx", len(c.ctxs))
+	}
+}
+
+type testContextKey struct{}
+
+func TestCacheEnforceCtxGetOrSet(t *testing.T) {
+	// The default cache looks the decisions up with GetOrSet.
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The context is done after it's checked on the way in, but before the evaluation.
+	e.SetOnMiss(func(key string, rvals []interface{}) { cancel() })
+	if res, err := e.EnforceCtx(ctx, "alice", "data1", "read"); res || err != context.Canceled {
+		t.Errorf("EnforceCtx returned %t, %v, supposed to be false, %v", res, err, context.Canceled)
+	}
+	if n := e.CacheLen(); n != 0 {
+		t.Errorf("cache holds %d decisions after a cancelled evaluation, supposed to be 0", n)
+	}
+
+	e.SetOnMiss(nil)
+	if res, err := e.EnforceCtx(context.Background(), "alice", "data1", "read"); !res || err != nil {
+		t.Errorf("EnforceCtx returned %t, %v, supposed to be true, nil", res, err)
+	}
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+}
+
+func TestCacheBatchEnforce(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "bob", "data2", "read", false)
+	e.ResetCacheStats()
+
+	requests := [][]interface{}{
+		{"alice", "data1", "read"},
+		{"alice", "data1", "write"},
+		{"bob", "data2", "read"},
+		{"bob", 2, "write"},
+		{"bob", "data2", "write"},
+		{"alice", "data1", "write"},
+	}
+	results, err := e.BatchEnforce(requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bool{true, false, false, false, true, false}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("%v: %t, supposed to be %t", requests[i], results[i], want[i])
+		}
+	}
+	if stats := e.CacheStats(); stats != (CacheStats{Hits: 2, Misses: 3, Bypasses: 1}) {
+		t.Errorf("stats: %+v, supposed to be %+v", stats, CacheStats{Hits: 2, Misses: 3, Bypasses: 1})
+	}
+
+	// The misses are cached by the batch.
+	if _, err := e.BatchEnforce(requests); err != nil {
+		t.Fatal(err)
+	}
+	if stats := e.CacheStats(); stats.Hits != 7 || stats.Misses != 3 {
+		t.Errorf("stats: %+v, supposed to have 7 hits and 3 misses", stats)
+	}
+}
+
+// testBatchCache counts the bulk calls made to it.
+type testBatchCache struct {
+	*cache.DefaultCache
+	getMany, setMany int
+}
+
+func (c *testBatchCache) GetMany(keys []string) (map[string]bool, error) {
+	c.getMany++
+	return c.DefaultCache.GetMany(keys)
+}
+
+func (c *testBatchCache) SetMany(entries map[string]bool, extra ...interface{}) error {
+	c.setMany++
+	return c.DefaultCache.SetMany(entries, extra...)
+}
+
+func TestCacheBatchEnforceWithBatchCache(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	c := &testBatchCache{DefaultCache: cache.NewDefaultCache()}
+	e.SetCache(c)
+
+	requests := [][]interface{}{
+		{"alice", "data1", "read"},
+		{"bob", "data2", "write"},
+		{"bob", "data2", "read"},
+	}
+	for i := 0; i < 2; i++ {
+		results, err := e.BatchEnforce(requests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !results[0] || !results[1] || results[2] {
+			t.Errorf("results: %v, supposed to be [true true false]", results)
+		}
+	}
+	// The second batch is all hits, so there is nothing to store.
+	if c.getMany != 2 || c.setMany != 1 {
+		t.Errorf("%d GetMany and %d SetMany calls, supposed to be 2 and 1", c.getMany, c.setMany)
+	}
+	if stats := e.CacheStats(); stats.Hits != 3 || stats.Misses != 3 {
+		t.Errorf("stats: %+v, supposed to have 3 hits and 3 misses", stats)
+	}
+}
+
+func TestCacheWarmCache(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	requests := [][]interface{}{
+		{"alice", "data1", "read"},
+		{"bob", "data2", "write"},
+		{"bob", "data2", "read"},
+		{"bob", "data2", "read"},
+		{"bob", 2, "write"},
+	}
+	if err := e.WarmCache(requests); err != nil {
+		t.Fatal(err)
+	}
+	// The request with a non-string value is skipped.
+	if n := e.CacheLen(); n != 3 {
+		t.Errorf("CacheLen: %d, supposed to be 3", n)
+	}
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, true)
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "read", false, true)
+
+	// A failing request doesn't stop the others from being cached.
+	_ = e.InvalidateCache()
+	err := e.WarmCache([][]interface{}{{"alice", "data1"}, {"alice", "data1", "read"}})
+	if err == nil {
+		t.Error("WarmCache is supposed to fail for a request with a missing value")
+	}
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+}
+
+func TestCacheNegativeResults(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.CacheNegativeResults(false)
+
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+	// The denial is not stored, so it is evaluated every time.
+	testEnforceWithCacheInfo(t, e, "alice", "data2", "read", false, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data2", "read", false, false)
+	if _, err := e.cache.Get("5:alice$$5:data2$$4:read$$"); err != persist.ErrNoSuchKey {
+		t.Errorf("the denial is cached, error %v, supposed to be %v", err, persist.ErrNoSuchKey)
+	}
+
+	// A grant is seen right away, even when the policy is changed behind the cache.
+	_, _ = e.Enforcer.AddPolicy("alice", "data2", "read")
+	testEnforceCache(t, e, "alice", "data2", "read", true)
+
+	results, _ := e.BatchEnforce([][]interface{}{{"bob", "data2", "write"}, {"bob", "data1", "write"}})
+	if !results[0] || results[1] {
+		t.Errorf("results: %v, supposed to be [true false]", results)
+	}
+	if n := e.CacheLen(); n != 3 {
+		t.Errorf("CacheLen: %d, supposed to be 3 with the grants only", n)
+	}
+
+	e.CacheNegativeResults(true)
+	testEnforceWithCacheInfo(t, e, "bob", "data1", "write", false, false)
+	testEnforceWithCacheInfo(t, e, "bob", "data1", "write", false, true)
+}
+
+func TestCacheLen(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+	if n := e.CacheLen(); n != 2 {
+		t.Errorf("CacheLen: %d, supposed to be 2", n)
+	}
+
+	_ = e.InvalidateCache()
+	if n := e.CacheLen(); n != 0 {
+		t.Errorf("CacheLen: %d, supposed to be 0", n)
+	}
+
+	e.SetCache(&testContextCache{Cache: cache.NewDefaultCache()})
+	if n := e.CacheLen(); n != -1 {
+		t.Errorf("CacheLen: %d, supposed to be -1 for a cache without Len", n)
+	}
+}
+
+func TestCacheKeySeparator(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	if key, _ := e.getKey("alice", "data1", "read"); key != "5:alice$$5:data1$$4:read$$" {
+		t.Errorf("key: %s, supposed to be %s", key, "5:alice$$5:data1$$4:read$$")
+	}
+	// Values holding the separator don't collide.
+	key1, _ := e.getKey("a$$b", "c")
+	key2, _ := e.getKey("a", "b$$c")
+	if key1 == key2 {
+		t.Errorf("%v and %v share the key %s", []string{"a$$b", "c"}, []string{"a", "b$$c"}, key1)
+	}
+
+	e.SetKeySeparator("")
+	key1, _ = e.getKey("ab", "c")
+	key2, _ = e.getKey("a", "bc")
+	if key1 == key2 {
+		t.Errorf("%v and %v share the key %s", []string{"ab", "c"}, []string{"a", "bc"}, key1)
+	}
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	if stats := e.CacheStats(); stats.Hits != 1 {
+		t.Errorf("hits: %d, supposed to be 1", stats.Hits)
+	}
+}
+
+func TestCacheKeyFunc(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.SetKeyFunc(func(rvals ...interface{}) (string, bool) {
+		if len(rvals) != 3 {
+			return "", false
+		}
+		sub, ok := rvals[0].(string)
+		if !ok || sub == "bob" {
+			return "", false
+		}
+		return fmt.Sprintf("%s/%v/%v", strings.ToLower(sub), rvals[1], rvals[2]), true
+	})
+
+	// Alice and alice share the cached decision.
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "Alice", "data1", "read", true)
+	if stats := e.CacheStats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("stats: %+v, supposed to have 1 hit and 1 miss", stats)
+	}
+
+	// The key func returning false forces a bypass.
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+	if stats := e.CacheStats(); stats.Bypasses != 2 || e.CacheLen() != 1 {
+		t.Errorf("stats: %+v, supposed to have 2 bypasses and 1 cached decision", stats)
+	}
+
+	e.SetKeyFunc(nil)
+	if key, _ := e.getKey("alice", "data1", "read"); key != "5:alice$$5:data1$$4:read$$" {
+		t.Errorf("key: %s, supposed to be the built-in one", key)
+	}
+}
+
+// testKeysCache hides all the optional interfaces of a cache but persist.KeysCache.
+type testKeysCache struct {
+	persist.Cache
+}
+
+func (c testKeysCache) Keys() []string {
+	return c.Cache.(persist.KeysCache).Keys()
+}
+
+func TestCacheInvalidateForSubject(t *testing.T) {
+	for _, c := range []persist.Cache{cache.NewDefaultCache(), testKeysCache{cache.NewDefaultCache()}} {
+		e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+		e.SetCache(c)
+
+		testEnforceCache(t, e, "alice", "data1", "read", true)
+		testEnforceCache(t, e, "alice", "data2", "read", false)
+		testEnforceCache(t, e, "aliceb", "data1", "read", false)
+		testEnforceCache(t, e, "bob", "data2", "write", true)
+
+		if err := e.InvalidateCacheForSubject("alice"); err != nil {
+			t.Fatal(err)
+		}
+		if n := e.CacheLen(); n != -1 && n != 2 {
+			t.Errorf("CacheLen: %d, supposed to be 2", n)
+		}
+		keys := c.(persist.KeysCache).Keys()
+		if len(keys) != 2 {
+			t.Errorf("keys: %v, supposed to be the ones of aliceb and bob", keys)
+		}
+		for _, key := range keys {
+			if strings.HasPrefix(key, "5:alice$$") {
+				t.Errorf("key %s of alice should be invalidated", key)
+			}
+		}
+	}
+}
+
+// testWrappingCache wraps the errors of its inner cache, like a remote cache adding context to them.
+type testWrappingCache struct {
+	persist.Cache
+}
+
+func (c testWrappingCache) Get(key string) (bool, error) {
+	res, err := c.Cache.Get(key)
+	if err != nil {
+		return res, fmt.Errorf("get %s: %w", key, err)
+	}
+	return res, nil
+}
+
+func (c testWrappingCache) Delete(key string) error {
+	if err := c.Cache.Delete(key); err != nil {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	return nil
+}
+
+func TestCacheWrappedNoSuchKey(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.SetCache(testWrappingCache{cache.NewDefaultCache()})
+	// Without fail-open, a cache error which is not a miss is returned.
+	e.FailOpenOnCacheError(false)
+
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+	if stats := e.CacheStats(); stats.Misses != 1 || stats.Errors != 0 {
+		t.Errorf("stats: %+v, supposed to have 1 miss and no error", stats)
+	}
+
+	if _, err := e.RemovePolicy("alice", "data1", "read"); err != nil {
+		t.Fatal(err)
+	}
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", false, false)
+	if ok, err := e.RemovePolicies([][]string{{"bob", "data2", "write"}}); !ok || err != nil {
+		t.Fatalf("RemovePolicies: %t, %v", ok, err)
+	}
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", false, false)
+}
+
+func TestCacheInvalidateForObject(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		cache     persist.Cache
+		sep       string
+		namespace string
+	}{
+		{"segment cache", cache.NewDefaultCache(), DefaultKeySeparator, ""},
+		{"keys cache", testKeysCache{cache.NewDefaultCache()}, DefaultKeySeparator, ""},
+		{"separator", cache.NewDefaultCache(), "|", ""},
+		{"namespace", cache.NewDefaultCache(), DefaultKeySeparator, "tenant1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+			e.SetCache(tc.cache)
+			e.SetKeySeparator(tc.sep)
+			e.SetCacheNamespace(tc.namespace)
+
+			testEnforceCache(t, e, "alice", "data1", "read", true)
+			testEnforceCache(t, e, "bob", "data1", "read", false)
+			testEnforceCache(t, e, "data1", "data2", "read", false)
+			testEnforceCache(t, e, "bob", "data2", "write", true)
+
+			if err := e.InvalidateCacheForObject("data1"); err != nil {
+				t.Fatal(err)
+			}
+			for _, rvals := range [][]interface{}{{"alice", "data1", "read"}, {"bob", "data1", "read"}} {
+				if e.IsCached(rvals...) {
+					t.Errorf("decision of %v should be invalidated", rvals)
+				}
+			}
+			for _, rvals := range [][]interface{}{{"data1", "data2", "read"}, {"bob", "data2", "write"}} {
+				if !e.IsCached(rvals...) {
+					t.Errorf("decision of %v should still be cached", rvals)
+				}
+			}
+		})
+	}
+}
+
+// testSegmentCache counts the native deletions by segment of a cache which can also list its keys.
+type testSegmentCache struct {
+	*cache.DefaultCache
+	deletions int
+}
+
+func (c *testSegmentCache) DeleteBySegment(index int, value string) error {
+	c.deletions++
+	return c.DefaultCache.DeleteBySegment(index, value)
+}
+
+func TestCacheInvalidateForObjectSegmentCache(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	c := &testSegmentCache{DefaultCache: cache.NewDefaultCache()}
+	e.SetCache(c)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+
+	// The native deletion is preferred to scanning the keys.
+	if err := e.InvalidateCacheForObject("data1"); err != nil || c.deletions != 1 {
+		t.Errorf("%d deletions by segment, %v, supposed to be 1", c.deletions, err)
+	}
+
+	// The keys in a namespace are scanned instead.
+	e.SetCacheNamespace("tenant1")
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	if err := e.InvalidateCacheForObject("data1"); err != nil || c.deletions != 1 {
+		t.Errorf("%d deletions by segment, %v, supposed to be still 1", c.deletions, err)
+	}
+	if e.IsCached("alice", "data1", "read") {
+		t.Error("decision of alice, data1, read should be invalidated")
+	}
+}
+
+func TestCacheInvalidateForDomain(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+	requests := [][]interface{}{
+		{"alice", "domain1", "data1", "read"},
+		{"bob", "domain1", "data1", "read"},
+		{"bob", "domain2", "data2", "read"},
+		{"alice", "domain2", "domain1", "read"},
+	}
+	if err := e.WarmCache(requests); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.InvalidateCacheForDomain("domain1"); err != nil {
+		t.Fatal(err)
+	}
+	for i, rvals := range requests {
+		if cached := e.IsCached(rvals...); cached != (i >= 2) {
+			t.Errorf("decision of %v cached: %t, supposed to be %t", rvals, cached, i >= 2)
+		}
+	}
+
+	// The domain may be at another position of the requests.
+	e.SetDomainIndex(2)
+	if err := e.InvalidateCacheForDomain("domain1"); err != nil {
+		t.Fatal(err)
+	}
+	if e.IsCached(requests[3]...) || !e.IsCached(requests[2]...) {
+		t.Error("only the decisions of the requests with domain1 at index 2 should be invalidated")
+	}
+}
+
+func testEnforceWithCacheInfo(t *testing.T, e *CachedEnforcer, sub string, obj interface{}, act string, res bool, hit bool) {
+	t.Helper()
+	myRes, myHit, err := e.EnforceWithCacheInfo(sub, obj, act)
+	if err != nil {
+		t.Errorf("%s, %v, %s: %v", sub, obj, act, err)
+	}
+	if myRes != res || myHit != hit {
+		t.Errorf("%s, %v, %s: %t, hit %t, supposed to be %t, hit %t", sub, obj, act, myRes, myHit, res, hit)
+	}
+}
+
+func TestCacheEnforceWithCacheInfo(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+	testEnforceWithCacheInfo(t, e, "alice", 1, "read", false, false)
+
+	e.EnableCache(false)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+}
+
+func TestCacheEnforceEx(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	for i := 0; i < 2; i++ {
+		res, explain, err := e.EnforceEx("alice", "data1", "read")
+		if err != nil || !res || !reflect.DeepEqual(explain, []string{"alice", "data1", "read"}) {
+			t.Errorf("EnforceEx: %t, %v, %v", res, explain, err)
+		}
+		// Modifying the returned explanation doesn't alter the cached one.
+		explain[0] = "bob"
+	}
+	if stats := e.CacheStats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("stats: %+v, supposed to have 1 hit and 1 miss", stats)
+	}
+
+	// The cached explanations are invalidated along with the decisions.
+	_, _ = e.RemovePolicy("alice", "data1", "read")
+	res, explain, err := e.EnforceEx("alice", "data1", "read")
+	if err != nil || res || len(explain) != 0 {
+		t.Errorf("EnforceEx: %t, %v, %v, supposed to be false without explanation", res, explain, err)
+	}
+}
+
+func TestCacheEnforceWithMatcher(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	matcher := "r.sub == p.sub && r.obj == p.obj"
+
+	if res, _ := e.Enforce("alice", "data1", "write"); res {
+		t.Error("alice is not supposed to write data1 with the model matcher")
+	}
+	// The decision of the model matcher is not shared with the custom matcher.
+	if res, _ := e.EnforceWithMatcher(matcher, "alice", "data1", "write"); !res {
+		t.Error("alice is supposed to write data1 with the custom matcher")
+	}
+	if res, _ := e.EnforceWithMatcher(matcher, "alice", "data1", "write"); !res {
+		t.Error("alice is supposed to write data1 with the cached custom matcher decision")
+	}
+	if stats := e.CacheStats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("stats: %+v, supposed to have 1 hit and 2 misses", stats)
+	}
+	if n := e.CacheLen(); n != 2 {
+		t.Errorf("CacheLen: %d, supposed to be 2", n)
+	}
+
+	// The custom matcher decisions are invalidated by subject as well.
+	_ = e.InvalidateCacheForSubject("alice")
+	if n := e.CacheLen(); n != 0 {
+		t.Errorf("CacheLen: %d, supposed to be 0", n)
+	}
+
+	e.EnableNonStringKeys(false)
+	_, _ = e.EnforceWithMatcher(matcher, 1, "data1", "write")
+	if stats := e.CacheStats(); stats.Bypasses != 1 {
+		t.Errorf("stats: %+v, supposed to have 1 bypass", stats)
+	}
+}
+
+func TestCacheSaveLoad(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.SetExpireTime(60)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "bob", "data2", "read", false)
+	e.SetExpireTime(0)
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+
+	var saved strings.Builder
+	if err := e.SaveCache(&saved); err != nil {
+		t.Fatal(err)
+	}
+
+	e2, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	if err := e2.LoadCache(strings.NewReader(saved.String())); err != nil {
+		t.Fatal(err)
+	}
+	if n := e2.CacheLen(); n != 3 {
+		t.Errorf("CacheLen: %d, supposed to be 3", n)
+	}
+	testEnforceWithCacheInfo(t, e2, "alice", "data1", "read", true, true)
+	testEnforceWithCacheInfo(t, e2, "bob", "data2", "read", false, true)
+	testEnforceWithCacheInfo(t, e2, "bob", "data2", "write", true, true)
+	c := e2.cache.(*cache.DefaultCache)
+	if ttl, _ := c.TTL("5:alice$$5:data1$$4:read$$"); ttl <= 0 || ttl > 60*time.Second {
+		t.Errorf("restored TTL is %v, supposed to be at most 60s", ttl)
+	}
+	if ttl, _ := c.TTL("3:bob$$5:data2$$5:write$$"); ttl != 0 {
+		t.Errorf("restored TTL is %v, supposed to never expire", ttl)
+	}
+
+	// Expired decisions are dropped.
+	e3, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	expired := `{"version":1,"entries":[{"key":"5:alice$$5:data1$$4:read$$","value":false,"expires_at":"2020-01-01T00:00:00Z"}]}`
+	if err := e3.LoadCache(strings.NewReader(expired)); err != nil {
+		t.Fatal(err)
+	}
+	if n := e3.CacheLen(); n != 0 {
+		t.Errorf("CacheLen: %d, supposed to be 0", n)
+	}
+}
+
+func TestCacheLoadCorrupt(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	for _, input := range []string{
+		``,
+		`{"version":1,"entries":[{"key":"5:alice$$5:data1$$4:read$$","value":true}`,
+		`{"version":2,"entries":[]}`,
+		`{"version":1,"entries":[{"key":"5:alice$$5:data1$$4:read$$","value":"yes"}]}`,
+		`{"version":1,"entries":[{"key":"5:alice$$5:data1$$4:read$$","value":true},{"key":"","value":true}]}`,
+	} {
+		if err := e.LoadCache(strings.NewReader(input)); err == nil {
+			t.Errorf("LoadCache(%q) is supposed to fail", input)
+		}
+	}
+	// Nothing is loaded from an invalid input.
+	if n := e.CacheLen(); n != 0 {
+		t.Errorf("CacheLen: %d, supposed to be 0", n)
+	}
+
+	e.SetCache(cache.NewLRUCache(10))
+	if err := e.SaveCache(&strings.Builder{}); err == nil {
+		t.Error("SaveCache is supposed to fail for a cache which can't list its entries")
+	}
+}
+
+func TestCacheHashKeys(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.HashKeys(SHA256KeyHasher)
+
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data2", "read", false, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+	testEnforceWithCacheInfo(t, e, "alice", "data2", "read", false, true)
+
+	keys := e.cache.(*cache.DefaultCache).Keys()
+	if len(keys) != 2 || keys[0] == keys[1] {
+		t.Fatalf("keys: %v, supposed to be 2 distinct hashes", keys)
+	}
+	for _, key := range keys {
+		if len(key) != 64 {
+			t.Errorf("key %q is supposed to be a hex-encoded SHA-256 digest", key)
+		}
+	}
+	if _, err := e.cache.Get(SHA256KeyHasher("5:alice$$5:data1$$4:read$$")); err != nil {
+		t.Errorf("the decision is supposed to be stored under the hash of its key: %v", err)
+	}
+
+	// Hashed keys can't be matched by subject, so all the decisions are invalidated.
+	_ = e.InvalidateCacheForSubject("bob")
+	if n := e.CacheLen(); n != 0 {
+		t.Errorf("CacheLen: %d, supposed to be 0", n)
+	}
+}
+
+func TestCacheBypassCacheForSubjects(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	e.BypassCacheForSubjects("alice")
+
+	testEnforceWithCacheInfo(t, e, "alice", "data2", "read", true, false)
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, false)
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, true)
+	if n := e.CacheLen(); n != 1 {
+		t.Errorf("CacheLen: %d, supposed to be 1 without alice's decision", n)
+	}
+
+	// alice always sees a fresh decision, even when the roles change behind the cache.
+	_, _ = e.Enforcer.DeleteRoleForUser("alice", "data2_admin")
+	testEnforceWithCacheInfo(t, e, "alice", "data2", "read", false, false)
+	if stats := e.CacheStats(); stats.Bypasses != 2 {
+		t.Errorf("stats: %+v, supposed to have 2 bypasses", stats)
+	}
+
+	e.BypassCacheForSubjects()
+	testEnforceWithCacheInfo(t, e, "alice", "data2", "read", false, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data2", "read", false, true)
+}
+
+func TestCacheSetCacheNil(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	var nilCache *cache.DefaultCache
+	for _, c := range []persist.Cache{nil, nilCache} {
+		if err := e.SetCacheSafe(c); err == nil {
+			t.Errorf("SetCacheSafe(%#v) is supposed to fail", c)
+		}
+	}
+	// The cache is left unchanged.
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("SetCache(nil) is supposed to panic")
+		}
+	}()
+	e.SetCache(nil)
+}
+
+func TestCacheSetCacheConcurrently(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			if i%2 == 0 {
+				e.SetCache(cache.NewLRUCache(10))
+			} else {
+				e.SetCache(cache.NewDefaultCache())
+			}
+			e.SetExpireTime(uint(i % 3))
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			testEnforceCache(t, e, "alice", "data1", "read", true)
+			return
+		default:
+			testEnforceCache(t, e, "alice", "data1", "read", true)
+			testEnforceCache(t, e, "bob", "data2", "write", true)
+			_, _ = e.BatchEnforce([][]interface{}{{"alice", "data2", "read"}})
+		}
+	}
+}
+
+func TestCacheSetOnMiss(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	var misses []string
+	e.SetOnMiss(func(key string, rvals []interface{}) {
+		misses = append(misses, fmt.Sprint(rvals...))
+		// The hook may call back into the enforcer.
+		_ = e.CacheLen()
+	})
+
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "alice", 1, "read", false)
+	_, _ = e.BatchEnforce([][]interface{}{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+
+	want := []string{fmt.Sprint("alice", "data1", "read"), fmt.Sprint("bob", "data2", "write")}
+	if strings.Join(misses, "|") != strings.Join(want, "|") {
+		t.Errorf("misses: %q, supposed to be %q", misses, want)
+	}
+
+	e.SetOnMiss(nil)
+	testEnforceCache(t, e, "bob", "data2", "read", false)
+	if len(misses) != 2 {
+		t.Errorf("the hook is supposed to be removed, %d misses", len(misses))
+	}
+}
+
+func TestCacheGetOrSet(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	var misses int32
+	e.SetOnMiss(func(key string, rvals []interface{}) {
+		atomic.AddInt32(&misses, 1)
+		// Keep the evaluation running while the other requests come in.
+		time.Sleep(50 * time.Millisecond)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res, _ := e.Enforce("alice", "data1", "read"); !res {
+				t.Error("alice is supposed to read data1")
+			}
+		}()
+	}
+	wg.Wait()
+	if misses != 1 {
+		t.Errorf("%d evaluations, supposed to be 1 for concurrent identical requests", misses)
+	}
+	if stats := e.CacheStats(); stats.Hits != 9 || stats.Misses != 1 {
+		t.Errorf("stats: %+v, supposed to have 9 hits and 1 miss", stats)
+	}
+}
+
+// testWrappingGetOrSetCache wraps the errors of GetOrSet and records the extra parameters of its Set.
+type testWrappingGetOrSetCache struct {
+	*cache.DefaultCache
+	extra []interface{}
+}
+
+func (c *testWrappingGetOrSetCache) Set(key string, value bool, extra ...interface{}) error {
+	c.extra = append([]interface{}(nil), extra...)
+	return c.DefaultCache.Set(key, value, extra...)
+}
+
+func (c *testWrappingGetOrSetCache) GetOrSet(key string, compute func() (bool, error), extra ...interface{}) (bool, error) {
+	if res, err := c.Get(key); err == nil {
+		return res, nil
+	}
+	res, err := compute()
+	if err != nil {
+		return false, fmt.Errorf("compute %s: %w", key, err)
+	}
+	return res, c.Set(key, res, extra...)
+}
+
+func TestCacheGetOrSetErrors(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	c := &testWrappingGetOrSetCache{DefaultCache: cache.NewDefaultCache()}
+	e.SetCache(c)
+	e.FailOpenOnCacheError(false)
+
+	// The evaluation error is told apart from an error of the cache, even wrapped.
+	_, err := e.EnforceWithMatcher("r.sub ==", "alice", "data1", "read")
+	var cacheErr *CacheError
+	if err == nil || errors.As(err, &cacheErr) {
+		t.Errorf("EnforceWithMatcher returned %v, supposed to be the error of the evaluation", err)
+	}
+
+	// The cost of the evaluation is passed to the cache like on the other path.
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	if len(c.extra) != 2 {
+		t.Fatalf("Set was given %v, supposed to be the survival time and the cost", c.extra)
+	}
+	if cost, ok := c.extra[1].(time.Duration); !ok || cost <= 0 {
+		t.Errorf("Set was given the cost %v, supposed to be the evaluation time", c.extra[1])
+	}
+}
+
+func TestCacheSingleFlight(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	// LRUCache doesn't implement persist.GetOrSetCache, so the enforcer runs the single flight itself.
+	e.SetCache(cache.NewLRUCache(10))
+	var misses int32
+	e.SetOnMiss(func(key string, rvals []interface{}) {
+		atomic.AddInt32(&misses, 1)
+		time.Sleep(50 * time.Millisecond)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if res, _ := e.Enforce("alice", "data2", "read"); !res {
+				t.Error("alice is supposed to read data2")
+			}
+			// Non-string requests bypass the cache and the single flight.
+			if res, _ := e.Enforce("alice", i, "read"); res {
+				t.Error("alice is not supposed to read a non-string object")
+			}
+		}(i)
+	}
+	wg.Wait()
+	if misses != 1 {
+		t.Errorf("%d evaluations, supposed to be 1 for concurrent identical requests", misses)
+	}
+	if stats := e.CacheStats(); stats.Hits != 9 || stats.Misses != 1 || stats.Bypasses != 10 {
+		t.Errorf("stats: %+v, supposed to have 9 hits, 1 miss and 10 bypasses", stats)
+	}
+}
+
+func TestCacheCloneCache(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.SetExpireTime(60)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "bob", "data2", "read", false)
+
+	clone, err := e.CloneCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := clone.(*cache.DefaultCache).TTL("5:alice$$5:data1$$4:read$$"); ttl <= 59*time.Second || ttl > 60*time.Second {
+		t.Errorf("cloned TTL is %v, supposed to be about 60s", ttl)
+	}
+
+	e2, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e2.SetCache(clone)
+	testEnforceWithCacheInfo(t, e2, "alice", "data1", "read", true, true)
+	testEnforceWithCacheInfo(t, e2, "bob", "data2", "read", false, true)
+
+	// The clone diverges from the original.
+	_, _ = e2.RemovePolicy("alice", "data1", "read")
+	testEnforceWithCacheInfo(t, e2, "alice", "data1", "read", false, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+	_ = clone.Set("5:carol$$5:data3$$4:read$$", true)
+	if _, err := e.cache.Get("5:carol$$5:data3$$4:read$$"); err != persist.ErrNoSuchKey {
+		t.Errorf("the original cache is supposed to be unaffected, error %v", err)
+	}
+
+	e.SetCache(cache.NewLRUCache(10))
+	if _, err := e.CloneCache(); err == nil {
+		t.Error("CloneCache is supposed to fail for a cache which can't list its entries")
+	}
+}
+
+func TestCacheIsCacheable(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	if !e.IsCacheable("alice", "data1", "read") {
+		t.Error("a request with string values is supposed to be cacheable")
+	}
+	if e.IsCacheable("alice", 1, "read") {
+		t.Error("a request with a non-string value is not supposed to be cacheable")
+	}
+	e.EnableNonStringKeys(true)
+	if !e.IsCacheable("alice", 1, "read") {
+		t.Error("a request with a non-string value is supposed to be cacheable with non-string keys")
+	}
+
+	e.BypassCacheForSubjects("bob")
+	if e.IsCacheable("bob", "data2", "write") {
+		t.Error("a request of a subject bypassing the cache is not supposed to be cacheable")
+	}
+
+	e.SetKeyFunc(func(rvals ...interface{}) (string, bool) {
+		return "", len(rvals) == 2
+	})
+	if e.IsCacheable("alice", "data1", "read") || !e.IsCacheable("alice", "data1") {
+		t.Error("cacheability is supposed to follow the key func")
+	}
+}
+
+// testCostCache records the extra parameters of Set.
+type testCostCache struct {
+	persist.Cache
+	extras map[string][]interface{}
+}
+
+func (c *testCostCache) Set(key string, value bool, extra ...interface{}) error {
+	c.extras[key] = extra
+	return c.Cache.Set(key, value, extra...)
+}
+
+func TestCacheEvaluationCost(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	c := &testCostCache{Cache: cache.NewCostCache(10), extras: make(map[string][]interface{})}
+	e.SetCache(c)
+	e.SetExpireTime(60)
+
+	testEnforceCache(t, e, "alice", "data2", "read", true)
+	extra := c.extras["5:alice$$5:data2$$4:read$$"]
+	if len(extra) != 2 || extra[0] != uint(60) {
+		t.Fatalf("Set was given %v, supposed to be the survival time and the cost", extra)
+	}
+	if cost, ok := extra[1].(time.Duration); !ok || cost <= 0 {
+		t.Errorf("cost is %v, supposed to be the positive evaluation time", extra[1])
+	}
+}
+
+func TestCacheEvaluationCostWrapped(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		wrap func(c persist.Cache) persist.Cache
+	}{
+		{"sharded", func(c persist.Cache) persist.Cache {
+			return cache.NewShardedCache(1, func() persist.Cache { return c })
+		}},
+		{"tiered", func(c persist.Cache) persist.Cache { return cache.NewTieredCache(c, cache.NewDefaultCache()) }},
+		{"compressing", func(c persist.Cache) persist.Cache { return cache.NewCompressingCache(c) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e, _ := NewCachedEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+			c := &testCostCache{Cache: cache.NewCostCache(10), extras: make(map[string][]interface{})}
+			e.SetCache(tc.wrap(c))
+			e.SetExpireTime(60)
+
+			// The drift found by VerifyCache is stored with the cost of the evaluation too.
+			testEnforceCache(t, e, "alice", "data2", "read", true)
+			testCacheCost(t, c.extras)
+			e.VerifyCache(1)
+			_, _ = e.Enforcer.RemoveGroupingPolicy("alice", "data2_admin")
+			c.extras = make(map[string][]interface{})
+			testEnforceCache(t, e, "alice", "data2", "read", false)
+			testCacheCost(t, c.extras)
+		})
+	}
+}
+
+// testCacheCost checks that the inner cache was set one key with extras, the survival time and the cost.
+func testCacheCost(t *testing.T, extras map[string][]interface{}) {
+	t.Helper()
+	if len(extras) != 1 {
+		t.Fatalf("the inner cache was set %v, supposed to be set one key", extras)
+	}
+	for key, extra := range extras {
+		if len(extra) != 2 || extra[0] != uint(60) {
+			t.Fatalf("Set of %s was given %v, supposed to be the survival time and the cost", key, extra)
+		}
+		if cost, ok := extra[1].(time.Duration); !ok || cost <= 0 {
+			t.Errorf("cost is %v, supposed to be the positive evaluation time", extra[1])
+		}
+	}
+}
+
+func TestCacheEnforceWithReason(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/rbac_with_deny_model.conf", "examples/rbac_with_deny_policy.csv")
+
+	tests := []struct {
+		sub, obj, act string
+		res           bool
+		reason        Reason
+	}{
+		{"alice", "data1", "read", true, ReasonAllow},
+		{"alice", "data2", "write", false, ReasonDeny},
+		{"bob", "data1", "read", false, ReasonNoMatch},
+	}
+	for i := 0; i < 2; i++ {
+		for _, tt := range tests {
+			res, reason, err := e.EnforceWithReason(tt.sub, tt.obj, tt.act)
+			if err != nil || res != tt.res || reason != tt.reason {
+				t.Errorf("%s, %s, %s: %t, %s, %v, supposed to be %t, %s", tt.sub, tt.obj, tt.act, res, reason, err, tt.res, tt.reason)
+			}
+		}
+	}
+	// The second round is served from the cache.
+	if stats := e.CacheStats(); stats.Hits != 3 || stats.Misses != 3 {
+		t.Errorf("stats: %+v, supposed to have 3 hits and 3 misses", stats)
+	}
+}
+
+func TestCacheEnforceWithReasonEffects(t *testing.T) {
+	rules := [][]string{
+		{"alice", "data1", "read", "allow"},
+		{"alice", "data1", "write", "allow"},
+		{"alice", "data1", "write", "deny"},
+		{"bob", "data2", "read", "deny"},
+	}
+	type request struct {
+		sub, obj, act string
+		res           bool
+		reason        Reason
+	}
+	tests := []struct {
+		effect   string
+		requests []request
+	}{
+		{"some(where (p.eft == allow)) && !some(where (p.eft == deny))", []request{
+			// No explanation for an allow, the effector only explains the deny rules.
+			{"alice", "data1", "read", true, ReasonAllow},
+			{"alice", "data1", "write", false, ReasonDeny},
+			{"bob", "data2", "read", false, ReasonDeny},
+			{"bob", "data1", "read", false, ReasonNoMatch},
+		}},
+		{"some(where (p.eft == allow)) || !some(where (p.eft == deny))", []request{
+			// No explanation for the deny, the effector only explains the allow rules.
+			{"bob", "data2", "read", false, ReasonDeny},
+			{"bob", "data1", "read", true, ReasonAllow},
+		}},
+	}
+	for _, tt := range tests {
+		m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act, eft
+
+[policy_effect]
+e = ` + tt.effect + `
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`)
+		e, err := NewCachedEnforcer(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.AddPolicies(rules); err != nil {
+			t.Fatal(err)
+		}
+		// The second round is served from the cache.
+		for i := 0; i < 2; i++ {
+			for _, r := range tt.requests {
+				res, reason, err := e.EnforceWithReason(r.sub, r.obj, r.act)
+				if err != nil || res != r.res || reason != r.reason {
+					t.Errorf("%s: %s, %s, %s: %t, %s, %v, supposed to be %t, %s", tt.effect, r.sub, r.obj, r.act, res, reason, err, r.res, r.reason)
+				}
+			}
+		}
+		if stats := e.CacheStats(); stats.Hits != uint64(len(tt.requests)) {
+			t.Errorf("%s: stats: %+v, supposed to have %d hits", tt.effect, stats, len(tt.requests))
+		}
+	}
+}
+
+// testFailingCache fails to Clear.
+type testFailingCache struct {
+	persist.Cache
+}
+
+var errTestClear = fmt.Errorf("clear failed")
+
+func (c testFailingCache) Clear() error {
+	return errTestClear
+}
+
+func TestCacheInvalidateCacheAsync(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+
+	if err := <-e.InvalidateCacheAsync(); err != nil {
+		t.Fatal(err)
+	}
+	if n := e.CacheLen(); n != 0 {
+		t.Errorf("CacheLen: %d, supposed to be 0 once the invalidation is done", n)
+	}
+	// Decisions stored after the invalidation are kept.
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+
+	e.SetCache(testFailingCache{Cache: cache.NewDefaultCache()})
+	done := e.InvalidateCacheAsync()
+	if err := <-done; err != errTestClear {
+		t.Errorf("InvalidateCacheAsync delivered %v, supposed to be %v", err, errTestClear)
+	}
+	if _, ok := <-done; ok {
+		t.Error("the channel is supposed to be closed after the error")
+	}
+}
+
+// testObserver records the cache events as "<event> <key>".
+type testObserver struct {
+	events []string
+}
+
+func (o *testObserver) OnHit(key string)   { o.events = append(o.events, "hit "+key) }
+func (o *testObserver) OnMiss(key string)  { o.events = append(o.events, "miss "+key) }
+func (o *testObserver) OnSet(key string)   { o.events = append(o.events, "set "+key) }
+func (o *testObserver) OnEvict(key string) { o.events = append(o.events, "evict "+key) }
+
+func TestCacheObserver(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	o := &testObserver{}
+	e.SetObserver(o)
+	// The observer is wired to the evictions of a cache set afterwards.
+	e.SetCache(cache.NewLRUCache(1))
+
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "alice", 1, "read", false)
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+
+	alice, bob := "5:alice$$5:data1$$4:read$$", "3:bob$$5:data2$$5:write$$"
+	want := []string{
+		"miss " + alice, "set " + alice,
+		"hit " + alice,
+		"miss " + bob, "evict " + alice, "set " + bob,
+	}
+	if strings.Join(o.events, "|") != strings.Join(want, "|") {
+		t.Errorf("events:\n%s\nsupposed to be:\n%s", strings.Join(o.events, "\n"), strings.Join(want, "\n"))
+	}
+
+	e.SetObserver(nil)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	if len(o.events) != len(want) {
+		t.Errorf("the observer is supposed to be removed, %d events", len(o.events))
+	}
+}
+
+func TestCacheCacheKey(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	for _, setup := range []func(){
+		func() {},
+		func() { e.SetKeySeparator("|") },
+		func() { e.HashKeys(SHA256KeyHasher) },
+	} {
+		setup()
+		_ = e.InvalidateCache()
+		testEnforceCache(t, e, "alice", "data1", "read", true)
+		key, ok := e.CacheKey("alice", "data1", "read")
+		if !ok {
+			t.Fatal("the request is supposed to be cacheable")
+		}
+		if keys := e.cache.(*cache.DefaultCache).Keys(); len(keys) != 1 || keys[0] != key {
+			t.Errorf("CacheKey returned %q, the stored keys are %q", key, keys)
+		}
+	}
+
+	if _, ok := e.CacheKey("alice", 1, "read"); ok {
+		t.Error("a request with a non-string value is not supposed to have a key")
+	}
+}
+
+func TestCacheFull(t *testing.T) {
+	for _, negatives := range []bool{true, false} {
+		e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+		e.SetCache(cache.NewDefaultCache(cache.WithLimit(1)))
+		e.CacheNegativeResults(negatives)
+
+		testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+		testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, false)
+		// The decision of bob didn't fit, so it is evaluated again.
+		testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, false)
+		testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+		if n := e.CacheLen(); n != 1 {
+			t.Errorf("cache holds %d decisions, supposed to be 1", n)
+		}
+
+		results, err := e.BatchEnforce([][]interface{}{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+		if err != nil || !reflect.DeepEqual(results, []bool{true, true}) {
+			t.Errorf("BatchEnforce: %v, %v, supposed to be [true true]", results, err)
+		}
+		if stats := e.CacheStats(); stats.Rejections != 3 {
+			t.Errorf("stats: %+v, supposed to have 3 rejections", stats)
+		}
+	}
+}
+
+// testTTLCache records the survival times given to SetWithTTL.
+type testTTLCache struct {
+	persist.Cache
+	ttls map[string]time.Duration
+}
+
+func (c *testTTLCache) SetWithTTL(key string, value bool, ttl time.Duration) error {
+	c.ttls[key] = ttl
+	return c.Cache.Set(key, value)
+}
+
+func TestCacheSetWithTTL(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	rc := &testCostCache{Cache: cache.NewDefaultCache(), extras: make(map[string][]interface{})}
+	c := &testTTLCache{Cache: rc, ttls: make(map[string]time.Duration)}
+	e.SetCache(c)
+	e.SetExpireTime(60)
+
+	// The decision is set with Set, which takes the cost too.
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	if len(c.ttls) != 0 {
+		t.Errorf("SetWithTTL was given %v, supposed to be left for Set", c.ttls)
+	}
+	testCacheCost(t, rc.extras)
+}
+
+// testCallbackWatcher lets the test fire its update callback.
+type testCallbackWatcher struct {
+	SampleWatcher
+	callback func(string)
+}
+
+func (w *testCallbackWatcher) SetUpdateCallback(callback func(string)) error {
+	w.callback = callback
+	return nil
+}
+
+func TestCacheSetWatcher(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	w := &testCallbackWatcher{}
+	if err := e.SetWatcher(w); err != nil {
+		t.Fatal(err)
+	}
+
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+
+	w.callback("")
+	if n := e.CacheLen(); n != 0 {
+		t.Errorf("cache holds %d decisions after a watcher update, supposed to be 0", n)
+	}
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+}
+
+func TestCacheEnforceMatrix(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "bob", "data1", "read", false)
+	e.ResetCacheStats()
+
+	matrix, err := e.EnforceMatrix([]string{"alice", "bob"}, []string{"data1", "data2", "data3"}, "read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]bool{{true, false, false}, {false, false, false}}
+	if !reflect.DeepEqual(matrix, want) {
+		t.Errorf("matrix: %v, supposed to be %v", matrix, want)
+	}
+	if stats := e.CacheStats(); stats != (CacheStats{Hits: 2, Misses: 4}) {
+		t.Errorf("stats: %+v, supposed to be %+v", stats, CacheStats{Hits: 2, Misses: 4})
+	}
+
+	if matrix, err := e.EnforceMatrix(nil, []string{"data1"}, "read"); err != nil || len(matrix) != 0 {
+		t.Errorf("empty matrix: %v, %v", matrix, err)
+	}
+}
+
+func TestCacheSetResultCacheable(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	// The decisions on data2 are flagged as time dependent.
+	e.SetResultCacheable(func(rvals []interface{}) bool {
+		return rvals[1] != "data2"
+	})
+
+	for i := 0; i < 2; i++ {
+		testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, false)
+	}
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+	if e.IsCacheable("bob", "data2", "write") {
+		t.Error("a flagged request is reported as cacheable")
+	}
+	if stats := e.CacheStats(); stats.Bypasses != 2 || e.CacheLen() != 1 {
+		t.Errorf("stats: %+v with %d cached decisions, supposed to have 2 bypasses and 1 cached decision", stats, e.CacheLen())
+	}
+
+	e.SetResultCacheable(nil)
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, false)
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, true)
+}
+
+func TestCacheNotInitialized(t *testing.T) {
+	e := &CachedEnforcer{}
+
+	if _, err := e.Enforce("alice", "data1", "read"); err != ErrEnforcerNotInitialized {
+		t.Errorf("Enforce returned %v, supposed to be %v", err, ErrEnforcerNotInitialized)
+	}
+	if _, _, err := e.EnforceEx("alice", "data1", "read"); err != ErrEnforcerNotInitialized {
+		t.Errorf("EnforceEx returned %v, supposed to be %v", err, ErrEnforcerNotInitialized)
+	}
+	if _, err := e.BatchEnforce([][]interface{}{{"alice", "data1", "read"}}); err != ErrEnforcerNotInitialized {
+		t.Errorf("BatchEnforce returned %v, supposed to be %v", err, ErrEnforcerNotInitialized)
+	}
+	if err := e.LoadPolicy(); err != ErrEnforcerNotInitialized {
+		t.Errorf("LoadPolicy returned %v, supposed to be %v", err, ErrEnforcerNotInitialized)
+	}
+	if err := e.InvalidateCache(); err != ErrEnforcerNotInitialized {
+		t.Errorf("InvalidateCache returned %v, supposed to be %v", err, ErrEnforcerNotInitialized)
+	}
+	if err := e.SetCacheSafe(cache.NewDefaultCache()); err != ErrEnforcerNotInitialized {
+		t.Errorf("SetCacheSafe returned %v, supposed to be %v", err, ErrEnforcerNotInitialized)
+	}
+}
+
+func TestCacheNotInitializedMethods(t *testing.T) {
+	ctx := context.Background()
+	rvals := []interface{}{"alice", "data1", "read"}
+	rule := []string{"alice", "data1", "read"}
+	// err is what the method is supposed to return, nil for the methods without an error.
+	tests := []struct {
+		name string
+		call func(e *CachedEnforcer) error
+		err  error
+	}{
+		{"EnableCache", func(e *CachedEnforcer) error { e.EnableCache(true); return nil }, nil},
+		{"EnableCacheWithOptions", func(e *CachedEnforcer) error { return e.EnableCacheWithOptions(false, true) }, ErrEnforcerNotInitialized},
+		{"IsCacheEnabled", func(e *CachedEnforcer) error { e.IsCacheEnabled(); return nil }, nil},
+		{"EnableNonStringKeys", func(e *CachedEnforcer) error { e.EnableNonStringKeys(true); return nil }, nil},
+		{"SetNonStringPolicy", func(e *CachedEnforcer) error { e.SetNonStringPolicy(NonStringCoerce); return nil }, nil},
+		{"CacheNegativeResults", func(e *CachedEnforcer) error { e.CacheNegativeResults(false); return nil }, nil},
+		{"SetCacheReadOnly", func(e *CachedEnforcer) error { e.SetCacheReadOnly(true); return nil }, nil},
+		{"SetCacheSampleRate", func(e *CachedEnforcer) error { e.SetCacheSampleRate(2); return nil }, nil},
+		{"FailOpenOnCacheError", func(e *CachedEnforcer) error { e.FailOpenOnCacheError(true); return nil }, nil},
+		{"SetDefaultDecisionOnError", func(e *CachedEnforcer) error { e.SetDefaultDecisionOnError(true); return nil }, nil},
+		{"Enforce", func(e *CachedEnforcer) error { _, err := e.Enforce(rvals...); return err }, ErrEnforcerNotInitialized},
+		{"EnforceCtx", func(e *CachedEnforcer) error { _, err := e.EnforceCtx(ctx, rvals...); return err }, ErrEnforcerNotInitialized},
+		{"EnforceWithCacheInfo", func(e *CachedEnforcer) error { _, _, err := e.EnforceWithCacheInfo(rvals...); return err }, ErrEnforcerNotInitialized},
+		{"EnforceDetailed", func(e *CachedEnforcer) error { _, _, _, err := e.EnforceDetailed(rvals...); return err }, ErrEnforcerNotInitialized},
+		{"EnforceSafe", func(e *CachedEnforcer) error { _, err := e.EnforceSafe(rvals...); return err }, ErrEnforcerNotInitialized},
+		{"EnforceWithMatcher", func(e *CachedEnforcer) error { _, err := e.EnforceWithMatcher("true", rvals...); return err }, ErrEnforcerNotInitialized},
+		{"EnforceWithTTL", func(e *CachedEnforcer) error { _, err := e.EnforceWithTTL(1, rvals...); return err }, ErrEnforcerNotInitialized},
+		{"EnforceCtxTTL", func(e *CachedEnforcer) error { _, err := e.EnforceCtxTTL(ctx, 1, rvals...); return err }, ErrEnforcerNotInitialized},
+		{"EnforceWithKey", func(e *CachedEnforcer) error { _, err := e.EnforceWithKey("key", rvals...); return err }, ErrEnforcerNotInitialized},
+		{"EnforceWithAge", func(e *CachedEnforcer) error { _, _, err := e.EnforceWithAge(rvals...); return err }, ErrEnforcerNotInitialized},
+		{"EnforceWithFallback", func(e *CachedEnforcer) error { _, err := e.EnforceWithFallback(rvals...); return err }, ErrEnforcerNotInitialized},
+		{"EnforceEx", func(e *CachedEnforcer) error { _, _, err := e.EnforceEx(rvals...); return err }, ErrEnforcerNotInitialized},
+		{"EnforceWithReason", func(e *CachedEnforcer) error { _, _, err := e.EnforceWithReason(rvals...); return err }, ErrEnforcerNotInitialized},
+		{"BatchEnforce", func(e *CachedEnforcer) error { _, err := e.BatchEnforce([][]interface{}{rvals}); return err }, ErrEnforcerNotInitialized},
+		{"EnforceMatrix", func(e *CachedEnforcer) error {
+			_, err := e.EnforceMatrix([]string{"alice"}, []string{"data1"}, "read")
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"WarmCache", func(e *CachedEnforcer) error { return e.WarmCache([][]interface{}{rvals}) }, ErrEnforcerNotInitialized},
+		{"Preset", func(e *CachedEnforcer) error { return e.Preset(rvals, true, 0) }, ErrEnforcerNotInitialized},
+		{"PresetMany", func(e *CachedEnforcer) error { return e.PresetMany([][]interface{}{rvals}, []bool{true}, 0) }, ErrEnforcerNotInitialized},
+		{"SetOnMiss", func(e *CachedEnforcer) error { e.SetOnMiss(nil); return nil }, nil},
+		{"SetDecisionTransform", func(e *CachedEnforcer) error { e.SetDecisionTransform(nil); return nil }, nil},
+		{"SetObserver", func(e *CachedEnforcer) error { e.SetObserver(nil); return nil }, nil},
+		{"EnableSlidingExpiration", func(e *CachedEnforcer) error { e.EnableSlidingExpiration(true); return nil }, nil},
+		{"VerifyCache", func(e *CachedEnforcer) error { e.VerifyCache(1); return nil }, nil},
+		{"SetOnDrift", func(e *CachedEnforcer) error { e.SetOnDrift(nil); return nil }, nil},
+		{"SetExpireTime", func(e *CachedEnforcer) error { e.SetExpireTime(1); return nil }, nil},
+		{"SetTTLJitter", func(e *CachedEnforcer) error { e.SetTTLJitter(0.1); return nil }, nil},
+		{"SetTTLJitterSeed", func(e *CachedEnforcer) error { e.SetTTLJitterSeed(1); return nil }, nil},
+		{"ExpireTime", func(e *CachedEnforcer) error { e.ExpireTime(); return nil }, nil},
+		// SetCache panics with the error, like with a nil cache.
+		{"SetCache", func(e *CachedEnforcer) (err error) {
+			defer func() { err, _ = recover().(error) }()
+			e.SetCache(cache.NewDefaultCache())
+			return nil
+		}, ErrEnforcerNotInitialized},
+		{"SetCacheSafe", func(e *CachedEnforcer) error { return e.SetCacheSafe(cache.NewDefaultCache()) }, ErrEnforcerNotInitialized},
+		{"SwapCache", func(e *CachedEnforcer) error { _, err := e.SwapCache(cache.NewDefaultCache()); return err }, ErrEnforcerNotInitialized},
+		{"SetKeySeparator", func(e *CachedEnforcer) error { e.SetKeySeparator("::"); return nil }, nil},
+		{"SetCacheNamespace", func(e *CachedEnforcer) error { e.SetCacheNamespace("ns"); return nil }, nil},
+		{"SetKeyFunc", func(e *CachedEnforcer) error { e.SetKeyFunc(nil); return nil }, nil},
+		{"HashKeys", func(e *CachedEnforcer) error { e.HashKeys(SHA256KeyHasher); return nil }, nil},
+		{"SetMaxKeyLength", func(e *CachedEnforcer) error { e.SetMaxKeyLength(10); return nil }, nil},
+		{"SetKeyOverflow", func(e *CachedEnforcer) error { e.SetKeyOverflow(KeyOverflowHash); return nil }, nil},
+		{"BypassCacheForSubjects", func(e *CachedEnforcer) error { e.BypassCacheForSubjects("alice"); return nil }, nil},
+		{"SetResultCacheable", func(e *CachedEnforcer) error { e.SetResultCacheable(nil); return nil }, nil},
+		{"CacheKey", func(e *CachedEnforcer) error { e.CacheKey(rvals...); return nil }, nil},
+		{"IsCached", func(e *CachedEnforcer) error { e.IsCached(rvals...); return nil }, nil},
+		{"IsCacheable", func(e *CachedEnforcer) error { e.IsCacheable(rvals...); return nil }, nil},
+		{"CacheLen", func(e *CachedEnforcer) error { e.CacheLen(); return nil }, nil},
+		{"MissLatencyHistogram", func(e *CachedEnforcer) error { e.MissLatencyHistogram(); return nil }, nil},
+		{"CacheStats", func(e *CachedEnforcer) error { e.CacheStats(); return nil }, nil},
+		{"ResetCacheStats", func(e *CachedEnforcer) error { e.ResetCacheStats(); return nil }, nil},
+		{"AddPolicy", func(e *CachedEnforcer) error { _, err := e.AddPolicy(rule); return err }, ErrEnforcerNotInitialized},
+		{"AddPolicies", func(e *CachedEnforcer) error { _, err := e.AddPolicies([][]string{rule}); return err }, ErrEnforcerNotInitialized},
+		{"RemovePolicy", func(e *CachedEnforcer) error { _, err := e.RemovePolicy(rule); return err }, ErrEnforcerNotInitialized},
+		{"RemovePolicies", func(e *CachedEnforcer) error { _, err := e.RemovePolicies([][]string{rule}); return err }, ErrEnforcerNotInitialized},
+		{"UpdatePolicy", func(e *CachedEnforcer) error { _, err := e.UpdatePolicy(rule, rule); return err }, ErrEnforcerNotInitialized},
+		{"UpdatePolicies", func(e *CachedEnforcer) error {
+			_, err := e.UpdatePolicies([][]string{rule}, [][]string{rule})
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"RemoveFilteredPolicy", func(e *CachedEnforcer) error { _, err := e.RemoveFilteredPolicy(0, "alice"); return err }, ErrEnforcerNotInitialized},
+		{"AddNamedPolicy", func(e *CachedEnforcer) error { _, err := e.AddNamedPolicy("p", rule); return err }, ErrEnforcerNotInitialized},
+		{"AddNamedPolicies", func(e *CachedEnforcer) error { _, err := e.AddNamedPolicies("p", [][]string{rule}); return err }, ErrEnforcerNotInitialized},
+		{"RemoveNamedPolicy", func(e *CachedEnforcer) error { _, err := e.RemoveNamedPolicy("p", rule); return err }, ErrEnforcerNotInitialized},
+		{"RemoveNamedPolicies", func(e *CachedEnforcer) error { _, err := e.RemoveNamedPolicies("p", [][]string{rule}); return err }, ErrEnforcerNotInitialized},
+		{"UpdateNamedPolicy", func(e *CachedEnforcer) error { _, err := e.UpdateNamedPolicy("p", rule, rule); return err }, ErrEnforcerNotInitialized},
+		{"UpdateNamedPolicies", func(e *CachedEnforcer) error {
+			_, err := e.UpdateNamedPolicies("p", [][]string{rule}, [][]string{rule})
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"RemoveFilteredNamedPolicy", func(e *CachedEnforcer) error { _, err := e.RemoveFilteredNamedPolicy("p", 0, "alice"); return err }, ErrEnforcerNotInitialized},
+		{"AddGroupingPolicy", func(e *CachedEnforcer) error { _, err := e.AddGroupingPolicy("alice", "admin"); return err }, ErrEnforcerNotInitialized},
+		{"AddGroupingPolicies", func(e *CachedEnforcer) error {
+			_, err := e.AddGroupingPolicies([][]string{{"alice", "admin"}})
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"RemoveGroupingPolicy", func(e *CachedEnforcer) error { _, err := e.RemoveGroupingPolicy("alice", "admin"); return err }, ErrEnforcerNotInitialized},
+		{"RemoveGroupingPolicies", func(e *CachedEnforcer) error {
+			_, err := e.RemoveGroupingPolicies([][]string{{"alice", "admin"}})
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"UpdateGroupingPolicy", func(e *CachedEnforcer) error {
+			_, err := e.UpdateGroupingPolicy([]string{"alice", "admin"}, []string{"bob", "admin"})
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"RemoveFilteredGroupingPolicy", func(e *CachedEnforcer) error { _, err := e.RemoveFilteredGroupingPolicy(0, "alice"); return err }, ErrEnforcerNotInitialized},
+		{"AddNamedGroupingPolicy", func(e *CachedEnforcer) error { _, err := e.AddNamedGroupingPolicy("g", "alice", "admin"); return err }, ErrEnforcerNotInitialized},
+		{"AddNamedGroupingPolicies", func(e *CachedEnforcer) error {
+			_, err := e.AddNamedGroupingPolicies("g", [][]string{{"alice", "admin"}})
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"RemoveNamedGroupingPolicy", func(e *CachedEnforcer) error {
+			_, err := e.RemoveNamedGroupingPolicy("g", "alice", "admin")
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"RemoveNamedGroupingPolicies", func(e *CachedEnforcer) error {
+			_, err := e.RemoveNamedGroupingPolicies("g", [][]string{{"alice", "admin"}})
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"UpdateNamedGroupingPolicy", func(e *CachedEnforcer) error {
+			_, err := e.UpdateNamedGroupingPolicy("g", []string{"alice", "admin"}, []string{"bob", "admin"})
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"RemoveFilteredNamedGroupingPolicy", func(e *CachedEnforcer) error {
+			_, err := e.RemoveFilteredNamedGroupingPolicy("g", 0, "alice")
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"AddRoleForUser", func(e *CachedEnforcer) error {
+			_, err := e.AddRoleForUser("alice", "admin")
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"AddRolesForUser", func(e *CachedEnforcer) error {
+			_, err := e.AddRolesForUser("alice", []string{"admin"})
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"DeleteRoleForUser", func(e *CachedEnforcer) error {
+			_, err := e.DeleteRoleForUser("alice", "admin")
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"DeleteRolesForUser", func(e *CachedEnforcer) error {
+			_, err := e.DeleteRolesForUser("alice")
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"DeleteUser", func(e *CachedEnforcer) error { _, err := e.DeleteUser("alice"); return err }, ErrEnforcerNotInitialized},
+		{"DeleteRole", func(e *CachedEnforcer) error { _, err := e.DeleteRole("admin"); return err }, ErrEnforcerNotInitialized},
+		{"DeletePermission", func(e *CachedEnforcer) error {
+			_, err := e.DeletePermission("data1", "read")
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"AddPermissionForUser", func(e *CachedEnforcer) error {
+			_, err := e.AddPermissionForUser("alice", "data1", "read")
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"DeletePermissionForUser", func(e *CachedEnforcer) error {
+			_, err := e.DeletePermissionForUser("alice", "data1", "read")
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"DeletePermissionsForUser", func(e *CachedEnforcer) error {
+			_, err := e.DeletePermissionsForUser("alice")
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"AddRoleForUserInDomain", func(e *CachedEnforcer) error {
+			_, err := e.AddRoleForUserInDomain("alice", "admin", "domain1")
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"DeleteRoleForUserInDomain", func(e *CachedEnforcer) error {
+			_, err := e.DeleteRoleForUserInDomain("alice", "admin", "domain1")
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"DeleteRolesForUserInDomain", func(e *CachedEnforcer) error {
+			_, err := e.DeleteRolesForUserInDomain("alice", "domain1")
+			return err
+		}, ErrEnforcerNotInitialized},
+		{"LoadPolicy", func(e *CachedEnforcer) error { return e.LoadPolicy() }, ErrEnforcerNotInitialized},
+		{"LoadFilteredPolicy", func(e *CachedEnforcer) error { return e.LoadFilteredPolicy(nil) }, ErrEnforcerNotInitialized},
+		{"LoadIncrementalFilteredPolicy", func(e *CachedEnforcer) error {
+			return e.LoadIncrementalFilteredPolicy(nil)
+		}, ErrEnforcerNotInitialized},
+		{"ClearPolicy", func(e *CachedEnforcer) error { e.ClearPolicy(); return nil }, nil},
+		{"LoadModel", func(e *CachedEnforcer) error { return e.LoadModel() }, ErrEnforcerNotInitialized},
+		{"SetModel", func(e *CachedEnforcer) error { e.SetModel(model.NewModel()); return nil }, nil},
+		{"SetRoleManager", func(e *CachedEnforcer) error { e.SetRoleManager(nil); return nil }, nil},
+		{"SetEffector", func(e *CachedEnforcer) error { e.SetEffector(nil); return nil }, nil},
+		{"EnableEnforce", func(e *CachedEnforcer) error { e.EnableEnforce(true); return nil }, nil},
+		{"BuildRoleLinks", func(e *CachedEnforcer) error { return e.BuildRoleLinks() }, ErrEnforcerNotInitialized},
+		{"BuildIncrementalRoleLinks", func(e *CachedEnforcer) error {
+			return e.BuildIncrementalRoleLinks(model.PolicyAdd, "g", [][]string{{"alice", "admin"}})
+		}, ErrEnforcerNotInitialized},
+		{"AddFunction", func(e *CachedEnforcer) error { e.AddFunction("fn", nil); return nil }, nil},
+		{"AddNamedMatchingFunc", func(e *CachedEnforcer) error { e.AddNamedMatchingFunc("g", "fn", nil); return nil }, nil},
+		{"AddNamedDomainMatchingFunc", func(e *CachedEnforcer) error {
+			e.AddNamedDomainMatchingFunc("g", "fn", nil)
+			return nil
+		}, nil},
+		{"SetWatcher", func(e *CachedEnforcer) error { return e.SetWatcher(&SampleWatcherEx{}) }, ErrEnforcerNotInitialized},
+		{"EnableDistributedInvalidation", func(e *CachedEnforcer) error {
+			return e.EnableDistributedInvalidation(&SampleWatcherEx{})
+		}, ErrEnforcerNotInitialized},
+		{"LastPolicyChange", func(e *CachedEnforcer) error { e.LastPolicyChange(); return nil }, nil},
+		{"InvalidateCacheForSubject", func(e *CachedEnforcer) error { return e.InvalidateCacheForSubject("alice") }, ErrEnforcerNotInitialized},
+		{"InvalidateCacheForObject", func(e *CachedEnforcer) error { return e.InvalidateCacheForObject("data1") }, ErrEnforcerNotInitialized},
+		{"InvalidateCacheForDomain", func(e *CachedEnforcer) error { return e.InvalidateCacheForDomain("domain1") }, ErrEnforcerNotInitialized},
+		{"SetDomainIndex", func(e *CachedEnforcer) error { e.SetDomainIndex(2); return nil }, nil},
+		{"FilterCache", func(e *CachedEnforcer) error {
+			return e.FilterCache(func(key string, value bool) bool { return true })
+		}, ErrEnforcerNotInitialized},
+		{"SetInvalidationBatchSize", func(e *CachedEnforcer) error { e.SetInvalidationBatchSize(10); return nil }, nil},
+		{"SaveCache", func(e *CachedEnforcer) error { return e.SaveCache(&bytes.Buffer{}) }, ErrEnforcerNotInitialized},
+		{"DumpCache", func(e *CachedEnforcer) error { return e.DumpCache(&bytes.Buffer{}) }, ErrEnforcerNotInitialized},
+		{"CloneCache", func(e *CachedEnforcer) error { _, err := e.CloneCache(); return err }, ErrEnforcerNotInitialized},
+		{"LoadCache", func(e *CachedEnforcer) error { return e.LoadCache(strings.NewReader("{}")) }, ErrEnforcerNotInitialized},
+		{"InvalidateCache", func(e *CachedEnforcer) error { return e.InvalidateCache() }, ErrEnforcerNotInitialized},
+		{"SetReWarmOnClear", func(e *CachedEnforcer) error { e.SetReWarmOnClear(true); return nil }, nil},
+		{"EvaluateKey", func(e *CachedEnforcer) error { _, err := e.EvaluateKey("key"); return err }, ErrEnforcerNotInitialized},
+		{"InvalidateCacheAsync", func(e *CachedEnforcer) error { return <-e.InvalidateCacheAsync() }, ErrEnforcerNotInitialized},
+		{"Close", func(e *CachedEnforcer) error { return e.Close() }, ErrEnforcerNotInitialized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%s panicked on a zero-value CachedEnforcer: %v", tt.name, r)
+				}
+			}()
+			if err := tt.call(&CachedEnforcer{}); err != tt.err {
+				t.Errorf("%s returned %v, supposed to be %v", tt.name, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCacheNamespace(t *testing.T) {
+	shared := cache.NewDefaultCache()
+	e1, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e1.SetCache(shared)
+	e1.SetCacheNamespace("tenant1")
+	e2, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e2.SetCache(shared)
+	e2.SetCacheNamespace("tenant2")
+
+	if key, _ := e1.CacheKey("alice", "data1", "read"); key != "tenant1:5:alice$$5:data1$$4:read$$" {
+		t.Errorf("key: %q, supposed to be prefixed with the namespace", key)
+	}
+
+	testEnforceWithCacheInfo(t, e1, "alice", "data1", "read", true, false)
+	// The decision cached by e1 is not read by e2.
+	testEnforceWithCacheInfo(t, e2, "alice", "data1", "read", true, false)
+	testEnforceWithCacheInfo(t, e1, "alice", "data1", "read", true, true)
+	testEnforceWithCacheInfo(t, e2, "bob", "data2", "write", true, false)
+	if n := shared.Len(); n != 3 {
+		t.Fatalf("shared cache holds %d decisions, supposed to be 3", n)
+	}
+
+	if err := e1.InvalidateCache(); err != nil {
+		t.Fatal(err)
+	}
+	testEnforceWithCacheInfo(t, e2, "alice", "data1", "read", true, true)
+	testEnforceWithCacheInfo(t, e2, "bob", "data2", "write", true, true)
+	testEnforceWithCacheInfo(t, e1, "alice", "data1", "read", true, false)
+
+	if err := e2.InvalidateCacheForSubject("bob"); err != nil {
+		t.Fatal(err)
+	}
+	testEnforceWithCacheInfo(t, e2, "bob", "data2", "write", true, false)
+	testEnforceWithCacheInfo(t, e2, "alice", "data1", "read", true, true)
+}
+
+// testBrokenCache fails all its operations, like a remote cache during an outage.
+type testBrokenCache struct{}
+
+var errTestOutage = fmt.Errorf("cache unavailable")
+
+func (testBrokenCache) Set(key string, value bool, extra ...interface{}) error { return errTestOutage }
+func (testBrokenCache) Get(key string) (bool, error)                           { return false, errTestOutage }
+func (testBrokenCache) Delete(key string) error                                { return errTestOutage }
+func (testBrokenCache) Clear() error                                           { return errTestOutage }
+
+// testBrokenGetOrSetCache also fails GetOrSet.
+type testBrokenGetOrSetCache struct {
+	testBrokenCache
+}
+
+func (testBrokenGetOrSetCache) GetOrSet(key string, compute func() (bool, error), extra ...interface{}) (bool, error) {
+	return false, errTestOutage
+}
+
+func (testBrokenGetOrSetCache) IsConcurrent() bool { return true }
+
+func TestCacheFailOpenOnCacheError(t *testing.T) {
+	for _, c := range []persist.Cache{testBrokenCache{}, testBrokenGetOrSetCache{}} {
+		e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+		e.SetCache(c)
+
+		testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+		testEnforceWithCacheInfo(t, e, "alice", "data2", "read", false, false)
+		results, err := e.BatchEnforce([][]interface{}{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+		if err != nil || !reflect.DeepEqual(results, []bool{true, true}) {
+			t.Errorf("BatchEnforce: %v, %v, supposed to be [true true]", results, err)
+		}
+		if stats := e.CacheStats(); stats.Errors == 0 {
+			t.Errorf("stats: %+v, supposed to count the errors of the cache", stats)
+		}
+
+		e.FailOpenOnCacheError(false)
+		if _, err := e.Enforce("alice", "data1", "read"); !errors.Is(err, errTestOutage) {
+			t.Errorf("Enforce returned %v, supposed to be %v", err, errTestOutage)
+		}
+		if _, err := e.BatchEnforce([][]interface{}{{"alice", "data1", "read"}}); !errors.Is(err, errTestOutage) {
+			t.Errorf("BatchEnforce returned %v, supposed to be %v", err, errTestOutage)
+		}
+	}
+}
+
+func TestCacheEnforceWithTTL(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	c := cache.NewDefaultCache()
+	e.SetCache(c)
+	e.SetExpireTime(60)
+
+	if res, err := e.EnforceWithTTL(3600, "alice", "data1", "read"); err != nil || !res {
+		t.Fatalf("EnforceWithTTL: %t, %v, supposed to be true", res, err)
+	}
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+	// A hit is returned whatever the ttl.
+	if res, err := e.EnforceWithTTL(1, "alice", "data1", "read"); err != nil || !res {
+		t.Fatalf("EnforceWithTTL: %t, %v, supposed to be true", res, err)
+	}
+
+	if ttl, _ := c.TTL("5:alice$$5:data1$$4:read$$"); ttl <= time.Minute || ttl > time.Hour {
+		t.Errorf("TTL: %v, supposed to be 1h", ttl)
+	}
+	if ttl, _ := c.TTL("3:bob$$5:data2$$5:write$$"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("TTL: %v, supposed to be 1m", ttl)
+	}
+
+	// The decisions stored without GetOrSet get the ttl too.
+	rc := &testCostCache{Cache: cache.NewDefaultCache(), extras: make(map[string][]interface{})}
+	e.SetCache(rc)
+	if _, err := e.EnforceWithTTL(3600, "alice", "data1", "read"); err != nil {
+		t.Fatal(err)
+	}
+	if extra := rc.extras["5:alice$$5:data1$$4:read$$"]; len(extra) == 0 || extra[0] != uint(3600) {
+		t.Errorf("Set was given %v, supposed to start with the ttl", extra)
+	}
+}
+
+func TestCacheIsCached(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	for _, c := range []persist.Cache{cache.NewDefaultCache(), cache.NewLRUCache(10), cache.NewCostCache(10)} {
+		e.SetCache(c)
+		if e.IsCached("alice", "data1", "read") {
+			t.Errorf("%T: the decision is reported as cached before it is evaluated", c)
+		}
+		testEnforceCache(t, e, "alice", "data1", "read", true)
+		if !e.IsCached("alice", "data1", "read") {
+			t.Errorf("%T: the decision is not reported as cached", c)
+		}
+		if e.IsCached("bob", 2, "write") {
+			t.Errorf("%T: a request which is not cacheable is reported as cached", c)
+		}
+	}
+
+	// WarmCache skips the cached decisions, which keep their survival time.
+	c := cache.NewDefaultCache()
+	e.SetCache(c)
+	e.SetExpireTime(60)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	e.SetExpireTime(3600)
+	if err := e.WarmCache([][]interface{}{{"alice", "data1", "read"}, {"bob", "data2", "write"}}); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := c.TTL("5:alice$$5:data1$$4:read$$"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("TTL of the cached decision: %v, supposed to be 1m", ttl)
+	}
+	if ttl, _ := c.TTL("3:bob$$5:data2$$5:write$$"); ttl <= time.Minute {
+		t.Errorf("TTL of the warmed decision: %v, supposed to be 1h", ttl)
+	}
+}
+
+// testClock is a cache.Clock which only moves forward when it is advanced.
+type testClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func testEnforceWithAge(t *testing.T, e *CachedEnforcer, sub string, obj interface{}, act string, res bool, age time.Duration) {
+	t.Helper()
+	myRes, myAge, err := e.EnforceWithAge(sub, obj, act)
+	if err != nil {
+		t.Errorf("%s, %v, %s: %v", sub, obj, act, err)
+	}
+	if myRes != res || myAge != age {
+		t.Errorf("%s, %v, %s: %t, age %v, supposed to be %t, age %v", sub, obj, act, myRes, myAge, res, age)
+	}
+}
+
+func TestCacheEnforceWithAge(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	clock := &testClock{now: time.Unix(0, 0)}
+	e.SetCache(cache.NewDefaultCache(cache.WithClock(clock)))
+
+	testEnforceWithAge(t, e, "alice", "data1", "read", true, 0)
+	clock.Advance(90 * time.Second)
+	testEnforceWithAge(t, e, "alice", "data1", "read", true, 90*time.Second)
+	testEnforceWithAge(t, e, "bob", "data2", "write", true, 0)
+	clock.Advance(time.Second)
+	testEnforceWithAge(t, e, "bob", "data2", "write", true, time.Second)
+	testEnforceWithAge(t, e, "alice", "data1", "read", true, 91*time.Second)
+	testEnforceWithAge(t, e, "bob", 2, "write", false, AgeNotCached)
+
+	// A hit of a cache which can't report the age is returned as is with an unknown age.
+	e.SetCache(cache.NewLRUCache(10))
+	testEnforceWithAge(t, e, "alice", "data1", "read", true, 0)
+	testEnforceWithAge(t, e, "alice", "data1", "read", true, AgeUnknown)
+	_, _ = e.Enforcer.RemovePolicy("alice", "data1", "read")
+	testEnforceWithAge(t, e, "alice", "data1", "read", true, AgeUnknown)
+
+	e.EnableCache(false)
+	testEnforceWithAge(t, e, "alice", "data1", "read", false, AgeNotCached)
+}
+
+// testClosableCache counts the calls of Close.
+type testClosableCache struct {
+	*cache.DefaultCache
+	closes int
+}
+
+func (c *testClosableCache) Close() error {
+	c.closes++
+	return c.DefaultCache.Close()
+}
+
+func TestCacheClose(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	c := &testClosableCache{DefaultCache: cache.NewDefaultCache(cache.WithSweep(time.Millisecond))}
+	e.SetCache(c)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != ErrEnforcerClosed {
+		t.Errorf("Close of a closed enforcer returned %v, supposed to be %v", err, ErrEnforcerClosed)
+	}
+	if c.closes != 1 {
+		t.Errorf("the cache was closed %d times, supposed to be once", c.closes)
+	}
+
+	// A cache which can't be closed is left as is.
+	e, _ = NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.SetCache(cache.NewLRUCache(10))
+	if err := e.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+// testPubSub delivers the updates of each of its watchers to the other ones.
+type testPubSub struct {
+	watchers []*testPubSubWatcher
+}
+
+type testPubSubWatcher struct {
+	SampleWatcherEx
+	pubSub   *testPubSub
+	callback func(string)
+}
+
+func (ps *testPubSub) newWatcher() *testPubSubWatcher {
+	w := &testPubSubWatcher{pubSub: ps}
+	ps.watchers = append(ps.watchers, w)
+	return w
+}
+
+func (w *testPubSubWatcher) SetUpdateCallback(callback func(string)) error {
+	w.callback = callback
+	return nil
+}
+
+func (w *testPubSubWatcher) publish() error {
+	for _, other := range w.pubSub.watchers {
+		if other != w && other.callback != nil {
+			other.callback("")
+		}
+	}
+	return nil
+}
+
+func (w *testPubSubWatcher) Update() error                                { return w.publish() }
+func (w *testPubSubWatcher) UpdateForAddPolicy(params ...string) error    { return w.publish() }
+func (w *testPubSubWatcher) UpdateForRemovePolicy(params ...string) error { return w.publish() }
+func (w *testPubSubWatcher) UpdateForSavePolicy(model model.Model) error  { return w.publish() }
+
+func TestCacheDistributedInvalidation(t *testing.T) {
+	ps := &testPubSub{}
+	e1, _ := NewCachedEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	e2, _ := NewCachedEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	if err := e1.EnableDistributedInvalidation(ps.newWatcher()); err != nil {
+		t.Fatal(err)
+	}
+	if err := e2.EnableDistributedInvalidation(ps.newWatcher()); err != nil {
+		t.Fatal(err)
+	}
+
+	testEnforceWithCacheInfo(t, e1, "alice", "data1", "read", true, false)
+	testEnforceWithCacheInfo(t, e1, "alice", "data1", "read", true, true)
+
+	// A change on e2 invalidates the cache of e1.
+	if _, err := e2.AddPolicy("bob", "data1", "read"); err != nil {
+		t.Fatal(err)
+	}
+	if n := e1.CacheLen(); n != 0 {
+		t.Errorf("e1 holds %d decisions after a change on e2, supposed to be 0", n)
+	}
+
+	testEnforceWithCacheInfo(t, e2, "alice", "data1", "read", true, false)
+	if _, err := e1.RemoveGroupingPolicy("alice", "data2_admin"); err != nil {
+		t.Fatal(err)
+	}
+	if n := e2.CacheLen(); n != 0 {
+		t.Errorf("e2 holds %d decisions after a change on e1, supposed to be 0", n)
+	}
+}
+
+func TestCacheDistributedInvalidationReloadsPolicy(t *testing.T) {
+	policy, err := os.ReadFile("examples/basic_policy.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "policy.csv")
+	if err := os.WriteFile(path, policy, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The enforcers share the policy through the adapter.
+	adapter := fileadapter.NewAdapter(path)
+	ps := &testPubSub{}
+	e1, _ := NewCachedEnforcer("examples/basic_model.conf", adapter)
+	e2, _ := NewCachedEnforcer("examples/basic_model.conf", adapter)
+	if err := e1.EnableDistributedInvalidation(ps.newWatcher()); err != nil {
+		t.Fatal(err)
+	}
+	if err := e2.EnableDistributedInvalidation(ps.newWatcher()); err != nil {
+		t.Fatal(err)
+	}
+	testEnforceWithCacheInfo(t, e1, "alice", "data1", "read", true, false)
+
+	// The rule revoked by e2 is revoked on e1 too, rather than evaluated again with the policy e1 held.
+	if _, err := e2.RemovePolicy("alice", "data1", "read"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e2.SavePolicy(); err != nil {
+		t.Fatal(err)
+	}
+	testEnforceWithCacheInfo(t, e1, "alice", "data1", "read", false, false)
+	testEnforceWithCacheInfo(t, e1, "alice", "data1", "read", false, true)
+}
+
+func TestCacheIsCacheEnabled(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	if !e.IsCacheEnabled() {
+		t.Error("the cache is supposed to be enabled by default")
+	}
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+
+	e.EnableCache(false)
+	if e.IsCacheEnabled() {
+		t.Error("the cache is reported as enabled after EnableCache(false)")
+	}
+	// Disabling the cache keeps the cached decisions.
+	if n := e.CacheLen(); n != 1 {
+		t.Errorf("cache holds %d decisions after it is disabled, supposed to be 1", n)
+	}
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+
+	e.EnableCache(true)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+}
+
+func TestCacheEnableCacheWithOptions(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+
+	if err := e.EnableCacheWithOptions(false, false); err != nil {
+		t.Fatal(err)
+	}
+	if n := e.CacheLen(); n != 1 {
+		t.Errorf("cache holds %d decisions after it is disabled without flushing, supposed to be 1", n)
+	}
+
+	if err := e.EnableCacheWithOptions(false, true); err != nil {
+		t.Fatal(err)
+	}
+	if e.IsCacheEnabled() {
+		t.Error("the cache is reported as enabled after it is disabled")
+	}
+	if n := e.CacheLen(); n != 0 {
+		t.Errorf("cache holds %d decisions after it is disabled with flushing, supposed to be 0", n)
+	}
+
+	if err := e.EnableCacheWithOptions(true, true); err != nil {
+		t.Fatal(err)
+	}
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+}
+
+func TestCacheEnforceWithFallback(t *testing.T) {
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = flaky() && r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`)
+	e, _ := NewCachedEnforcer(m, fileadapter.NewAdapter("examples/basic_policy.csv"))
+	errFlaky := fmt.Errorf("flaky failed")
+	var failing int32
+	e.AddFunction("flaky", func(args ...interface{}) (interface{}, error) {
+		if atomic.LoadInt32(&failing) != 0 {
+			return nil, errFlaky
+		}
+		return true, nil
+	})
+	clock := &testClock{now: time.Unix(0, 0)}
+	c := cache.NewDefaultCache(cache.WithClock(clock))
+	e.SetCache(c)
+	e.SetExpireTime(1)
+
+	if res, err := e.EnforceWithFallback("alice", "data1", "read"); err != nil || !res {
+		t.Fatalf("EnforceWithFallback: %t, %v, supposed to be true", res, err)
+	}
+	clock.Advance(2 * time.Second)
+	atomic.StoreInt32(&failing, 1)
+
+	// The evaluation fails, the expired decision is returned.
+	res, err := e.EnforceWithFallback("alice", "data1", "read")
+	var staleErr *StaleDecisionError
+	if !res || !errors.As(err, &staleErr) || !errors.Is(err, errFlaky) {
+		t.Errorf("EnforceWithFallback: %t, %v, supposed to be true with a stale decision error", res, err)
+	}
+	// Without a cached decision, the error is returned as is.
+	if _, err := e.EnforceWithFallback("bob", "data2", "write"); !errors.Is(err, errFlaky) || errors.As(err, &staleErr) {
+		t.Errorf("EnforceWithFallback returned %v, supposed to be the error of the evaluation", err)
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	if res, err := e.EnforceWithFallback("alice", "data1", "read"); err != nil || !res {
+		t.Errorf("EnforceWithFallback: %t, %v, supposed to be true", res, err)
+	}
+}
+
+func TestCacheDumpCache(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	clock := &testClock{now: time.Unix(0, 0)}
+	e.SetCache(cache.NewDefaultCache(cache.WithClock(clock)))
+
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+	e.SetExpireTime(60)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "alice", "data2", "read", false)
+	clock.Advance(15 * time.Second)
+
+	var out strings.Builder
+	if err := e.DumpCache(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `KEY                         VALUE  TTL
+3:bob$$5:data2$$5:write$$   true   never
+5:alice$$5:data1$$4:read$$  true   45s
+5:alice$$5:data2$$4:read$$  false  45s
+`
+	if out.String() != want {
+		t.Errorf("dump:\n%s\nsupposed to be:\n%s", out.String(), want)
+	}
+
+	e.SetCache(cache.NewLRUCache(10))
+	if err := e.DumpCache(&out); err == nil {
+		t.Error("DumpCache is supposed to fail for a cache which can't list its items")
+	}
+}
+
+func TestCacheSetCacheSampleRate(t *testing.T) {
+	requests := make([][]interface{}, 100)
+	for i := range requests {
+		requests[i] = []interface{}{fmt.Sprintf("user%d", i), "data1", "read"}
+	}
+	stored := func(e *CachedEnforcer) []string {
+		var keys []string
+		for _, rvals := range requests {
+			if e.IsCached(rvals...) {
+				keys = append(keys, rvals[0].(string))
+			}
+		}
+		return keys
+	}
+
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.SetCacheSampleRate(1)
+	for _, rvals := range requests {
+		_, _ = e.Enforce(rvals...)
+	}
+	if n := e.CacheLen(); n != len(requests) {
+		t.Errorf("cache holds %d decisions with a sample rate of 1, supposed to be %d", n, len(requests))
+	}
+
+	// The sample is the same for every enforcer and every call.
+	var samples [][]string
+	for i := 0; i < 2; i++ {
+		e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+		e.SetCacheSampleRate(10)
+		for j := 0; j < 2; j++ {
+			for _, rvals := range requests {
+				_, _ = e.Enforce(rvals...)
+			}
+		}
+		if _, err := e.BatchEnforce(requests); err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, stored(e))
+	}
+	if n := len(samples[0]); n == 0 || n > 30 {
+		t.Errorf("%d decisions sampled out of %d with a sample rate of 10", n, len(requests))
+	}
+	if !reflect.DeepEqual(samples[0], samples[1]) {
+		t.Errorf("samples %v and %v, supposed to be the same", samples[0], samples[1])
+	}
+}
+
+func TestCacheSetReWarmOnClear(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.SetReWarmOnClear(true)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "alice", "data2", "read", false)
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+	if _, err := e.EnforceWithMatcher("r.sub == p.sub", "bob", "data1", "read"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.InvalidateCache(); err != nil {
+		t.Fatal(err)
+	}
+	if n := e.CacheLen(); n != 0 {
+		t.Fatalf("CacheLen: %d right after InvalidateCache, supposed to be 0", n)
+	}
+	// The first access starts re-warming the other keys in the background.
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	deadline := time.Now().Add(time.Second)
+	for !e.IsCached("alice", "data2", "read") || !e.IsCached("bob", "data2", "write") {
+		if time.Now().After(deadline) {
+			t.Fatal("the keys captured before InvalidateCache were not re-warmed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	testEnforceWithCacheInfo(t, e, "alice", "data2", "read", false, true)
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, true)
+	// The decision with a custom matcher can't be evaluated again.
+	if n := e.CacheLen(); n != 3 {
+		t.Errorf("CacheLen: %d, supposed to be 3", n)
+	}
+
+	// A closed enforcer doesn't re-warm.
+	if err := e.InvalidateCache(); err != nil {
+		t.Fatal(err)
+	}
+	_ = e.Close()
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	time.Sleep(10 * time.Millisecond)
+	if e.IsCached("bob", "data2", "write") {
+		t.Error("a closed enforcer should not re-warm the cache")
+	}
+}
+
+func TestCacheSetReWarmOnClearNonString(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/abac_model.conf")
+	e.SetReWarmOnClear(true)
+	e.SetNonStringPolicy(NonStringCoerce)
+	testEnforceCache(t, e, "alice", newTestResource("data1", "alice"), "read", true)
+
+	// The coerced key can't be evaluated again, the struct would be rebuilt as a string.
+	if err := e.InvalidateCache(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := e.reWarmKeys.Load().([]string); len(keys) != 0 {
+		t.Errorf("keys %v captured with NonStringCoerce, supposed to be none", keys)
+	}
+
+	// Nor can a key hashed for its length.
+	e.SetNonStringPolicy(NonStringBypass)
+	e.SetMaxKeyLength(10)
+	e.SetKeyOverflow(KeyOverflowHash)
+	testEnforceCache(t, e, "alice", "data1", "read", false)
+	if err := e.InvalidateCache(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := e.reWarmKeys.Load().([]string); len(keys) != 0 {
+		t.Errorf("keys %v captured with KeyOverflowHash, supposed to be none", keys)
+	}
+}
+
+func TestCacheSetDefaultDecisionOnError(t *testing.T) {
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = broken() && r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`)
+	errBroken := fmt.Errorf("broken failed")
+	for _, c := range []persist.Cache{testBrokenCache{}, testBrokenGetOrSetCache{}} {
+		for _, d := range []bool{false, true} {
+			e, _ := NewCachedEnforcer(m, fileadapter.NewAdapter("examples/basic_policy.csv"))
+			e.AddFunction("broken", func(args ...interface{}) (interface{}, error) {
+				return nil, errBroken
+			})
+			e.SetCache(c)
+			e.SetDefaultDecisionOnError(d)
+
+			// Both the cache and the evaluation fail.
+			if res, err := e.Enforce("alice", "data1", "read"); res != d || !errors.Is(err, errBroken) {
+				t.Errorf("%T: Enforce: %t, %v, supposed to be %t with the error of the evaluation", c, res, err, d)
+			}
+			// The cache fails and the decision is not evaluated.
+			e.FailOpenOnCacheError(false)
+			if res, err := e.Enforce("alice", "data1", "read"); res != d || !errors.Is(err, errTestOutage) {
+				t.Errorf("%T: Enforce: %t, %v, supposed to be %t with the error of the cache", c, res, err, d)
+			}
+
+			// The evaluation fails with a healthy cache.
+			e.SetCache(cache.NewDefaultCache())
+			if res, err := e.Enforce("alice", "data1", "read"); res || !errors.Is(err, errBroken) {
+				t.Errorf("Enforce: %t, %v, supposed to be false with the error of the evaluation", res, err)
+			}
+		}
+	}
+}
+
+func TestCacheEnableSlidingExpiration(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	clock := &testClock{now: time.Unix(0, 0)}
+	e.SetCache(cache.NewDefaultCache(cache.WithClock(clock)))
+	e.SetExpireTime(10)
+	e.EnableSlidingExpiration(true)
+
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, false)
+	// alice keeps being hit within the survival time, way past it in total, while bob is idle.
+	for i := 0; i < 10; i++ {
+		clock.Advance(8 * time.Second)
+		testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+	}
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, false)
+
+	e.EnableSlidingExpiration(false)
+	clock.Advance(8 * time.Second)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+	clock.Advance(8 * time.Second)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+}
+
+func TestCacheSwapCache(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	first := e.cache
+	warm := cache.NewDefaultCache()
+	_ = warm.Set("5:alice$$5:data1$$4:read$$", true)
+
+	old, err := e.SwapCache(warm)
+	if err != nil || old != first {
+		t.Fatalf("SwapCache: %v, %v, supposed to return the previous cache", old, err)
+	}
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+	if old, err := e.SwapCache(nil); err == nil || old != nil {
+		t.Errorf("SwapCache of nil: %v, %v, supposed to fail", old, err)
+	}
+
+	// The enforcements running while the cache is swapped use one cache or the other, run with -race.
+	caches := []persist.Cache{cache.NewDefaultCache(), cache.NewLRUCache(10), cache.NewShardedCache(4, func() persist.Cache { return cache.NewDefaultCache() })}
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if res, err := e.Enforce("alice", "data1", "read"); err != nil || !res {
+					t.Errorf("Enforce: %t, %v, supposed to be true", res, err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		c := caches[i%len(caches)]
+		old, err := e.SwapCache(c)
+		if err != nil || old == nil {
+			t.Errorf("SwapCache: %v, %v, supposed to return the previous cache", old, err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestCacheFilterCache(t *testing.T) {
+	for _, c := range []persist.Cache{cache.NewDefaultCache(), cache.NewLRUCache(10), testKeysCache{cache.NewDefaultCache()}} {
+		e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+		e.SetCache(c)
+		testEnforceCache(t, e, "alice", "data1", "read", true)
+		testEnforceCache(t, e, "alice", "data2", "read", false)
+		testEnforceCache(t, e, "bob", "data2", "write", true)
+		testEnforceCache(t, e, "bob", "data1", "read", false)
+		_, _, _ = e.EnforceEx("bob", "data1", "write")
+
+		// Only the allowed decisions are kept.
+		if err := e.FilterCache(func(key string, value bool) bool { return value }); err != nil {
+			t.Fatal(err)
+		}
+		for _, rvals := range [][]interface{}{{"alice", "data1", "read"}, {"bob", "data2", "write"}} {
+			if !e.IsCached(rvals...) {
+				t.Errorf("%T: decision of %v should still be cached", c, rvals)
+			}
+		}
+		for _, rvals := range [][]interface{}{{"alice", "data2", "read"}, {"bob", "data1", "read"}} {
+			if e.IsCached(rvals...) {
+				t.Errorf("%T: denial of %v should be deleted", c, rvals)
+			}
+		}
+		if key, _ := e.CacheKey("bob", "data1", "write"); e.explainCache.Has(key) {
+			t.Errorf("%T: denial of EnforceEx should be deleted", c)
+		}
+	}
+
+	// A cache which can't be iterated is cleared.
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	c := cache.NewDefaultCache()
+	e.SetCache(struct{ persist.Cache }{c})
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	if err := e.FilterCache(func(key string, value bool) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.Len(); n != 0 {
+		t.Errorf("cache holds %d decisions, supposed to be cleared", n)
+	}
+}
+
+func TestCacheEnforceWithKey(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.SetCacheNamespace("tenant1")
+
+	if res, err := e.EnforceWithKey("alice/data1/read", "alice", "data1", "read"); err != nil || !res {
+		t.Fatalf("EnforceWithKey: %t, %v, supposed to be true", res, err)
+	}
+	if stats := e.CacheStats(); stats.Misses != 1 || stats.Hits != 0 {
+		t.Errorf("stats: %+v, supposed to have 1 miss", stats)
+	}
+	if _, err := e.cache.Get("tenant1:alice/data1/read"); err != nil {
+		t.Errorf("the decision is not cached under the key of the caller: %v", err)
+	}
+	// The hit is served by the key, whatever the request values.
+	_, _ = e.Enforcer.RemovePolicy("alice", "data1", "read")
+	if res, err := e.EnforceWithKey("alice/data1/read", "alice", "data1", "read"); err != nil || !res {
+		t.Errorf("EnforceWithKey: %t, %v, supposed to be the cached true", res, err)
+	}
+	if stats := e.CacheStats(); stats.Misses != 1 || stats.Hits != 1 {
+		t.Errorf("stats: %+v, supposed to have 1 hit and 1 miss", stats)
+	}
+	// The built-in key is distinct.
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", false, false)
+
+	e.BypassCacheForSubjects("bob")
+	if res, err := e.EnforceWithKey("bob/data2/write", "bob", "data2", "write"); err != nil || !res {
+		t.Errorf("EnforceWithKey: %t, %v, supposed to be true", res, err)
+	}
+	if stats := e.CacheStats(); stats.Bypasses != 1 {
+		t.Errorf("stats: %+v, supposed to have 1 bypass", stats)
+	}
+}
+
+func TestCacheMissLatencyHistogram(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	for _, d := range []time.Duration{0, 10 * time.Microsecond, 11 * time.Microsecond, 2 * time.Millisecond, 2 * time.Millisecond, time.Minute} {
+		e.observeMissLatency(d)
+	}
+	counts := map[time.Duration]uint64{}
+	buckets := e.MissLatencyHistogram()
+	for i, bucket := range buckets {
+		if i > 0 && i < len(buckets)-1 && bucket.UpperBound <= buckets[i-1].UpperBound {
+			t.Errorf("bucket %d: bound %v, supposed to be after %v", i, bucket.UpperBound, buckets[i-1].UpperBound)
+		}
+		if bucket.Count != 0 {
+			counts[bucket.UpperBound] = bucket.Count
+		}
+	}
+	want := map[time.Duration]uint64{10 * time.Microsecond: 2, 50 * time.Microsecond: 1, 5 * time.Millisecond: 2, 0: 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("histogram: %v, supposed to be %v", counts, want)
+	}
+
+	// The misses of Enforce are counted, the hits are not.
+	e.ResetCacheStats()
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+	var total uint64
+	for _, bucket := range e.MissLatencyHistogram() {
+		total += bucket.Count
+	}
+	if total != 2 {
+		t.Errorf("histogram counts %d misses, supposed to be 2", total)
+	}
+
+	// An evaluation of about 20ms is counted in the bucket up to 50ms.
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = slow() && r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`)
+	e, _ = NewCachedEnforcer(m, fileadapter.NewAdapter("examples/basic_policy.csv"))
+	var slowed int32
+	e.AddFunction("slow", func(args ...interface{}) (interface{}, error) {
+		// The matcher is evaluated for each rule, only the first one sleeps.
+		if atomic.AddInt32(&slowed, 1) == 1 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return true, nil
+	})
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	for _, bucket := range e.MissLatencyHistogram() {
+		if bucket.UpperBound == 50*time.Millisecond && bucket.Count != 1 {
+			t.Errorf("bucket up to 50ms counts %d misses, supposed to be 1", bucket.Count)
+		}
+	}
+}
+
+func TestCacheSetCacheReadOnly(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	shared := cache.NewDefaultCache()
+	_ = shared.Set("3:bob$$5:data2$$5:write$$", true)
+	e.SetCache(shared)
+	e.SetCacheReadOnly(true)
+
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+	if _, err := e.BatchEnforce([][]interface{}{{"alice", "data2", "read"}, {"bob", "data2", "write"}}); err != nil {
+		t.Fatal(err)
+	}
+	// The decisions populated by another enforcer are still hit.
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, true)
+	if n := shared.Len(); n != 1 {
+		t.Errorf("cache holds %d decisions, supposed to be only the one set by another enforcer", n)
+	}
+
+	e.SetCacheReadOnly(false)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+}
+
+// testReadOnlyCache fails to store the decisions but looks them up in its inner cache.
+type testReadOnlyCache struct {
+	persist.Cache
+}
+
+func (testReadOnlyCache) Set(key string, value bool, extra ...interface{}) error {
+	return errTestOutage
+}
+
+func TestCacheCacheError(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.SetCache(testReadOnlyCache{cache.NewDefaultCache()})
+	e.FailOpenOnCacheError(false)
+
+	res, err := e.Enforce("alice", "data1", "read")
+	var cacheErr *CacheError
+	if !errors.As(err, &cacheErr) || cacheErr.Op != "set" || !errors.Is(err, errTestOutage) {
+		t.Fatalf("Enforce returned %v, supposed to be a *CacheError of set wrapping %v", err, errTestOutage)
+	}
+	if !res {
+		t.Errorf("Enforce returned %t, supposed to be the evaluated decision true", res)
+	}
+
+	// The errors of the evaluation are not the fault of the cache.
+	e.SetCache(cache.NewDefaultCache())
+	if _, err = e.Enforce("alice"); err == nil || errors.As(err, &cacheErr) {
+		t.Errorf("Enforce returned %v, supposed to be an error of the evaluation", err)
+	}
+}
+
+// testDeadlineCache gives up storing the decisions once the context is done, like a remote cache.
+type testDeadlineCache struct {
+	persist.Cache
+}
+
+func (c testDeadlineCache) SetCtx(ctx context.Context, key string, value bool, extra ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Set(key, value, extra...)
+}
+
+func (c testDeadlineCache) GetCtx(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return c.Get(key)
+}
+
+func TestCacheEnforceCtxTTL(t *testing.T) {
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act && done()
+`)
+	e, _ := NewCachedEnforcer(m, fileadapter.NewAdapter("examples/basic_policy.csv"))
+	c := cache.NewDefaultCache()
+	e.SetCache(c)
+	e.SetExpireTime(60)
+	cancel := func() {}
+	e.AddFunction("done", func(args ...interface{}) (interface{}, error) {
+		cancel()
+		return true, nil
+	})
+
+	if res, err := e.EnforceCtxTTL(context.Background(), 3600, "alice", "data1", "read"); !res || err != nil {
+		t.Fatalf("EnforceCtxTTL returned %t, %v, supposed to be true, nil", res, err)
+	}
+	if ttl, _ := c.TTL("5:alice$$5:data1$$4:read$$"); ttl <= time.Minute || ttl > time.Hour {
+		t.Errorf("TTL: %v, supposed to be 1h", ttl)
+	}
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	cancelCtx()
+	if res, err := e.EnforceCtxTTL(ctx, 3600, "alice", "data1", "read"); res || err != context.Canceled {
+		t.Errorf("EnforceCtxTTL returned %t, %v, supposed to be false, %v", res, err, context.Canceled)
+	}
+
+	// A decision evaluated while the context gets done is returned, a context-aware cache gives up storing it.
+	e.SetCache(testDeadlineCache{cache.NewDefaultCache()})
+	e.ResetCacheStats()
+	ctx, cancel = context.WithCancel(context.Background())
+	if res, err := e.EnforceCtxTTL(ctx, 3600, "bob", "data2", "write"); !res || err != nil {
+		t.Errorf("EnforceCtxTTL returned %t, %v, supposed to be true, nil", res, err)
+	}
+	if stats := e.CacheStats(); stats.Errors != 1 {
+		t.Errorf("stats: %+v, supposed to count the store given up with the context", stats)
+	}
+	cancel = func() {}
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, false)
+}
+
+func TestCachePreset(t *testing.T) {
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act && evaluated()
+`)
+	e, _ := NewCachedEnforcer(m, fileadapter.NewAdapter("examples/basic_policy.csv"))
+	c := cache.NewDefaultCache()
+	e.SetCache(c)
+	var evaluations int32
+	e.AddFunction("evaluated", func(args ...interface{}) (interface{}, error) {
+		atomic.AddInt32(&evaluations, 1)
+		return true, nil
+	})
+
+	// The preset decisions are returned even if the policy decides otherwise.
+	if err := e.Preset([]interface{}{"alice", "data1", "read"}, false, 3600); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.PresetMany([][]interface{}{{"bob", "data1", "read"}, {"bob", "data2", "write"}}, []bool{true, false}, 0); err != nil {
+		t.Fatal(err)
+	}
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", false, true)
+	testEnforceWithCacheInfo(t, e, "bob", "data1", "read", true, true)
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", false, true)
+	if n := atomic.LoadInt32(&evaluations); n != 0 {
+		t.Errorf("the matcher was evaluated %d times, supposed to be never", n)
+	}
+	if ttl, _ := c.TTL("5:alice$$5:data1$$4:read$$"); ttl <= time.Minute || ttl > time.Hour {
+		t.Errorf("TTL: %v, supposed to be 1h", ttl)
+	}
+
+	e.BypassCacheForSubjects("root")
+	if err := e.Preset([]interface{}{"root", "data1", "read"}, true, 0); !errors.Is(err, ErrRequestNotCacheable) {
+		t.Errorf("Preset returned %v, supposed to be %v", err, ErrRequestNotCacheable)
+	}
+	if err := e.PresetMany([][]interface{}{{"alice", "data2", "read"}}, nil, 0); err == nil {
+		t.Error("PresetMany with fewer decisions than requests is supposed to fail")
+	}
+	if e.IsCached("alice", "data2", "read") {
+		t.Error("a failed PresetMany is not supposed to cache anything")
+	}
+}
+
+func TestCacheLastPolicyChange(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	created := e.LastPolicyChange()
+	if created.IsZero() {
+		t.Fatal("the loading of the policy by NewCachedEnforcer is supposed to be recorded")
+	}
+
+	time.Sleep(time.Millisecond)
+	if err := e.LoadPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := e.LastPolicyChange()
+	if !loaded.After(created) {
+		t.Errorf("LoadPolicy didn't advance the last policy change: %v, previously %v", loaded, created)
+	}
+
+	// A mutation which doesn't change the policy doesn't count.
+	time.Sleep(time.Millisecond)
+	if _, err := e.AddPolicy("alice", "data1", "read"); err != nil {
+		t.Fatal(err)
+	}
+	if changed := e.LastPolicyChange(); !changed.Equal(loaded) {
+		t.Errorf("AddPolicy of an existing rule moved the last policy change to %v, supposed to stay %v", changed, loaded)
+	}
+	if _, err := e.AddPolicy("alice", "data2", "read"); err != nil {
+		t.Fatal(err)
+	}
+	if changed := e.LastPolicyChange(); !changed.After(loaded) {
+		t.Errorf("AddPolicy didn't advance the last policy change: %v, previously %v", changed, loaded)
+	}
+
+	if changed := (&CachedEnforcer{}).LastPolicyChange(); !changed.IsZero() {
+		t.Errorf("LastPolicyChange of the zero value: %v, supposed to be the zero time", changed)
+	}
+}
+
+func TestCacheEvaluateKey(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.SetCacheNamespace("tenant1")
+	key, _ := e.CacheKey("alice", "data1", "read")
+	if res, err := e.EvaluateKey(key); err != nil || !res {
+		t.Errorf("EvaluateKey(%s): %t, %v, supposed to be true", key, res, err)
+	}
+	if n := e.CacheLen(); n != 0 {
+		t.Errorf("cache holds %d decisions, EvaluateKey is not supposed to cache", n)
+	}
+	for _, key := range []string{"5:alice$$5:data1$$4:read$$", key + matcherKeySuffix("true"), "tenant1:alice$$data1$$read$$"} {
+		if _, err := e.EvaluateKey(key); !errors.Is(err, persist.ErrNoSuchKey) {
+			t.Errorf("EvaluateKey(%s) returned %v, supposed to be %v", key, err, persist.ErrNoSuchKey)
+		}
+	}
+}
+
+func TestCacheVerifyCache(t *testing.T) {
+	// The decisions are looked up with GetOrSet, or with Get and Set.
+	for _, c := range []persist.Cache{cache.NewDefaultCache(), testKeysCache{cache.NewDefaultCache()}} {
+		t.Run(fmt.Sprintf("%T", c), func(t *testing.T) {
+			testVerifyCache(t, c)
+		})
+	}
+}
+
+func testVerifyCache(t *testing.T, c persist.Cache) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.SetCache(c)
+	type drift struct {
+		key          string
+		cached, live bool
+	}
+	var drifts []drift
+	e.SetOnDrift(func(key string, cached, live bool) {
+		drifts = append(drifts, drift{key, cached, live})
+	})
+	testEnforceCache(t, e, "alice", "data1", "read", true)
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+
+	// The policy changes underneath the cache, without invalidating it.
+	if _, err := e.Enforcer.RemovePolicy("alice", "data1", "read"); err != nil {
+		t.Fatal(err)
+	}
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+	if len(drifts) != 0 {
+		t.Errorf("drifts: %v, supposed to be none without verification", drifts)
+	}
+
+	e.VerifyCache(1)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", false, true)
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, true)
+	if want := []drift{{"5:alice$$5:data1$$4:read$$", true, false}}; !reflect.DeepEqual(drifts, want) {
+		t.Errorf("drifts: %v, supposed to be %v", drifts, want)
+	}
+	// The cache is corrected.
+	e.VerifyCache(0)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", false, true)
+}
+
+func TestCacheSetTTLJitter(t *testing.T) {
+	var requests [][]interface{}
+	for i := 0; i < 20; i++ {
+		requests = append(requests, []interface{}{fmt.Sprintf("user%d", i), "data1", "read"})
+	}
+	warm := func(seed uint64) (*CachedEnforcer, *cache.DefaultCache, *testClock) {
+		e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+		clock := &testClock{now: time.Unix(0, 0)}
+		c := cache.NewDefaultCache(cache.WithClock(clock))
+		e.SetCache(c)
+		e.SetExpireTime(100)
+		e.SetTTLJitter(0.5)
+		e.SetTTLJitterSeed(seed)
+		if err := e.WarmCache(requests); err != nil {
+			t.Fatal(err)
+		}
+		return e, c, clock
+	}
+
+	e, c, clock := warm(1)
+	ttls := map[time.Duration]bool{}
+	for _, rvals := range requests {
+		key, _ := e.CacheKey(rvals...)
+		ttl, err := c.TTL(key)
+		if err != nil || ttl < 50*time.Second || ttl > 150*time.Second {
+			t.Errorf("TTL of %v: %v, %v, supposed to be within 100s ± 50%%", rvals, ttl, err)
+		}
+		ttls[ttl] = true
+	}
+	if len(ttls) < 5 {
+		t.Errorf("the decisions cached together expire at only %d different times", len(ttls))
+	}
+
+	want := entriesTTL(t, c)
+
+	// The decisions expire at spread-out times.
+	clock.Advance(100 * time.Second)
+	if n := len(c.Keys()); n == 0 || n == len(requests) {
+		t.Errorf("%d of the %d decisions are still cached after 100s, supposed to be some of them", n, len(requests))
+	}
+
+	// The jitter is the same with the same seed.
+	_, same, _ := warm(1)
+	if !reflect.DeepEqual(entriesTTL(t, same), want) {
+		t.Error("the survival times differ with the same seed")
+	}
+}
+
+// entriesTTL returns the remaining survival times of the decisions of c.
+func entriesTTL(t *testing.T, c *cache.DefaultCache) map[string]time.Duration {
+	t.Helper()
+	entries, err := c.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttls := make(map[string]time.Duration, len(entries))
+	for key, entry := range entries {
+		ttls[key] = entry.TTL
+	}
+	return ttls
+}
+
+func TestCacheEnforceDetailed(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	for _, tc := range []struct {
+		name          string
+		rvals         []interface{}
+		res           bool
+		hit, bypassed bool
+	}{
+		{"miss", []interface{}{"alice", "data1", "read"}, true, false, false},
+		{"hit", []interface{}{"alice", "data1", "read"}, true, true, false},
+		{"non-string bypass", []interface{}{"alice", 1, "read"}, false, false, true},
+		{"non-string bypass again", []interface{}{"alice", 1, "read"}, false, false, true},
+	} {
+		res, hit, bypassed, err := e.EnforceDetailed(tc.rvals...)
+		if err != nil || res != tc.res || hit != tc.hit || bypassed != tc.bypassed {
+			t.Errorf("%s: %t, hit %t, bypassed %t, %v, supposed to be %t, hit %t, bypassed %t",
+				tc.name, res, hit, bypassed, err, tc.res, tc.hit, tc.bypassed)
+		}
+	}
+
+	e.EnableCache(false)
+	if _, hit, bypassed, _ := e.EnforceDetailed("alice", "data1", "read"); hit || !bypassed {
+		t.Errorf("with the cache disabled: hit %t, bypassed %t, supposed to be bypassed", hit, bypassed)
+	}
+}
+
+func TestNewCachedEnforcerFrom(t *testing.T) {
+	enforcer, _ := NewEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	if _, err := enforcer.AddPolicy("carol", "data3", "read"); err != nil {
+		t.Fatal(err)
+	}
+	w := &testCallbackWatcher{}
+	if err := enforcer.SetWatcher(w); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewCachedEnforcerFrom(enforcer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Enforcer != enforcer {
+		t.Error("the enforcer is supposed to be wrapped, not created again")
+	}
+	// The policy of the enforcer is used.
+	testEnforceWithCacheInfo(t, e, "carol", "data3", "read", true, false)
+	testEnforceWithCacheInfo(t, e, "carol", "data3", "read", true, true)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+
+	// The notifications of its watcher invalidate the cached decisions.
+	w.callback("")
+	if n := e.CacheLen(); n != 0 {
+		t.Errorf("cache holds %d decisions after a watcher update, supposed to be 0", n)
+	}
+
+	if _, err := NewCachedEnforcerFrom(nil); err == nil {
+		t.Error("NewCachedEnforcerFrom(nil) is supposed to fail")
+	}
+}
+
+func TestCacheSetMaxKeyLength(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.SetMaxKeyLength(32)
+	long := strings.Repeat("x", 64)
+
+	// The requests with a long key bypass the cache by default.
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+	testEnforceWithCacheInfo(t, e, "alice", long, "read", false, false)
+	testEnforceWithCacheInfo(t, e, "alice", long, "read", false, false)
+	if stats := e.CacheStats(); stats.Bypasses != 2 {
+		t.Errorf("bypasses: %d, supposed to be 2", stats.Bypasses)
+	}
+	if n := e.CacheLen(); n != 1 {
+		t.Errorf("cache holds %d decisions, supposed to be only the one with a short key", n)
+	}
+
+	// Or they are cached by the hash of their key.
+	e.SetKeyOverflow(KeyOverflowHash)
+	testEnforceWithCacheInfo(t, e, "alice", long, "read", false, false)
+	testEnforceWithCacheInfo(t, e, "alice", long, "read", false, true)
+	key, ok := e.CacheKey("alice", long, "read")
+	if !ok || len(key) > 64 || strings.Contains(key, long) {
+		t.Errorf("key of a long request: %s, supposed to be hashed", key)
+	}
+	if short, _ := e.CacheKey("alice", "data1", "read"); short != "5:alice$$5:data1$$4:read$$" {
+		t.Errorf("key of a short request: %s, supposed not to be hashed", short)
+	}
+	// A partial invalidation can't find the hashed keys, so it deletes all the decisions.
+	if err := e.InvalidateCacheForSubject("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if n := e.CacheLen(); n != 0 {
+		t.Errorf("cache holds %d decisions after a partial invalidation, supposed to be 0", n)
+	}
+}
+
+// testSnapshotCache blocks once it took the snapshot of its keys until it is released, and counts the batches deleting them.
+// It hides the native deletion by segment of its inner cache, so that the invalidations scan its keys.
+type testSnapshotCache struct {
+	testScanCache
+	snapshotting chan struct{}
+	release      chan struct{}
+	batches      int32
+}
+
+// testScanCache is a cache which can be scanned, but can't delete the keys by segment.
+type testScanCache interface {
+	persist.BatchCache
+	persist.KeysCache
+	persist.LenCache
+	persist.ConcurrentCache
+}
+
+func (c *testSnapshotCache) Keys() []string {
+	keys := c.testScanCache.Keys()
+	close(c.snapshotting)
+	<-c.release
+	return keys
+}
+
+func (c *testSnapshotCache) DeleteMany(keys []string) error {
+	atomic.AddInt32(&c.batches, 1)
+	return c.testScanCache.DeleteMany(keys)
+}
+
+func TestCacheSetInvalidationBatchSize(t *testing.T) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	c := &testSnapshotCache{testScanCache: cache.NewDefaultCache(), snapshotting: make(chan struct{}), release: make(chan struct{})}
+	e.SetCache(c)
+	e.SetInvalidationBatchSize(10)
+	entries := make(map[string]bool)
+	for i := 0; i < 95; i++ {
+		entries[fmt.Sprintf("%d:user%d$$5:data1$$4:read$$", len(fmt.Sprint(i))+4, i)] = false
+	}
+	_ = c.SetMany(entries)
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- e.InvalidateCacheForObject("data1")
+	}()
+	<-c.snapshotting
+	// The enforces proceed while the keys to delete are scanned.
+	enforced := make(chan struct{})
+	go func() {
+		testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, true)
+		testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+		close(enforced)
+	}()
+	select {
+	case <-enforced:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Enforce is blocked by the invalidation")
+	}
+	close(c.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt32(&c.batches); n != 10 {
+		t.Errorf("the keys were deleted in %d batches, supposed to be 10", n)
+	}
+	// The decision cached during the scan survives it, as documented.
+	if n := c.Len(); n != 2 {
+		t.Errorf("cache holds %d decisions, supposed to be the 2 of the enforces", n)
+	}
+}
+
+// testPanicCache panics on every lookup, like a buggy custom cache.
+type testPanicCache struct {
+	persist.Cache
+}
+
+func (testPanicCache) Get(key string) (bool, error) {
+	panic("buggy cache")
+}
+
+func TestCacheEnforceSafe(t *testing.T) {
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act && owns(r.sub, r.obj)
+`)
+	e, _ := NewCachedEnforcer(m, fileadapter.NewAdapter("examples/basic_policy.csv"))
+	var owners map[string]string
+	e.AddFunction("owns", func(args ...interface{}) (interface{}, error) {
+		// owners is nil until it is set, so the function panics.
+		owners[args[1].(string)] = args[0].(string)
+		return true, nil
+	})
+
+	res, err := e.EnforceSafe("alice", "data1", "read")
+	if !errors.Is(err, ErrEnforcePanicked) || !strings.Contains(err.Error(), "nil map") || res {
+		t.Errorf("EnforceSafe returned %t, %v, supposed to be false and %v", res, err, ErrEnforcePanicked)
+	}
+	e.SetDefaultDecisionOnError(true)
+	if res, err := e.EnforceSafe("alice", "data1", "read"); !errors.Is(err, ErrEnforcePanicked) || !res {
+		t.Errorf("EnforceSafe returned %t, %v, supposed to be the default decision true and %v", res, err, ErrEnforcePanicked)
+	}
+	e.SetDefaultDecisionOnError(false)
+
+	owners = make(map[string]string)
+	if res, err := e.EnforceSafe("alice", "data1", "read"); err != nil || !res {
+		t.Errorf("EnforceSafe returned %t, %v, supposed to be true once the function is fixed", res, err)
+	}
+
+	// A panicking cache doesn't crash the request, nor leave the enforcer locked.
+	e.SetCache(testPanicCache{cache.NewDefaultCache()})
+	if _, err := e.EnforceSafe("bob", "data2", "write"); !errors.Is(err, ErrEnforcePanicked) {
+		t.Errorf("EnforceSafe returned %v with a panicking cache, supposed to be %v", err, ErrEnforcePanicked)
+	}
+	e.SetCache(cache.NewDefaultCache())
+	testEnforceCache(t, e, "bob", "data2", "write", true)
+}
+
+func TestCacheSetDecisionTransform(t *testing.T) {
+	// The decisions are looked up with GetOrSet, or with Get and Set.
+	for _, c := range []persist.Cache{cache.NewDefaultCache(), testKeysCache{cache.NewDefaultCache()}} {
+		t.Run(fmt.Sprintf("%T", c), func(t *testing.T) {
+			testSetDecisionTransform(t, c)
+		})
+	}
+}
+
+func testSetDecisionTransform(t *testing.T, c persist.Cache) {
+	e, _ := NewCachedEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.SetCache(c)
+	calls := 0
+	// The writes are frozen.
+	e.SetDecisionTransform(func(rvals []interface{}, raw bool) bool {
+		calls++
+		return raw && rvals[2] != "write"
+	})
+
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", false, false)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, false)
+	key, _ := e.getKey("bob", "data2", "write")
+	if res, err := c.Get(key); err != nil || res {
+		t.Errorf("cached decision of bob: %t, %v, supposed to be the transformed false", res, err)
+	}
+
+	// The hits return the transformed decision without transforming it again.
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", false, true)
+	testEnforceWithCacheInfo(t, e, "alice", "data1", "read", true, true)
+	if calls != 2 {
+		t.Errorf("transform called %d times, supposed to be 2", calls)
+	}
+
+	results, err := e.BatchEnforce([][]interface{}{{"bob", "data2", "write"}, {"alice", "data1", "write"}})
+	if err != nil || results[0] || results[1] {
+		t.Errorf("BatchEnforce: %v, %v, supposed to be all denied", results, err)
+	}
+	if res, _, err := e.EnforceEx("bob", "data2", "write"); err != nil || res {
+		t.Errorf("EnforceEx of bob: %t, %v, supposed to be the transformed false", res, err)
+	}
+
+	e.SetDecisionTransform(nil)
+	e.InvalidateCache()
+	testEnforceWithCacheInfo(t, e, "bob", "data2", "write", true, false)
+}
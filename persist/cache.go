@@ -0,0 +1,404 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoSuchKey is returned by a Cache when the key does not exist or has expired.
+// A Cache may wrap it to add context, it is matched with errors.Is.
+var ErrNoSuchKey = errors.New("there's no such key existing in cache")
+
+// ErrCacheFull is returned by a Cache with a limit on its number of keys when a new key doesn't fit, instead of
+// evicting another one. CachedEnforcer still returns the decisions it fails to cache this way.
+var ErrCacheFull = errors.New("cache is full")
+
+// ErrInvalidTTL is returned by a Cache when the first parameter of extra for Set is not a survival time.
+var ErrInvalidTTL = errors.New("the survival time must be a uint or an int number of seconds, or a time.Duration")
+
+// ParseTTL returns the survival time of the first parameter of extra for Set, which is a uint or an int number of
+// seconds, or a time.Duration. It returns 0, meaning the item never expires, if extra is empty or starts with nil,
+// and an error wrapping ErrInvalidTTL for any other type, instead of ignoring it.
+func ParseTTL(extra ...interface{}) (time.Duration, error) {
+	if len(extra) == 0 || extra[0] == nil {
+		return 0, nil
+	}
+	switch ttl := extra[0].(type) {
+	case uint:
+		return time.Duration(ttl) * time.Second, nil
+	case int:
+		return time.Duration(ttl) * time.Second, nil
+	case time.Duration:
+		return ttl, nil
+	}
+	return 0, fmt.Errorf("%w, not a %T", ErrInvalidTTL, extra[0])
+}
+
+// TypedCache is the interface for caches of values of type T.
+type TypedCache[T any] interface {
+	// Set puts key and value into cache.
+	// First parameter for extra should be a uint denoting the expected survival time in seconds,
+	// like the one of CachedEnforcer.SetExpireTime, see ParseTTL for the other types accepted.
+	// If it is 0 or missing, the key never expires.
+	// CachedEnforcer passes the evaluation time of a decision as a time.Duration second parameter, which caches may ignore.
+	Set(key string, value T, extra ...interface{}) error
+
+	// Get returns result for key,
+	// If there's no such key existing in cache,
+	// ErrNoSuchKey will be returned.
+	Get(key string) (T, error)
+
+	// Delete will remove the specific key in cache.
+	// If there's no such key existing in cache,
+	// ErrNoSuchKey will be returned.
+	Delete(key string) error
+
+	// Clear deletes all the items stored in cache.
+	Clear() error
+}
+
+// Cache is the interface for the decision cache used by CachedEnforcer.
+type Cache = TypedCache[bool]
+
+// ContextCache is the interface for caches which can abort their work with a context, like remote caches.
+// CachedEnforcer.EnforceCtx uses it when the cache implements it.
+type ContextCache interface {
+	Cache
+
+	// SetCtx is like Set, but gives up once ctx is done.
+	SetCtx(ctx context.Context, key string, value bool, extra ...interface{}) error
+
+	// GetCtx is like Get, but gives up once ctx is done.
+	GetCtx(ctx context.Context, key string) (bool, error)
+}
+
+// LoadingCache is the interface for caches evaluating the decisions themselves on a miss, like the groupcache one.
+// CachedEnforcer uses it when the cache implements it, to count the decisions evaluated by the cache as misses
+// rather than hits.
+type LoadingCache interface {
+	Cache
+
+	// GetLoad is like Get, but gives up once ctx is done and also reports whether the decision was evaluated by the
+	// cache for this call rather than found in it.
+	GetLoad(ctx context.Context, key string) (value bool, loaded bool, err error)
+}
+
+// ConcurrentCache is the interface for caches which are safe for concurrent use on their own.
+// CachedEnforcer lets the lookups and stores of such a cache run in parallel, instead of serializing them.
+type ConcurrentCache interface {
+	Cache
+
+	// IsConcurrent reports whether the cache is safe for concurrent use.
+	IsConcurrent() bool
+}
+
+// GetOrSetCache is the interface for caches which can compute a missing value once for concurrent callers.
+type GetOrSetCache interface {
+	Cache
+
+	// GetOrSet returns the value for key, or else calls compute and puts its value into cache, extra is like for Set.
+	// Concurrent callers missing the same key share a single call of compute and its result.
+	// The value is not put into cache if compute returns an error.
+	// If the value is computed but doesn't fit, it is returned along with ErrCacheFull.
+	GetOrSet(key string, compute func() (bool, error), extra ...interface{}) (bool, error)
+}
+
+// EvictNotifyCache is the interface for caches which can report the items they evict to make room for new ones.
+type EvictNotifyCache interface {
+	Cache
+
+	// SetOnEvict sets a function called with the key of every evicted item, nil removes it.
+	// It is called with the lock of the cache held, so it must not call back into the cache.
+	SetOnEvict(fn func(key string))
+}
+
+// HasCache is the interface for caches which can tell whether they hold a key without the side effects of Get,
+// like marking it as recently used.
+type HasCache interface {
+	Cache
+
+	// Has reports whether key exists in cache and has not expired.
+	Has(key string) bool
+}
+
+// LenCache is the interface for caches which can report the number of items they hold.
+type LenCache interface {
+	Cache
+
+	// Len returns the number of items in cache.
+	Len() int
+}
+
+// KeysCache is the interface for caches which can list their keys.
+type KeysCache interface {
+	Cache
+
+	// Keys returns a snapshot of the keys in cache, which may be large.
+	Keys() []string
+}
+
+// PrefixCache is the interface for caches which can delete all the keys with a prefix.
+type PrefixCache interface {
+	Cache
+
+	// DeletePrefix removes all the keys starting with prefix, it is not an error if there is none.
+	DeletePrefix(prefix string) error
+}
+
+// DefaultKeySeparator is the separator following each segment of the built-in keys of CachedEnforcer, by default.
+const DefaultKeySeparator = "$$"
+
+// SegmentCache is the interface for caches which can delete the keys with a segment, for keys made of the segments
+// "<length>:<value><separator>" with DefaultKeySeparator, like the built-in keys of CachedEnforcer.
+type SegmentCache interface {
+	Cache
+
+	// DeleteBySegment removes all the keys whose segment at index, counting from 0, equals value.
+	// The keys which are not made of segments are kept, it is not an error if no key matches.
+	DeleteBySegment(index int, value string) error
+}
+
+// KeySegment returns the value of the segment at index, counting from 0, of a key made of the segments
+// "<length>:<value><sep>", or false if the key has no such segment.
+func KeySegment(key string, index int, sep string) (string, bool) {
+	if index < 0 {
+		return "", false
+	}
+	for i := 0; ; i++ {
+		colon := strings.IndexByte(key, ':')
+		if colon <= 0 {
+			return "", false
+		}
+		n, err := strconv.Atoi(key[:colon])
+		if err != nil || n < 0 || n > len(key)-colon-1 {
+			return "", false
+		}
+		segment, rest := key[colon+1:colon+1+n], key[colon+1+n:]
+		if !strings.HasPrefix(rest, sep) {
+			return "", false
+		}
+		if i == index {
+			return segment, true
+		}
+		key = rest[len(sep):]
+	}
+}
+
+// StaleCache is the interface for caches which can still return their expired items until they reclaim them.
+type StaleCache interface {
+	Cache
+
+	// GetStale is like Get, but also returns the value of key if it has expired and is not reclaimed yet.
+	GetStale(key string) (bool, error)
+}
+
+// TTLCache is the interface for caches which can report how long their items survive.
+type TTLCache interface {
+	Cache
+
+	// TTL returns the remaining survival time of key, or 0 if it never expires.
+	// If there's no such key existing in cache,
+	// ErrNoSuchKey will be returned.
+	TTL(key string) (time.Duration, error)
+}
+
+// TouchCache is the interface for caches which can extend the survival time of their items without setting them again.
+type TouchCache interface {
+	Cache
+
+	// Touch resets the survival time of key, extra is like for Set.
+	// If there's no such key existing in cache,
+	// ErrNoSuchKey will be returned.
+	Touch(key string, extra ...interface{}) error
+}
+
+// SetWithTTLCache is the interface for caches which take the survival time of their items as a time.Duration,
+// instead of the untyped first parameter of extra. CachedEnforcer uses it when the cache implements it.
+type SetWithTTLCache interface {
+	Cache
+
+	// SetWithTTL puts key and value into cache, surviving for ttl, or always if ttl is 0 or less.
+	SetWithTTL(key string, value bool, ttl time.Duration) error
+}
+
+// SetWithTTL puts key and value into c surviving for ttl, with c.SetWithTTL if c is a SetWithTTLCache,
+// or else with c.Set and ttl rounded up to whole seconds.
+func SetWithTTL(c Cache, key string, value bool, ttl time.Duration) error {
+	if tc, ok := c.(SetWithTTLCache); ok {
+		return tc.SetWithTTL(key, value, ttl)
+	}
+	var seconds uint
+	if ttl > 0 {
+		seconds = uint((ttl + time.Second - 1) / time.Second)
+	}
+	return c.Set(key, value, seconds)
+}
+
+// AgeCache is the interface for caches which can report how long ago their items were set.
+type AgeCache interface {
+	Cache
+
+	// Age returns how long ago key was set.
+	// If there's no such key existing in cache,
+	// ErrNoSuchKey will be returned.
+	Age(key string) (time.Duration, error)
+}
+
+// BatchCache is the interface for caches which can handle several keys at once, like remote caches
+// saving round trips. SetMany, GetMany and DeleteMany fall back to the single key methods for the other caches.
+type BatchCache interface {
+	Cache
+
+	// SetMany puts all the entries into cache, extra is like for Set.
+	SetMany(entries map[string]bool, extra ...interface{}) error
+
+	// GetMany returns the results for the keys existing in cache, the other keys are left out.
+	GetMany(keys []string) (map[string]bool, error)
+
+	// DeleteMany removes the keys from cache, it is not an error if some of them don't exist.
+	DeleteMany(keys []string) error
+}
+
+// SetMany puts all the entries into c, with c.SetMany if c is a BatchCache.
+func SetMany(c Cache, entries map[string]bool, extra ...interface{}) error {
+	if bc, ok := c.(BatchCache); ok {
+		return bc.SetMany(entries, extra...)
+	}
+	for key, value := range entries {
+		if err := c.Set(key, value, extra...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMany returns the results for the keys existing in c, with c.GetMany if c is a BatchCache.
+func GetMany(c Cache, keys []string) (map[string]bool, error) {
+	if bc, ok := c.(BatchCache); ok {
+		return bc.GetMany(keys)
+	}
+	results := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		value, err := c.Get(key)
+		if errors.Is(err, ErrNoSuchKey) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		results[key] = value
+	}
+	return results, nil
+}
+
+// DeleteMany removes the keys from c, with c.DeleteMany if c is a BatchCache.
+func DeleteMany(c Cache, keys []string) error {
+	if bc, ok := c.(BatchCache); ok {
+		return bc.DeleteMany(keys)
+	}
+	for _, key := range keys {
+		if err := c.Delete(key); err != nil && !errors.Is(err, ErrNoSuchKey) {
+			return err
+		}
+	}
+	return nil
+}
+
+// TypedCacheEntry is an item of a TypedCache, as listed by EntriesCache.
+type TypedCacheEntry[T any] struct {
+	Value T
+	// TTL is the remaining survival time of the item, 0 if it never expires.
+	TTL time.Duration
+}
+
+// CacheEntry is an item of a Cache, as listed by EntriesCache.
+type CacheEntry = TypedCacheEntry[bool]
+
+// EntriesCache is the interface for caches which can list their items, to save them for instance.
+type EntriesCache interface {
+	Cache
+
+	// Entries returns a snapshot of the unexpired items in cache, which may be large.
+	Entries() (map[string]CacheEntry, error)
+}
+
+// RangeCache is the interface for caches which can iterate over their items without copying them.
+type RangeCache interface {
+	Cache
+
+	// Range calls fn for each unexpired item in cache, in no particular order, until fn returns false.
+	// fn may be called with the lock of the cache held, so it must not call back into the cache.
+	Range(fn func(key string, value bool) bool) error
+}
+
+// ErrRangeUnsupported is returned by Range for a cache which can't list its items.
+var ErrRangeUnsupported = errors.New("the cache implements neither RangeCache, EntriesCache nor KeysCache")
+
+// Range calls fn for each item of c until fn returns false, with c.Range if c is a RangeCache, or else over the
+// items of c.Entries if c is an EntriesCache, or over the keys of c.Keys if c is a KeysCache.
+// ErrRangeUnsupported is returned if c is none of them.
+func Range(c Cache, fn func(key string, value bool) bool) error {
+	switch c := c.(type) {
+	case RangeCache:
+		return c.Range(fn)
+	case EntriesCache:
+		entries, err := c.Entries()
+		if err != nil {
+			return err
+		}
+		for key, entry := range entries {
+			if !fn(key, entry.Value) {
+				break
+			}
+		}
+		return nil
+	case KeysCache:
+		for _, key := range c.Keys() {
+			value, err := c.Get(key)
+			if errors.Is(err, ErrNoSuchKey) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if !fn(key, value) {
+				break
+			}
+		}
+		return nil
+	default:
+		return ErrRangeUnsupported
+	}
+}
+
+// EvictionStats holds the counters of the items a cache dropped on its own.
+type EvictionStats struct {
+	// Evictions is the number of items dropped to make room for new ones.
+	Evictions uint64
+	// Expirations is the number of expired items reclaimed.
+	Expirations uint64
+}
+
+// EvictionStatsCache is the interface for caches which count the items they drop on their own.
+type EvictionStatsCache interface {
+	Cache
+
+	// EvictionStats returns a snapshot of the counters since the cache was created.
+	EvictionStats() EvictionStats
+}
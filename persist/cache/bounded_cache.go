@@ -0,0 +1,276 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+type boundedEntry struct {
+	key  string
+	item cacheItem[bool]
+	// index is the position of the entry in the expiry heap, -1 if it never expires.
+	index int
+}
+
+// expiryHeap orders the entries which expire by their expiry, the next one to expire first.
+type expiryHeap []*boundedEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].item.expiresAt.Before(h[j].item.expiresAt) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	entry := x.(*boundedEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	entry.index = -1
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// BoundedCache is an in-memory implementation of persist.Cache bounded both in size and in time: it holds at most
+// capacity items, and each item expires after at most ttl. When a new item doesn't fit, an expired item is reclaimed
+// if there is one, or else the least recently used item is evicted.
+type BoundedCache struct {
+	capacity int
+	ttl      time.Duration
+	clock    Clock
+	ll       *list.List
+	m        map[string]*list.Element
+	expiries expiryHeap
+	mutex    sync.Mutex
+	// stats and onEvict are guarded by mutex.
+	stats   persist.EvictionStats
+	onEvict func(key string)
+}
+
+// NewBoundedCache creates an empty BoundedCache holding at most capacity items for at most ttl each, a capacity
+// less than 1 is treated as 1 and a ttl of 0 or less means the items only expire after their own survival time.
+func NewBoundedCache(capacity int, ttl time.Duration) *BoundedCache {
+	return NewBoundedCacheWithClock(capacity, ttl, realClock{})
+}
+
+// NewBoundedCacheWithClock is like NewBoundedCache, but reads the time from clock, to control the expiry in tests.
+func NewBoundedCacheWithClock(capacity int, ttl time.Duration, clock Clock) *BoundedCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &BoundedCache{
+		capacity: capacity,
+		ttl:      ttl,
+		clock:    clock,
+		ll:       list.New(),
+		m:        make(map[string]*list.Element),
+	}
+}
+
+// bound returns expiresAt, or the expiry after the ttl of the cache if it is sooner.
+func (c *BoundedCache) bound(now, expiresAt time.Time) time.Time {
+	if c.ttl <= 0 {
+		return expiresAt
+	}
+	if limit := now.Add(c.ttl); expiresAt.IsZero() || limit.Before(expiresAt) {
+		return limit
+	}
+	return expiresAt
+}
+
+// Set puts key and value into cache, the first parameter of extra is the survival time in seconds.
+// The item expires after the ttl of the cache if it is sooner, even if it is set to never expire.
+func (c *BoundedCache) Set(key string, value bool, extra ...interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.clock.Now()
+	expiresAt, err := expireAt(now, extra...)
+	if err != nil {
+		return err
+	}
+	c.setItem(key, cacheItem[bool]{value: value, expiresAt: c.bound(now, expiresAt)}, now)
+	return nil
+}
+
+// SetWithTTL puts key and value into cache, surviving for ttl, or the ttl of the cache if it is sooner.
+func (c *BoundedCache) SetWithTTL(key string, value bool, ttl time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.clock.Now()
+	c.setItem(key, cacheItem[bool]{value: value, expiresAt: c.bound(now, expireAfter(now, ttl))}, now)
+	return nil
+}
+
+// setItem puts item for key as the most recently used, making room for it over capacity.
+func (c *BoundedCache) setItem(key string, item cacheItem[bool], now time.Time) {
+	if elem, ok := c.m[key]; ok {
+		entry := elem.Value.(*boundedEntry)
+		entry.item = item
+		switch {
+		case entry.index >= 0 && item.expiresAt.IsZero():
+			heap.Remove(&c.expiries, entry.index)
+		case entry.index >= 0:
+			heap.Fix(&c.expiries, entry.index)
+		case !item.expiresAt.IsZero():
+			heap.Push(&c.expiries, entry)
+		}
+		c.ll.MoveToFront(elem)
+		return
+	}
+	entry := &boundedEntry{key: key, item: item, index: -1}
+	c.m[key] = c.ll.PushFront(entry)
+	if !item.expiresAt.IsZero() {
+		heap.Push(&c.expiries, entry)
+	}
+	for c.ll.Len() > c.capacity {
+		if len(c.expiries) > 0 && c.expiries[0].item.expired(now) {
+			c.removeElement(c.m[c.expiries[0].key])
+			c.stats.Expirations++
+			continue
+		}
+		elem := c.ll.Back()
+		c.removeElement(elem)
+		c.stats.Evictions++
+		if c.onEvict != nil {
+			c.onEvict(elem.Value.(*boundedEntry).key)
+		}
+	}
+}
+
+// Get returns the value for key and marks it as the most recently used.
+func (c *BoundedCache) Get(key string) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.m[key]
+	if !ok {
+		return false, persist.ErrNoSuchKey
+	}
+	entry := elem.Value.(*boundedEntry)
+	if entry.item.expired(c.clock.Now()) {
+		c.removeElement(elem)
+		c.stats.Expirations++
+		return false, persist.ErrNoSuchKey
+	}
+	c.ll.MoveToFront(elem)
+	return entry.item.value, nil
+}
+
+// Has reports whether key exists in cache and has not expired, without marking it as the most recently used.
+func (c *BoundedCache) Has(key string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.m[key]
+	return ok && !elem.Value.(*boundedEntry).item.expired(c.clock.Now())
+}
+
+// Delete removes key from cache.
+func (c *BoundedCache) Delete(key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.m[key]
+	if !ok {
+		return persist.ErrNoSuchKey
+	}
+	c.removeElement(elem)
+	return nil
+}
+
+// DeletePrefix removes all the keys starting with prefix.
+func (c *BoundedCache) DeletePrefix(prefix string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, elem := range c.m {
+		if strings.HasPrefix(key, prefix) {
+			c.removeElement(elem)
+		}
+	}
+	return nil
+}
+
+// Clear deletes all the items stored in cache.
+func (c *BoundedCache) Clear() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ll.Init()
+	c.m = make(map[string]*list.Element)
+	c.expiries = nil
+	return nil
+}
+
+// Len returns the number of items in cache, including the expired ones which are not reclaimed yet.
+func (c *BoundedCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ll.Len()
+}
+
+// Keys returns a snapshot of the keys of the unexpired items in cache, from the most to the least recently used.
+func (c *BoundedCache) Keys() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.clock.Now()
+	keys := make([]string, 0, c.ll.Len())
+	for elem := c.ll.Front(); elem != nil; elem = elem.Next() {
+		if entry := elem.Value.(*boundedEntry); !entry.item.expired(now) {
+			keys = append(keys, entry.key)
+		}
+	}
+	return keys
+}
+
+func (c *BoundedCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*boundedEntry)
+	c.ll.Remove(elem)
+	delete(c.m, entry.key)
+	if entry.index >= 0 {
+		heap.Remove(&c.expiries, entry.index)
+	}
+}
+
+// EvictionStats returns the number of items evicted for capacity and of expired items reclaimed.
+func (c *BoundedCache) EvictionStats() persist.EvictionStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.stats
+}
+
+// SetOnEvict sets a function called with the key of every item evicted for capacity, nil removes it.
+// The expired items reclaimed aren't reported. It is called with the lock of the cache held, so it must not call
+// back into the cache.
+func (c *BoundedCache) SetOnEvict(fn func(key string)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onEvict = fn
+}
+
+// IsConcurrent reports that the cache is safe for concurrent use.
+func (c *BoundedCache) IsConcurrent() bool {
+	return true
+}
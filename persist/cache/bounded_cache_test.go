@@ -0,0 +1,143 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+func TestBoundedCache(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := NewBoundedCacheWithClock(2, time.Minute, clock)
+	var _ persist.Cache = c
+	var _ persist.SetWithTTLCache = c
+	var _ persist.HasCache = c
+	var _ persist.LenCache = c
+	var _ persist.KeysCache = c
+	var _ persist.EvictionStatsCache = c
+	var _ persist.ConcurrentCache = c
+	var _ persist.EvictNotifyCache = c
+	var _ persist.PrefixCache = c
+
+	_ = c.Set("alice", true)
+	_ = c.Set("bob", false)
+
+	// alice becomes the most recently used, so bob is evicted for carol.
+	testGetCache(t, c, "alice", true, nil)
+	_ = c.Set("carol", true)
+	testGetCache(t, c, "bob", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "alice", true, nil)
+	testGetCache(t, c, "carol", true, nil)
+	if stats := c.EvictionStats(); stats != (persist.EvictionStats{Evictions: 1}) {
+		t.Errorf("stats: %+v, supposed to have 1 eviction", stats)
+	}
+
+	// Items set to never expire still expire after the ttl of the cache.
+	clock.Advance(time.Minute)
+	testGetCache(t, c, "alice", false, persist.ErrNoSuchKey)
+	if c.Has("carol") {
+		t.Error("carol is in cache after the ttl of the cache")
+	}
+	if keys := c.Keys(); len(keys) != 0 {
+		t.Errorf("Keys: %v, supposed to be empty", keys)
+	}
+	if stats := c.EvictionStats(); stats != (persist.EvictionStats{Evictions: 1, Expirations: 1}) {
+		t.Errorf("stats: %+v, supposed to have 1 eviction and 1 expiration", stats)
+	}
+
+	// A shorter survival time is kept, a longer one is capped by the ttl of the cache.
+	_ = c.Set("alice", true, uint(10))
+	_ = c.SetWithTTL("bob", true, time.Hour)
+	clock.Advance(10 * time.Second)
+	testGetCache(t, c, "alice", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "bob", true, nil)
+	clock.Advance(50 * time.Second)
+	testGetCache(t, c, "bob", false, persist.ErrNoSuchKey)
+}
+
+func TestBoundedCacheEvictsExpiredFirst(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := NewBoundedCacheWithClock(3, time.Minute, clock)
+	_ = c.Set("alice", true)
+	_ = c.Set("bob", true, uint(10))
+	_ = c.Set("carol", true)
+
+	// bob has expired, so it is reclaimed for dave even though alice is the least recently used.
+	clock.Advance(10 * time.Second)
+	_ = c.Set("dave", true)
+	if c.Len() != 3 {
+		t.Errorf("cache holds %d items, supposed to be 3", c.Len())
+	}
+	testGetCache(t, c, "alice", true, nil)
+	testGetCache(t, c, "carol", true, nil)
+	testGetCache(t, c, "dave", true, nil)
+	if stats := c.EvictionStats(); stats != (persist.EvictionStats{Expirations: 1}) {
+		t.Errorf("stats: %+v, supposed to have 1 expiration", stats)
+	}
+
+	// Nothing has expired, so the least recently used alice is evicted for erin.
+	_ = c.Set("erin", true)
+	testGetCache(t, c, "alice", false, persist.ErrNoSuchKey)
+	if stats := c.EvictionStats(); stats != (persist.EvictionStats{Evictions: 1, Expirations: 1}) {
+		t.Errorf("stats: %+v, supposed to have 1 eviction and 1 expiration", stats)
+	}
+
+	_ = c.Delete("carol")
+	_ = c.Clear()
+	if c.Len() != 0 || len(c.expiries) != 0 {
+		t.Errorf("cache holds %d items after Clear", c.Len())
+	}
+}
+
+func TestBoundedCacheOnEvict(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := NewBoundedCacheWithClock(2, time.Minute, clock)
+	var evicted []string
+	c.SetOnEvict(func(key string) { evicted = append(evicted, key) })
+
+	// The expired alice is reclaimed for carol without being reported, bob is evicted for dave.
+	_ = c.Set("alice", true, uint(10))
+	_ = c.Set("bob", true)
+	clock.Advance(10 * time.Second)
+	_ = c.Set("carol", true)
+	_ = c.Set("dave", true)
+	if len(evicted) != 1 || evicted[0] != "bob" {
+		t.Errorf("evicted %v, supposed to be [bob]", evicted)
+	}
+
+	c.SetOnEvict(nil)
+	_ = c.Set("erin", true)
+	if len(evicted) != 1 {
+		t.Errorf("evicted %v after the hook is removed", evicted)
+	}
+}
+
+func TestBoundedCacheDeletePrefix(t *testing.T) {
+	c := NewBoundedCache(4, time.Minute)
+	_ = c.Set("alice$$data1", true)
+	_ = c.Set("alice$$data2", true, uint(10))
+	_ = c.Set("bob$$data1", true)
+
+	_ = c.DeletePrefix("alice$$")
+	testGetCache(t, c, "alice$$data1", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "alice$$data2", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "bob$$data1", true, nil)
+	if c.Len() != 1 || len(c.expiries) != 1 {
+		t.Errorf("cache holds %d items and %d expiries, supposed to be 1", c.Len(), len(c.expiries))
+	}
+}
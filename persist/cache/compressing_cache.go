@@ -0,0 +1,151 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+// CompressingCache is a persist.Cache compressing the keys before passing them to an inner cache, to save memory
+// when the keys are long, like requests embedding attribute bundles. Unlike CachedEnforcer.HashKeys, the compression
+// is lossless, so distinct keys never share an item. The keys are compressed with a fast LZ77 codec in the manner of
+// snappy, replacing the repetitions with copies and leaving out any entropy coding, which takes under a microsecond per
+// key. Each key is compressed on its own, so without a preset dictionary of the content common to the keys there is
+// little to copy from and the keys barely shrink, or grow a few bytes, see NewCompressingCacheWithDict.
+// The inner keys are opaque, so the cache can't delete the keys with a prefix and CachedEnforcer.InvalidateCacheForSubject
+// clears it whole.
+// It is safe for concurrent use if the inner cache is.
+type CompressingCache struct {
+	inner    persist.Cache
+	dict     []byte
+	dictHash *[keyHashSize]int32
+	buffers  sync.Pool
+}
+
+// NewCompressingCache creates a CompressingCache in front of inner without a dictionary, which saves little memory
+// for the time it takes, prefer NewCompressingCacheWithDict.
+func NewCompressingCache(inner persist.Cache) *CompressingCache {
+	return NewCompressingCacheWithDict(inner, nil)
+}
+
+// NewCompressingCacheWithDict creates a CompressingCache in front of inner, compressing the keys with the preset
+// dictionary dict, like a typical key. The keys compressed with another dictionary are no longer hit.
+func NewCompressingCacheWithDict(inner persist.Cache, dict []byte) *CompressingCache {
+	c := &CompressingCache{inner: inner, dict: append([]byte(nil), dict...), dictHash: new([keyHashSize]int32)}
+	for i := 0; i+keyMinMatch <= len(c.dict); i++ {
+		c.dictHash[keyHash(c.dict[i:])] = int32(i + 1)
+	}
+	return c
+}
+
+const (
+	keyHashBits = 10
+	keyHashSize = 1 << keyHashBits
+	keyMinMatch = 4
+)
+
+// keyHash hashes the first keyMinMatch bytes of b.
+func keyHash(b []byte) uint32 {
+	return binary.LittleEndian.Uint32(b) * 0x1e35a7bd >> (32 - keyHashBits)
+}
+
+type keyBuffer struct {
+	src   []byte
+	dst   []byte
+	table [keyHashSize]int32
+}
+
+// compress returns the key encoded as a sequence of literals and copies from the dictionary or the key itself.
+// Each literal is its length times 2 as a uvarint then its bytes, each copy its length times 2 plus 1 then its
+// backward offset as uvarints, so the encoding is decodable and distinct keys never share a compressed key.
+func (c *CompressingCache) compress(key string) string {
+	kb, _ := c.buffers.Get().(*keyBuffer)
+	if kb == nil {
+		kb = &keyBuffer{}
+	}
+	kb.src = append(append(kb.src[:0], c.dict...), key...)
+	kb.dst = kb.dst[:0]
+	kb.table = *c.dictHash
+	src := kb.src
+	lit := len(c.dict)
+	for i := lit; i+keyMinMatch <= len(src); {
+		h := keyHash(src[i:])
+		candidate := int(kb.table[h]) - 1
+		kb.table[h] = int32(i + 1)
+		if candidate < 0 || binary.LittleEndian.Uint32(src[candidate:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+		n := keyMinMatch
+		for i+n < len(src) && src[candidate+n] == src[i+n] {
+			n++
+		}
+		kb.dst = appendKeyLiteral(kb.dst, src[lit:i])
+		kb.dst = appendUvarint(appendUvarint(kb.dst, uint64(n)<<1|1), uint64(i-candidate))
+		i += n
+		lit = i
+	}
+	kb.dst = appendKeyLiteral(kb.dst, src[lit:])
+	compressed := string(kb.dst)
+	c.buffers.Put(kb)
+	return compressed
+}
+
+// appendKeyLiteral appends the literal b to dst, if any.
+func appendKeyLiteral(dst, b []byte) []byte {
+	if len(b) == 0 {
+		return dst
+	}
+	return append(appendUvarint(dst, uint64(len(b))<<1), b...)
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(dst, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// Set puts the compressed key and value into the inner cache, extra is passed along.
+func (c *CompressingCache) Set(key string, value bool, extra ...interface{}) error {
+	return c.inner.Set(c.compress(key), value, extra...)
+}
+
+// SetWithTTL puts the compressed key and value into the inner cache surviving for ttl, see persist.SetWithTTL.
+func (c *CompressingCache) SetWithTTL(key string, value bool, ttl time.Duration) error {
+	return persist.SetWithTTL(c.inner, c.compress(key), value, ttl)
+}
+
+// Get returns the result for the compressed key from the inner cache.
+func (c *CompressingCache) Get(key string) (bool, error) {
+	return c.inner.Get(c.compress(key))
+}
+
+// Delete removes the compressed key from the inner cache.
+func (c *CompressingCache) Delete(key string) error {
+	return c.inner.Delete(c.compress(key))
+}
+
+// Clear deletes all the items stored in the inner cache.
+func (c *CompressingCache) Clear() error {
+	return c.inner.Clear()
+}
+
+// IsConcurrent reports whether the cache is safe for concurrent use, which it is if the inner cache is.
+func (c *CompressingCache) IsConcurrent() bool {
+	return isConcurrent(c.inner)
+}
@@ -0,0 +1,163 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+// testAttributeKey returns a key for a request embedding a JSON attribute bundle.
+func testAttributeKey(i int) string {
+	attrs := fmt.Sprintf(`{"id":"user%d","department":"engineering","roles":["viewer","editor"],"region":"eu-west-1","clearance":"internal","tags":["team-%d","project-%d"]}`, i, i%50, i%200)
+	return fmt.Sprintf("%d:%s$$%d:data%d$$4:read$$", len(attrs), attrs, len(fmt.Sprint(i%100))+4, i%100)
+}
+
+func TestCompressingCache(t *testing.T) {
+	testCompressingCache(t, NewCompressingCache, 170)
+	testCompressingCache(t, func(inner persist.Cache) *CompressingCache {
+		return NewCompressingCacheWithDict(inner, []byte(testAttributeKey(0)))
+	}, 50)
+}
+
+func testCompressingCache(t *testing.T, newCache func(inner persist.Cache) *CompressingCache, maxLen int) {
+	t.Helper()
+	inner := NewDefaultCache()
+	c := newCache(inner)
+	_ = c.Set(testAttributeKey(1), true)
+	_ = c.Set(testAttributeKey(2), false)
+
+	testGetCache(t, c, testAttributeKey(1), true, nil)
+	testGetCache(t, c, testAttributeKey(2), false, nil)
+	testGetCache(t, c, testAttributeKey(3), false, persist.ErrNoSuchKey)
+	for _, key := range inner.Keys() {
+		if len(key) > maxLen {
+			t.Errorf("inner key %q is %d bytes long, supposed to be compressed to at most %d", key, len(key), maxLen)
+		}
+	}
+
+	if err := c.Delete(testAttributeKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	testGetCache(t, c, testAttributeKey(1), false, persist.ErrNoSuchKey)
+	_ = c.Clear()
+	testGetCache(t, c, testAttributeKey(2), false, persist.ErrNoSuchKey)
+}
+
+// testDecompressKey decodes the key compressed with dict by CompressingCache.compress.
+func testDecompressKey(t *testing.T, dict []byte, compressed string) string {
+	t.Helper()
+	b := []byte(compressed)
+	out := append([]byte(nil), dict...)
+	for len(b) > 0 {
+		v, n := binary.Uvarint(b)
+		b = b[n:]
+		if n <= 0 || v>>1 == 0 {
+			t.Fatalf("invalid token in compressed key %q", compressed)
+		}
+		if v&1 == 0 {
+			out = append(out, b[:v>>1]...)
+			b = b[v>>1:]
+			continue
+		}
+		offset, n := binary.Uvarint(b)
+		b = b[n:]
+		if n <= 0 || offset == 0 || int(offset) > len(out) {
+			t.Fatalf("invalid copy offset %d in compressed key %q", offset, compressed)
+		}
+		// The copies may overlap the bytes they produce.
+		for i := 0; i < int(v>>1); i++ {
+			out = append(out, out[len(out)-int(offset)])
+		}
+	}
+	return string(out[len(dict):])
+}
+
+func TestCompressingCacheRoundTrip(t *testing.T) {
+	keys := []string{"", "a", "aaaaaaaaaaaaaaaaaaaa", "abcabcabcabcabcd", "alice$$data1$$read$$", testAttributeKey(0), testAttributeKey(7)}
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, testAttributeKey(i))
+	}
+	for _, dict := range [][]byte{nil, []byte(testAttributeKey(0)), []byte("abc")} {
+		c := NewCompressingCacheWithDict(NewDefaultCache(), dict)
+		seen := make(map[string]string)
+		for _, key := range keys {
+			compressed := c.compress(key)
+			if got := testDecompressKey(t, dict, compressed); got != key {
+				t.Fatalf("key %q was compressed to %q, decompressed to %q", key, compressed, got)
+			}
+			if other, ok := seen[compressed]; ok && other != key {
+				t.Fatalf("keys %q and %q were both compressed to %q", key, other, compressed)
+			}
+			seen[compressed] = key
+		}
+	}
+}
+
+func benchmarkCacheMemory(b *testing.B, newCache func() persist.Cache) {
+	const n = 100000
+	var heap uint64
+	for i := 0; i < b.N; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		c := newCache()
+		// The keys are built for each request like CachedEnforcer does, so the cache retains them.
+		for j := 0; j < n; j++ {
+			_ = c.Set(testAttributeKey(j), true)
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		heap = after.HeapAlloc - before.HeapAlloc
+		runtime.KeepAlive(c)
+	}
+	b.ReportMetric(float64(heap)/n, "B/key")
+}
+
+func BenchmarkDefaultCacheMemory(b *testing.B) {
+	benchmarkCacheMemory(b, func() persist.Cache { return NewDefaultCache() })
+}
+
+func BenchmarkCompressingCacheMemory(b *testing.B) {
+	benchmarkCacheMemory(b, func() persist.Cache { return NewCompressingCache(NewDefaultCache()) })
+}
+
+func BenchmarkCompressingCacheSet(b *testing.B) {
+	c := NewCompressingCacheWithDict(NewDefaultCache(), []byte(testAttributeKey(0)))
+	key := testAttributeKey(1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = c.Set(key, true)
+	}
+}
+
+func BenchmarkCompressingCacheWithDictMemory(b *testing.B) {
+	dict := []byte(testAttributeKey(0))
+	benchmarkCacheMemory(b, func() persist.Cache { return NewCompressingCacheWithDict(NewDefaultCache(), dict) })
+}
+
+func TestCompressingCacheIsConcurrent(t *testing.T) {
+	var _ persist.ConcurrentCache = &CompressingCache{}
+	if c := NewCompressingCache(NewDefaultCache()); !c.IsConcurrent() {
+		t.Error("a CompressingCache of a concurrent cache is supposed to be concurrent")
+	}
+	if c := NewCompressingCache(newMockFarCache()); c.IsConcurrent() {
+		t.Error("a CompressingCache of a cache which is not concurrent is not supposed to be concurrent")
+	}
+}
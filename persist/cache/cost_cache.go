@@ -0,0 +1,221 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/heap"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+type costEntry struct {
+	key  string
+	item cacheItem[bool]
+	cost time.Duration
+	// seq orders the entries set with the same cost, the oldest being evicted first.
+	seq   uint64
+	index int
+}
+
+// costHeap is a min-heap of entries by cost, then by seq.
+type costHeap []*costEntry
+
+func (h costHeap) Len() int { return len(h) }
+
+func (h costHeap) Less(i, j int) bool {
+	if h[i].cost != h[j].cost {
+		return h[i].cost < h[j].cost
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h costHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *costHeap) Push(x interface{}) {
+	entry := x.(*costEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *costHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// CostCache is an in-memory implementation of persist.Cache holding at most capacity items,
+// the item which is the cheapest to recompute is evicted when a new one does not fit.
+// The cost of an item is the second parameter of extra for Set, as a time.Duration, like the evaluation time
+// CachedEnforcer passes along; an item without cost is the first to be evicted.
+// Ties are broken by evicting the least recently set of them.
+type CostCache struct {
+	capacity int
+	m        map[string]*costEntry
+	h        costHeap
+	seq      uint64
+	mutex    sync.Mutex
+	// stats and onEvict are guarded by mutex.
+	stats   persist.EvictionStats
+	onEvict func(key string)
+}
+
+// NewCostCache creates an empty CostCache, a capacity less than 1 is treated as 1.
+func NewCostCache(capacity int) *CostCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &CostCache{
+		capacity: capacity,
+		m:        make(map[string]*costEntry),
+	}
+}
+
+// Set puts key and value into cache, the first parameter of extra is the survival time in seconds
+// and the second one is the cost of the item.
+func (c *CostCache) Set(key string, value bool, extra ...interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	expiresAt, err := expireAt(time.Now(), extra...)
+	if err != nil {
+		return err
+	}
+	item := cacheItem[bool]{value: value, expiresAt: expiresAt}
+	var cost time.Duration
+	if len(extra) > 1 {
+		cost, _ = extra[1].(time.Duration)
+	}
+	c.seq++
+	if entry, ok := c.m[key]; ok {
+		entry.item, entry.cost, entry.seq = item, cost, c.seq
+		heap.Fix(&c.h, entry.index)
+		return nil
+	}
+	if len(c.m) >= c.capacity {
+		evicted := c.h[0]
+		c.remove(evicted)
+		c.stats.Evictions++
+		if c.onEvict != nil {
+			c.onEvict(evicted.key)
+		}
+	}
+	entry := &costEntry{key: key, item: item, cost: cost, seq: c.seq}
+	heap.Push(&c.h, entry)
+	c.m[key] = entry
+	return nil
+}
+
+// Get returns the value for key.
+func (c *CostCache) Get(key string) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.m[key]
+	if !ok {
+		return false, persist.ErrNoSuchKey
+	}
+	if entry.item.expired(time.Now()) {
+		c.remove(entry)
+		c.stats.Expirations++
+		return false, persist.ErrNoSuchKey
+	}
+	return entry.item.value, nil
+}
+
+// Delete removes key from cache.
+func (c *CostCache) Delete(key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.m[key]
+	if !ok {
+		return persist.ErrNoSuchKey
+	}
+	c.remove(entry)
+	return nil
+}
+
+// Clear deletes all the items stored in cache.
+func (c *CostCache) Clear() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.m = make(map[string]*costEntry)
+	c.h = nil
+	return nil
+}
+
+// DeletePrefix removes all the keys starting with prefix.
+func (c *CostCache) DeletePrefix(prefix string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, entry := range c.m {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(entry)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of items in cache, including the expired ones which are not reclaimed yet.
+func (c *CostCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.m)
+}
+
+// Keys returns a snapshot of the keys of the unexpired items in cache.
+func (c *CostCache) Keys() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	keys := make([]string, 0, len(c.m))
+	for key, entry := range c.m {
+		if !entry.item.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (c *CostCache) remove(entry *costEntry) {
+	heap.Remove(&c.h, entry.index)
+	delete(c.m, entry.key)
+}
+
+// EvictionStats returns the number of items evicted for capacity and of expired items reclaimed on read.
+func (c *CostCache) EvictionStats() persist.EvictionStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.stats
+}
+
+// SetOnEvict sets a function called with the key of every item evicted for capacity, nil removes it.
+// It is called with the lock of the cache held, so it must not call back into the cache.
+func (c *CostCache) SetOnEvict(fn func(key string)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onEvict = fn
+}
+
+// IsConcurrent reports that the cache is safe for concurrent use.
+func (c *CostCache) IsConcurrent() bool {
+	return true
+}
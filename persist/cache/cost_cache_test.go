@@ -0,0 +1,62 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+func TestCostCache(t *testing.T) {
+	c := NewCostCache(2)
+	_ = c.Set("deep", true, uint(0), 5*time.Millisecond)
+	_ = c.Set("cheap", false, uint(0), time.Microsecond)
+
+	// cheap is the cheapest to recompute, so it is evicted even though deep is older.
+	_ = c.Set("new", true, uint(0), time.Millisecond)
+	testGetCache(t, c, "cheap", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "deep", true, nil)
+	testGetCache(t, c, "new", true, nil)
+
+	// An item without cost is the first to go.
+	_ = c.Set("nocost", true)
+	testGetCache(t, c, "new", false, persist.ErrNoSuchKey)
+	_ = c.Set("other", true, uint(0), time.Second)
+	testGetCache(t, c, "nocost", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "deep", true, nil)
+	testGetCache(t, c, "other", true, nil)
+	if stats := c.EvictionStats(); stats.Evictions != 3 {
+		t.Errorf("stats: %+v, supposed to have 3 evictions", stats)
+	}
+
+	// Setting an existing key updates its cost, ties are broken by the least recently set.
+	_ = c.Set("other", true, uint(0), 5*time.Millisecond)
+	_ = c.Set("last", true, uint(0), time.Second)
+	testGetCache(t, c, "deep", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "other", true, nil)
+
+	if err := c.Delete("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete("other"); err != persist.ErrNoSuchKey {
+		t.Errorf("Delete of a missing key returned %v, supposed to be %v", err, persist.ErrNoSuchKey)
+	}
+	_ = c.Clear()
+	if c.Len() != 0 || c.h.Len() != 0 {
+		t.Error("cache should be empty after Clear")
+	}
+}
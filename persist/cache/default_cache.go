@@ -0,0 +1,663 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/heap"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+	"golang.org/x/sync/singleflight"
+)
+
+type cacheItem[T any] struct {
+	value T
+	// expiresAt is the zero time for items that never expire.
+	expiresAt time.Time
+	// storedAt is the time the item was set, it is only recorded by DefaultCache.
+	storedAt time.Time
+}
+
+func (item cacheItem[T]) expired(now time.Time) bool {
+	return !item.expiresAt.IsZero() && !now.Before(item.expiresAt)
+}
+
+// TypedDefaultCache is the default in-memory implementation of persist.TypedCache.
+// It is safe for concurrent use and does not rely on the locking of CachedEnforcer.
+type TypedDefaultCache[T any] struct {
+	m     map[string]cacheItem[T]
+	mutex sync.RWMutex
+	// expirations is guarded by mutex.
+	expirations uint64
+	// flights runs the computes of GetOrSet.
+	flights singleflight.Group
+	// limit is the maximum number of keys, 0 if there is none.
+	limit int
+	// expiries tracks when the items expire if there is a limit, so that the full cache reclaims its expired items
+	// without walking them all. It is guarded by mutex.
+	expiries expiryQueue
+	clock    Clock
+	// keepExpired is accessed atomically.
+	keepExpired int32
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// DefaultCache is the default in-memory implementation of persist.Cache.
+type DefaultCache = TypedDefaultCache[bool]
+
+// NewDefaultCache creates an empty DefaultCache configured with opts.
+func NewDefaultCache(opts ...Option) *DefaultCache {
+	return NewTypedDefaultCache[bool](opts...)
+}
+
+// NewTypedDefaultCache creates an empty TypedDefaultCache configured with opts.
+func NewTypedDefaultCache[T any](opts ...Option) *TypedDefaultCache[T] {
+	o := options{clock: realClock{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	c := &TypedDefaultCache[T]{m: make(map[string]cacheItem[T]), clock: o.clock, limit: o.limit}
+	if o.sweep > 0 {
+		c.stop = make(chan struct{})
+		go c.sweep(o.sweep)
+	}
+	return c
+}
+
+// NewDefaultCacheWithClock creates an empty DefaultCache using clock to set and check the expiry of its items,
+// on read as well as in the sweeper. It is a shorthand for NewDefaultCache with WithClock.
+func NewDefaultCacheWithClock(clock Clock) *DefaultCache {
+	return NewDefaultCache(WithClock(clock))
+}
+
+// NewTypedDefaultCacheWithClock is like NewDefaultCacheWithClock, for a TypedDefaultCache.
+func NewTypedDefaultCacheWithClock[T any](clock Clock) *TypedDefaultCache[T] {
+	return NewTypedDefaultCache[T](WithClock(clock))
+}
+
+// NewDefaultCacheWithLimit creates an empty DefaultCache holding at most max keys, 0 means there is no limit.
+// It is a shorthand for NewDefaultCache with WithLimit.
+func NewDefaultCacheWithLimit(max int) *DefaultCache {
+	return NewDefaultCache(WithLimit(max))
+}
+
+// NewDefaultCacheWithSweep creates an empty DefaultCache which deletes expired items every interval in the background.
+// It is a shorthand for NewDefaultCache with WithSweep. Call Close to stop the sweeper.
+func NewDefaultCacheWithSweep(interval time.Duration) *DefaultCache {
+	return NewDefaultCache(WithSweep(interval))
+}
+
+// NewTypedDefaultCacheWithSweep is like NewDefaultCacheWithSweep, for a TypedDefaultCache.
+func NewTypedDefaultCacheWithSweep[T any](interval time.Duration) *TypedDefaultCache[T] {
+	return NewTypedDefaultCache[T](WithSweep(interval))
+}
+
+// NewDefaultCacheFromEntries creates a DefaultCache configured with opts and holding entries, as listed by
+// persist.EntriesCache, each expiring after its remaining survival time.
+func NewDefaultCacheFromEntries(entries map[string]persist.CacheEntry, opts ...Option) *DefaultCache {
+	return NewTypedDefaultCacheFromEntries(entries, opts...)
+}
+
+// NewTypedDefaultCacheFromEntries is like NewDefaultCacheFromEntries, for a TypedDefaultCache.
+// The limit of WithLimit doesn't apply to entries.
+func NewTypedDefaultCacheFromEntries[T any](entries map[string]persist.TypedCacheEntry[T], opts ...Option) *TypedDefaultCache[T] {
+	c := NewTypedDefaultCache[T](opts...)
+	now := c.clock.Now()
+	for key, entry := range entries {
+		item := cacheItem[T]{value: entry.Value, expiresAt: expireAfter(now, entry.TTL), storedAt: now}
+		c.m[key] = item
+		c.trackExpiry(key, item.expiresAt)
+	}
+	return c
+}
+
+// Option configures a DefaultCache or a TypedDefaultCache.
+type Option func(*options)
+
+type options struct {
+	clock Clock
+	limit int
+	sweep time.Duration
+}
+
+// Clock tells the current time to a DefaultCache, so that tests can control when its items expire.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// WithClock makes the cache use clock to set and check the expiry of its items, on read as well as in the sweeper.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithLimit makes the cache hold at most max keys, 0 means there is no limit, which is the default.
+// Once it is full, Set returns persist.ErrCacheFull for new keys instead of evicting, the existing keys can
+// still be set. The expired items are reclaimed before a new key is refused.
+func WithLimit(max int) Option {
+	return func(o *options) {
+		o.limit = max
+	}
+}
+
+// WithSweep makes the cache delete the expired items every interval in the background, 0 means never, which is
+// the default. Without the sweeper, an expired item is only reclaimed when it is read again, so keys that are never
+// read again stay in memory forever. The sweeper bounds that memory at the cost of walking the whole cache under
+// the write lock every interval, which blocks Set and Get for the duration of the walk; pick a longer interval
+// for large caches. The interval is real time, the items it deletes are the ones expired according to the clock
+// of the cache. Call Close to stop the sweeper.
+func WithSweep(interval time.Duration) Option {
+	return func(o *options) {
+		o.sweep = interval
+	}
+}
+
+func (c *TypedDefaultCache[T]) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.deleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *TypedDefaultCache[T]) deleteExpired() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.deleteExpiredLocked(c.clock.Now())
+}
+
+// deleteExpiredLocked deletes the items expired at now, c.mutex must be held for writing.
+func (c *TypedDefaultCache[T]) deleteExpiredLocked(now time.Time) {
+	for key, item := range c.m {
+		if item.expired(now) {
+			delete(c.m, key)
+			c.expirations++
+		}
+	}
+}
+
+// Close stops the background sweeper, if any. It is safe to call Close more than once.
+func (c *TypedDefaultCache[T]) Close() error {
+	c.closeOnce.Do(func() {
+		if c.stop != nil {
+			close(c.stop)
+		}
+	})
+	return nil
+}
+
+// expireAt returns the expiry timestamp for the survival time in extra, or the zero time if the item never expires,
+// see persist.ParseTTL.
+func expireAt(now time.Time, extra ...interface{}) (time.Time, error) {
+	ttl, err := persist.ParseTTL(extra...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return expireAfter(now, ttl), nil
+}
+
+// expireAfter returns the expiry timestamp for ttl, or the zero time if ttl is 0 or less.
+func expireAfter(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// expiry records when the item of key expires, it is stale once the item is deleted or set to expire at another time.
+type expiry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// expiryQueue orders the expiries, the next one first.
+type expiryQueue []expiry
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].expiresAt.Before(q[j].expiresAt) }
+func (q expiryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *expiryQueue) Push(x interface{}) {
+	*q = append(*q, x.(expiry))
+}
+
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// trackExpiry records that the item of key expires at expiresAt, if the cache has a limit.
+// The stale expiries are dropped once they outnumber the items, to bound the memory of the queue.
+// c.mutex must be held for writing.
+func (c *TypedDefaultCache[T]) trackExpiry(key string, expiresAt time.Time) {
+	if c.limit <= 0 || expiresAt.IsZero() {
+		return
+	}
+	heap.Push(&c.expiries, expiry{key: key, expiresAt: expiresAt})
+	if len(c.expiries) <= 2*len(c.m)+64 {
+		return
+	}
+	c.expiries = c.expiries[:0]
+	for key, item := range c.m {
+		if !item.expiresAt.IsZero() {
+			c.expiries = append(c.expiries, expiry{key: key, expiresAt: item.expiresAt})
+		}
+	}
+	heap.Init(&c.expiries)
+}
+
+// reclaimExpired deletes the items expired at now, in the order they expire, c.mutex must be held for writing.
+// It only pops the expiries due, so it doesn't walk the whole cache.
+func (c *TypedDefaultCache[T]) reclaimExpired(now time.Time) {
+	for len(c.expiries) > 0 && !now.Before(c.expiries[0].expiresAt) {
+		e := heap.Pop(&c.expiries).(expiry)
+		if item, ok := c.m[e.key]; ok && item.expiresAt.Equal(e.expiresAt) {
+			delete(c.m, e.key)
+			c.expirations++
+		}
+	}
+}
+
+// fits reports whether key can be set without exceeding the limit, reclaiming the expired items if needed.
+// c.mutex must be held for writing.
+func (c *TypedDefaultCache[T]) fits(key string, now time.Time) bool {
+	if c.limit <= 0 || len(c.m) < c.limit {
+		return true
+	}
+	if _, ok := c.m[key]; ok {
+		return true
+	}
+	c.reclaimExpired(now)
+	return len(c.m) < c.limit
+}
+
+// Set puts key and value into cache, the first parameter of extra is the survival time in seconds.
+// It returns persist.ErrCacheFull if key is new and the cache is at its limit.
+func (c *TypedDefaultCache[T]) Set(key string, value T, extra ...interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.clock.Now()
+	expiresAt, err := expireAt(now, extra...)
+	if err != nil {
+		return err
+	}
+	return c.setItem(key, cacheItem[T]{value: value, expiresAt: expiresAt}, now)
+}
+
+// SetWithTTL puts key and value into cache, surviving for ttl, or always if ttl is 0 or less.
+// It returns persist.ErrCacheFull like Set.
+func (c *TypedDefaultCache[T]) SetWithTTL(key string, value T, ttl time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.clock.Now()
+	return c.setItem(key, cacheItem[T]{value: value, expiresAt: expireAfter(now, ttl)}, now)
+}
+
+// Touch resets the survival time of key, the first parameter of extra is the survival time in seconds.
+// Its age is left unchanged.
+func (c *TypedDefaultCache[T]) Touch(key string, extra ...interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.clock.Now()
+	item, ok := c.m[key]
+	if !ok || item.expired(now) {
+		return persist.ErrNoSuchKey
+	}
+	expiresAt, err := expireAt(now, extra...)
+	if err != nil {
+		return err
+	}
+	item.expiresAt = expiresAt
+	c.m[key] = item
+	c.trackExpiry(key, expiresAt)
+	return nil
+}
+
+// setItem puts item for key under the limit, c.mutex must be held for writing.
+func (c *TypedDefaultCache[T]) setItem(key string, item cacheItem[T], now time.Time) error {
+	if !c.fits(key, now) {
+		return persist.ErrCacheFull
+	}
+	item.storedAt = now
+	c.m[key] = item
+	c.trackExpiry(key, item.expiresAt)
+	return nil
+}
+
+// ExpireOnRead controls whether Get deletes the expired item it finds, which is the default. Deleting it reclaims
+// its memory right away, but takes the write lock during a read; with false, Get only takes the read lock and the
+// expired items are left for the sweeper to reclaim, see WithSweep.
+func (c *TypedDefaultCache[T]) ExpireOnRead(enable bool) {
+	if enable {
+		atomic.StoreInt32(&c.keepExpired, 0)
+	} else {
+		atomic.StoreInt32(&c.keepExpired, 1)
+	}
+}
+
+// Get returns the value for key, expired items are reported as ErrNoSuchKey and removed, see ExpireOnRead.
+func (c *TypedDefaultCache[T]) Get(key string) (T, error) {
+	c.mutex.RLock()
+	item, ok := c.m[key]
+	c.mutex.RUnlock()
+	var zero T
+	if !ok {
+		return zero, persist.ErrNoSuchKey
+	}
+	if item.expired(c.clock.Now()) {
+		if atomic.LoadInt32(&c.keepExpired) == 0 {
+			c.deleteIfExpired(key)
+		}
+		return zero, persist.ErrNoSuchKey
+	}
+	return item.value, nil
+}
+
+// Has reports whether key exists in cache and has not expired, an expired item is left for Get or the sweeper to reclaim.
+func (c *TypedDefaultCache[T]) Has(key string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	item, ok := c.m[key]
+	return ok && !item.expired(c.clock.Now())
+}
+
+// GetStale returns the value for key even if it has expired, as long as it is not reclaimed yet.
+func (c *TypedDefaultCache[T]) GetStale(key string) (T, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	item, ok := c.m[key]
+	if !ok {
+		var zero T
+		return zero, persist.ErrNoSuchKey
+	}
+	return item.value, nil
+}
+
+// TTL returns the remaining survival time of key, or 0 if it never expires.
+func (c *TypedDefaultCache[T]) TTL(key string) (time.Duration, error) {
+	c.mutex.RLock()
+	item, ok := c.m[key]
+	c.mutex.RUnlock()
+	now := c.clock.Now()
+	if !ok || item.expired(now) {
+		return 0, persist.ErrNoSuchKey
+	}
+	if item.expiresAt.IsZero() {
+		return 0, nil
+	}
+	return item.expiresAt.Sub(now), nil
+}
+
+// Age returns how long ago key was set, the items of NewDefaultCacheFromEntries count from the creation of the cache.
+func (c *TypedDefaultCache[T]) Age(key string) (time.Duration, error) {
+	c.mutex.RLock()
+	item, ok := c.m[key]
+	c.mutex.RUnlock()
+	now := c.clock.Now()
+	if !ok || item.expired(now) {
+		return 0, persist.ErrNoSuchKey
+	}
+	return now.Sub(item.storedAt), nil
+}
+
+// GetOrSet returns the value for key, or else calls compute and puts its value into cache, extra is like for Set.
+// Concurrent callers missing the same key share a single call of compute and its result.
+func (c *TypedDefaultCache[T]) GetOrSet(key string, compute func() (T, error), extra ...interface{}) (T, error) {
+	if value, err := c.Get(key); err == nil {
+		return value, nil
+	}
+	value, err, _ := c.flights.Do(key, func() (interface{}, error) {
+		// The value may have been put by a flight finishing in between.
+		if value, err := c.Get(key); err == nil {
+			return value, nil
+		}
+		value, err := compute()
+		if err != nil {
+			return value, err
+		}
+		return value, c.Set(key, value, extra...)
+	})
+	if errors.Is(err, persist.ErrCacheFull) {
+		return value.(T), err
+	} else if err != nil {
+		var zero T
+		return zero, err
+	}
+	return value.(T), nil
+}
+
+// deleteIfExpired takes the write lock and deletes key, unless it has been set again since it was read.
+func (c *TypedDefaultCache[T]) deleteIfExpired(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if item, ok := c.m[key]; ok && item.expired(c.clock.Now()) {
+		delete(c.m, key)
+		c.expirations++
+	}
+}
+
+// Delete removes key from cache.
+func (c *TypedDefaultCache[T]) Delete(key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.m[key]; !ok {
+		return persist.ErrNoSuchKey
+	}
+	delete(c.m, key)
+	return nil
+}
+
+// Clear deletes all the items stored in cache. The items are deleted in place, so the memory of the map is kept
+// for the next fill instead of being garbage collected and grown again; a cache which was once much larger than
+// it will be again holds on to that memory.
+func (c *TypedDefaultCache[T]) Clear() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key := range c.m {
+		delete(c.m, key)
+	}
+	c.expiries = c.expiries[:0]
+	return nil
+}
+
+// SetMany puts all the entries into cache under a single lock, extra is like for Set.
+// If some new keys don't fit under the limit, the others are still put and persist.ErrCacheFull is returned.
+func (c *TypedDefaultCache[T]) SetMany(entries map[string]T, extra ...interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.clock.Now()
+	expiresAt, err := expireAt(now, extra...)
+	if err != nil {
+		return err
+	}
+	for key, value := range entries {
+		if !c.fits(key, now) {
+			err = persist.ErrCacheFull
+			continue
+		}
+		c.m[key] = cacheItem[T]{value: value, expiresAt: expiresAt, storedAt: now}
+		c.trackExpiry(key, expiresAt)
+	}
+	return err
+}
+
+// GetMany returns the values for the unexpired keys in cache under a single lock, the other keys are left out.
+// Unlike Get, the expired items are left for the sweeper or a later Get to reclaim.
+func (c *TypedDefaultCache[T]) GetMany(keys []string) (map[string]T, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	now := c.clock.Now()
+	values := make(map[string]T, len(keys))
+	for _, key := range keys {
+		if item, ok := c.m[key]; ok && !item.expired(now) {
+			values[key] = item.value
+		}
+	}
+	return values, nil
+}
+
+// DeleteMany removes the keys from cache under a single lock, it is not an error if some of them don't exist.
+func (c *TypedDefaultCache[T]) DeleteMany(keys []string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, key := range keys {
+		delete(c.m, key)
+	}
+	return nil
+}
+
+// DeletePrefix removes all the keys starting with prefix.
+func (c *TypedDefaultCache[T]) DeletePrefix(prefix string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key := range c.m {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.m, key)
+		}
+	}
+	return nil
+}
+
+// DeleteBySegment removes all the keys whose segment at index equals value, the keys being made of the segments
+// "<length>:<value><separator>" with persist.DefaultKeySeparator.
+func (c *TypedDefaultCache[T]) DeleteBySegment(index int, value string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key := range c.m {
+		if segment, ok := persist.KeySegment(key, index, persist.DefaultKeySeparator); ok && segment == value {
+			delete(c.m, key)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of items in cache, including the expired ones which are not reclaimed yet.
+func (c *TypedDefaultCache[T]) Len() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.m)
+}
+
+// Keys returns a snapshot of the keys of the unexpired items in cache, which may be large.
+func (c *TypedDefaultCache[T]) Keys() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	now := c.clock.Now()
+	keys := make([]string, 0, len(c.m))
+	for key, item := range c.m {
+		if !item.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Range calls fn for each unexpired item in cache, in no particular order, until fn returns false.
+// fn is called with the read lock of the cache held, so it must not set or delete any item.
+func (c *TypedDefaultCache[T]) Range(fn func(key string, value T) bool) error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	now := c.clock.Now()
+	for key, item := range c.m {
+		if item.expired(now) {
+			continue
+		}
+		if !fn(key, item.value) {
+			break
+		}
+	}
+	return nil
+}
+
+// Entries returns a snapshot of the unexpired items in cache, which may be large.
+func (c *TypedDefaultCache[T]) Entries() (map[string]persist.TypedCacheEntry[T], error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	now := c.clock.Now()
+	entries := make(map[string]persist.TypedCacheEntry[T], len(c.m))
+	for key, item := range c.m {
+		if item.expired(now) {
+			continue
+		}
+		entry := persist.TypedCacheEntry[T]{Value: item.value}
+		if !item.expiresAt.IsZero() {
+			entry.TTL = item.expiresAt.Sub(now)
+		}
+		entries[key] = entry
+	}
+	return entries, nil
+}
+
+// ToMap returns a snapshot of the values of the unexpired items in cache, to inspect it in tests or tools for instance.
+// The survival times are left out, see Entries for them.
+func (c *TypedDefaultCache[T]) ToMap() map[string]T {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	now := c.clock.Now()
+	values := make(map[string]T, len(c.m))
+	for key, item := range c.m {
+		if !item.expired(now) {
+			values[key] = item.value
+		}
+	}
+	return values
+}
+
+// FromMap replaces all the items in cache with the values of m, which never expire, to seed the cache in tests or
+// tools for instance. The limit of WithLimit doesn't apply, see NewDefaultCacheFromEntries for items expiring
+// after their survival time.
+func (c *TypedDefaultCache[T]) FromMap(m map[string]T) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.clock.Now()
+	for key := range c.m {
+		delete(c.m, key)
+	}
+	for key, value := range m {
+		c.m[key] = cacheItem[T]{value: value, storedAt: now}
+	}
+}
+
+// EvictionStats returns the number of expired items reclaimed on read or by the sweeper, nothing is ever evicted.
+func (c *TypedDefaultCache[T]) EvictionStats() persist.EvictionStats {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return persist.EvictionStats{Expirations: c.expirations}
+}
+
+// IsConcurrent reports that the cache is safe for concurrent use.
+func (c *TypedDefaultCache[T]) IsConcurrent() bool {
+	return true
+}
@@ -0,0 +1,619 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+func testGetCache(t *testing.T, c persist.Cache, key string, res bool, err error) {
+	t.Helper()
+	myRes, myErr := c.Get(key)
+	if myErr != err {
+		t.Errorf("%s: error %v, supposed to be %v", key, myErr, err)
+	}
+	if myRes != res {
+		t.Errorf("%s: %t, supposed to be %t", key, myRes, res)
+	}
+}
+
+func TestDefaultCache(t *testing.T) {
+	c := NewDefaultCache()
+	_ = c.Set("alice$$data1$$read$$", true)
+	_ = c.Set("bob$$data2$$write$$", false)
+
+	testGetCache(t, c, "alice$$data1$$read$$", true, nil)
+	testGetCache(t, c, "bob$$data2$$write$$", false, nil)
+	testGetCache(t, c, "alice$$data2$$read$$", false, persist.ErrNoSuchKey)
+
+	if err := c.Delete("alice$$data1$$read$$"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete("alice$$data1$$read$$"); err != persist.ErrNoSuchKey {
+		t.Errorf("Delete of a missing key returned %v, supposed to be %v", err, persist.ErrNoSuchKey)
+	}
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+
+	_ = c.Clear()
+	testGetCache(t, c, "bob$$data2$$write$$", false, persist.ErrNoSuchKey)
+}
+
+func TestDefaultCacheExpireTime(t *testing.T) {
+	c := NewDefaultCache()
+	_ = c.Set("alice$$data1$$read$$", true, uint(1))
+	_ = c.Set("bob$$data2$$write$$", true, uint(0))
+	_ = c.Set("carol$$data3$$read$$", true)
+
+	testGetCache(t, c, "alice$$data1$$read$$", true, nil)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+	if _, ok := c.m["alice$$data1$$read$$"]; ok {
+		t.Error("expired item should be removed when it is read")
+	}
+	if stats := c.EvictionStats(); stats != (persist.EvictionStats{Expirations: 1}) {
+		t.Errorf("stats: %+v, supposed to have 1 expiration", stats)
+	}
+	// A survival time of 0, or none at all, never expires.
+	testGetCache(t, c, "bob$$data2$$write$$", true, nil)
+	testGetCache(t, c, "carol$$data3$$read$$", true, nil)
+}
+
+func TestDefaultCacheWithSweep(t *testing.T) {
+	c := NewDefaultCache(WithSweep(100 * time.Millisecond))
+	_ = c.Set("alice$$data1$$read$$", true, uint(1))
+	_ = c.Set("bob$$data2$$write$$", true)
+
+	time.Sleep(1300 * time.Millisecond)
+
+	// The sweeper reclaims the expired item without it being read.
+	c.mutex.Lock()
+	_, expiredOk := c.m["alice$$data1$$read$$"]
+	_, survivalOk := c.m["bob$$data2$$write$$"]
+	c.mutex.Unlock()
+	if expiredOk {
+		t.Error("expired item should be removed by the sweeper")
+	}
+	if !survivalOk {
+		t.Error("item without survival time should not be removed by the sweeper")
+	}
+	if stats := c.EvictionStats(); stats != (persist.EvictionStats{Expirations: 1}) {
+		t.Errorf("stats: %+v, supposed to have 1 expiration", stats)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Close is idempotent.
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDefaultCache().Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefaultCacheConcurrency(t *testing.T) {
+	c := NewDefaultCache()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := fmt.Sprintf("user%d$$data%d$$read$$", i, j%10)
+				_ = c.Set(key, j%2 == 0, uint(j%2))
+				_, _ = c.Get(key)
+				if j%100 == 0 {
+					_ = c.Delete(key)
+				}
+				if j%500 == 0 {
+					_ = c.Clear()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestDefaultCacheLen(t *testing.T) {
+	c := NewDefaultCache()
+	var _ persist.LenCache = c
+	var _ persist.KeysCache = c
+
+	_ = c.Set("alice$$data1$$read$$", true)
+	_ = c.Set("bob$$data2$$write$$", false)
+	_ = c.Set("bob$$data2$$write$$", true)
+	if n := c.Len(); n != 2 {
+		t.Errorf("Len: %d, supposed to be 2", n)
+	}
+	keys := c.Keys()
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"alice$$data1$$read$$", "bob$$data2$$write$$"}) {
+		t.Errorf("Keys: %v", keys)
+	}
+
+	_ = c.Delete("alice$$data1$$read$$")
+	if n := c.Len(); n != 1 {
+		t.Errorf("Len: %d, supposed to be 1", n)
+	}
+	_ = c.Clear()
+	if n := c.Len(); n != 0 {
+		t.Errorf("Len: %d, supposed to be 0", n)
+	}
+	if keys := c.Keys(); len(keys) != 0 {
+		t.Errorf("Keys: %v, supposed to be empty", keys)
+	}
+}
+
+func TestDefaultCacheDeletePrefix(t *testing.T) {
+	c := NewDefaultCache()
+	var _ persist.PrefixCache = c
+	_ = c.Set("alice$$data1$$read$$", true)
+	_ = c.Set("alice$$data2$$read$$", true)
+	_ = c.Set("bob$$data2$$write$$", true)
+
+	if err := c.DeletePrefix("alice$$"); err != nil {
+		t.Fatal(err)
+	}
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "alice$$data2$$read$$", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "bob$$data2$$write$$", true, nil)
+}
+
+func TestDefaultCacheDeleteBySegment(t *testing.T) {
+	c := NewDefaultCache()
+	var _ persist.SegmentCache = c
+	_ = c.Set("5:alice$$5:data1$$4:read$$", true)
+	_ = c.Set("3:bob$$5:data1$$4:read$$#1f", true)
+	_ = c.Set("5:data1$$5:data2$$4:read$$", true)
+	_ = c.Set("data1", true)
+
+	if err := c.DeleteBySegment(1, "data1"); err != nil {
+		t.Fatal(err)
+	}
+	testGetCache(t, c, "5:alice$$5:data1$$4:read$$", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "3:bob$$5:data1$$4:read$$#1f", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "5:data1$$5:data2$$4:read$$", true, nil)
+	testGetCache(t, c, "data1", true, nil)
+}
+
+func TestDefaultCacheRange(t *testing.T) {
+	c := NewDefaultCache()
+	var _ persist.RangeCache = c
+	_ = c.Set("alice$$data1$$read$$", true)
+	_ = c.Set("bob$$data2$$write$$", false)
+	_ = c.Set("carol$$data3$$read$$", true, uint(1))
+	c.m["carol$$data3$$read$$"] = cacheItem[bool]{value: true, expiresAt: time.Now().Add(-time.Second)}
+
+	seen := map[string]bool{}
+	_ = c.Range(func(key string, value bool) bool {
+		seen[key] = value
+		return true
+	})
+	if !reflect.DeepEqual(seen, map[string]bool{"alice$$data1$$read$$": true, "bob$$data2$$write$$": false}) {
+		t.Errorf("Range: %v, supposed to be the unexpired items", seen)
+	}
+
+	calls := 0
+	_ = c.Range(func(key string, value bool) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Errorf("Range called fn %d times, supposed to stop after the first", calls)
+	}
+}
+
+func TestDefaultCacheToMap(t *testing.T) {
+	c := NewDefaultCache()
+	_ = c.Set("alice$$data1$$read$$", true)
+	_ = c.Set("bob$$data2$$write$$", false, uint(3600))
+	c.m["carol$$data3$$read$$"] = cacheItem[bool]{value: true, expiresAt: time.Now().Add(-time.Second)}
+
+	m := c.ToMap()
+	want := map[string]bool{"alice$$data1$$read$$": true, "bob$$data2$$write$$": false}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("ToMap: %v, supposed to be the unexpired items %v", m, want)
+	}
+
+	copied := NewDefaultCache()
+	_ = copied.Set("dave$$data4$$read$$", true)
+	copied.FromMap(m)
+	if got := copied.ToMap(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ToMap after FromMap: %v, supposed to be %v", got, want)
+	}
+	if ttl, err := copied.TTL("bob$$data2$$write$$"); err != nil || ttl != 0 {
+		t.Errorf("TTL: %v, %v, supposed to never expire", ttl, err)
+	}
+	// The map is copied.
+	m["alice$$data1$$read$$"] = false
+	if res, _ := copied.Get("alice$$data1$$read$$"); !res {
+		t.Error("FromMap is not supposed to keep using the map")
+	}
+}
+
+func TestDefaultCacheTTLTypes(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := NewDefaultCache(WithClock(clock))
+	for _, tc := range []struct {
+		extra []interface{}
+		ttl   time.Duration
+	}{
+		{[]interface{}{uint(60)}, time.Minute},
+		{[]interface{}{60}, time.Minute},
+		{[]interface{}{90 * time.Second}, 90 * time.Second},
+		{[]interface{}{-1}, 0},
+		{[]interface{}{nil}, 0},
+		{nil, 0},
+	} {
+		if err := c.Set("alice$$data1$$read$$", true, tc.extra...); err != nil {
+			t.Errorf("Set with %#v: %v", tc.extra, err)
+			continue
+		}
+		if ttl, _ := c.TTL("alice$$data1$$read$$"); ttl != tc.ttl {
+			t.Errorf("TTL with %#v: %v, supposed to be %v", tc.extra, ttl, tc.ttl)
+		}
+	}
+}
+
+func TestCacheInvalidTTL(t *testing.T) {
+	for _, c := range []persist.Cache{NewDefaultCache(), NewLRUCache(10), NewLFUCache(10), NewCostCache(10), NewSizeCappedCache(1 << 10)} {
+		for _, extra := range []interface{}{"60", 60.0, int64(60)} {
+			if err := c.Set("alice$$data1$$read$$", true, extra); !errors.Is(err, persist.ErrInvalidTTL) {
+				t.Errorf("%T: Set with %#v returned %v, supposed to be %v", c, extra, err, persist.ErrInvalidTTL)
+			}
+			// The item is not set with a survival time it can't honor.
+			testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+		}
+	}
+	if err := NewDefaultCache().SetMany(map[string]bool{"alice$$data1$$read$$": true}, "60"); !errors.Is(err, persist.ErrInvalidTTL) {
+		t.Errorf("SetMany returned %v, supposed to be %v", err, persist.ErrInvalidTTL)
+	}
+}
+
+func TestTypedDefaultCache(t *testing.T) {
+	c := NewTypedDefaultCache[[]string]()
+	var _ persist.TypedCache[[]string] = c
+	var _ persist.Cache = NewDefaultCache()
+
+	_ = c.Set("alice$$data1$$read$$", []string{"alice", "data1", "read"})
+	if res, err := c.Get("alice$$data1$$read$$"); err != nil || !reflect.DeepEqual(res, []string{"alice", "data1", "read"}) {
+		t.Errorf("Get: %v, %v", res, err)
+	}
+	if res, err := c.Get("bob$$data2$$write$$"); err != persist.ErrNoSuchKey || res != nil {
+		t.Errorf("Get: %v, %v, supposed to be the zero value and %v", res, err, persist.ErrNoSuchKey)
+	}
+}
+
+func TestBatchCache(t *testing.T) {
+	var _ persist.BatchCache = NewDefaultCache()
+	// DefaultCache is handled natively, while LRUCache goes through the single key fallback.
+	for _, c := range []persist.Cache{NewDefaultCache(), NewLRUCache(10)} {
+		t.Run(fmt.Sprintf("%T", c), func(t *testing.T) {
+			entries := map[string]bool{"alice$$data1$$read$$": true, "bob$$data2$$write$$": false, "carol$$data3$$read$$": true}
+			if err := persist.SetMany(c, entries, uint(10)); err != nil {
+				t.Fatal(err)
+			}
+			testGetCache(t, c, "bob$$data2$$write$$", false, nil)
+
+			res, err := persist.GetMany(c, []string{"alice$$data1$$read$$", "bob$$data2$$write$$", "dave$$data4$$read$$"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := map[string]bool{"alice$$data1$$read$$": true, "bob$$data2$$write$$": false}; !reflect.DeepEqual(res, want) {
+				t.Errorf("GetMany: %v, supposed to be %v", res, want)
+			}
+
+			if err := persist.DeleteMany(c, []string{"alice$$data1$$read$$", "dave$$data4$$read$$"}); err != nil {
+				t.Fatal(err)
+			}
+			testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+			testGetCache(t, c, "carol$$data3$$read$$", true, nil)
+		})
+	}
+}
+
+func TestDefaultCacheGetOrSet(t *testing.T) {
+	c := NewDefaultCache()
+	var _ persist.GetOrSetCache = c
+
+	var computes int32
+	release := make(chan struct{})
+	compute := func() (bool, error) {
+		atomic.AddInt32(&computes, 1)
+		<-release
+		return true, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res, err := c.GetOrSet("alice$$data1$$read$$", compute, uint(10)); err != nil || !res {
+				t.Errorf("GetOrSet: %t, %v", res, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if computes != 1 {
+		t.Errorf("compute called %d times, supposed to be once", computes)
+	}
+	testGetCache(t, c, "alice$$data1$$read$$", true, nil)
+	if ttl, _ := c.TTL("alice$$data1$$read$$"); ttl <= 0 || ttl > 10*time.Second {
+		t.Errorf("TTL is %v, supposed to be at most 10s", ttl)
+	}
+
+	// A failed compute is not cached.
+	errCompute := fmt.Errorf("evaluation failed")
+	if _, err := c.GetOrSet("bob$$data2$$write$$", func() (bool, error) { return false, errCompute }); err != errCompute {
+		t.Errorf("GetOrSet returned %v, supposed to be %v", err, errCompute)
+	}
+	testGetCache(t, c, "bob$$data2$$write$$", false, persist.ErrNoSuchKey)
+}
+
+func TestDefaultCacheWithLimit(t *testing.T) {
+	c := NewDefaultCache(WithLimit(2))
+	_ = c.Set("alice$$data1$$read$$", true)
+	_ = c.Set("bob$$data2$$write$$", true, uint(1))
+
+	if err := c.Set("carol$$data3$$read$$", true); err != persist.ErrCacheFull {
+		t.Errorf("Set of a new key at the limit returned %v, supposed to be %v", err, persist.ErrCacheFull)
+	}
+	testGetCache(t, c, "carol$$data3$$read$$", false, persist.ErrNoSuchKey)
+	if err := c.Set("alice$$data1$$read$$", false); err != nil {
+		t.Errorf("Set of an existing key at the limit returned %v", err)
+	}
+	testGetCache(t, c, "alice$$data1$$read$$", false, nil)
+
+	if err := c.SetMany(map[string]bool{"alice$$data1$$read$$": true, "carol$$data3$$read$$": true}); err != persist.ErrCacheFull {
+		t.Errorf("SetMany of a new key at the limit returned %v, supposed to be %v", err, persist.ErrCacheFull)
+	}
+	testGetCache(t, c, "alice$$data1$$read$$", true, nil)
+	testGetCache(t, c, "carol$$data3$$read$$", false, persist.ErrNoSuchKey)
+
+	res, err := c.GetOrSet("carol$$data3$$read$$", func() (bool, error) { return true, nil })
+	if err != persist.ErrCacheFull || !res {
+		t.Errorf("GetOrSet at the limit returned %t, %v, supposed to be true, %v", res, err, persist.ErrCacheFull)
+	}
+
+	// The expired items are reclaimed to make room.
+	time.Sleep(time.Second)
+	if err := c.Set("carol$$data3$$read$$", true); err != nil {
+		t.Errorf("Set after an item expired returned %v", err)
+	}
+	testGetCache(t, c, "carol$$data3$$read$$", true, nil)
+}
+
+func TestDefaultCacheWithLimitReclaimsInOrder(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := NewDefaultCache(WithClock(clock), WithLimit(2))
+	_ = c.Set("alice$$data1$$read$$", true, uint(10))
+	_ = c.Set("bob$$data2$$write$$", true, uint(60))
+	// alice is set again before it expires, its first expiry is stale.
+	_ = c.Set("alice$$data1$$read$$", true, uint(120))
+
+	clock.Advance(time.Minute)
+	if err := c.Set("carol$$data3$$read$$", true); err != nil {
+		t.Errorf("Set after an item expired returned %v", err)
+	}
+	testGetCache(t, c, "alice$$data1$$read$$", true, nil)
+	testGetCache(t, c, "bob$$data2$$write$$", false, persist.ErrNoSuchKey)
+	if stats := c.EvictionStats(); stats.Expirations != 1 {
+		t.Errorf("stats: %+v, supposed to have 1 expiration", stats)
+	}
+
+	// The stale expiries of the keys set again don't pile up.
+	for i := 0; i < 1000; i++ {
+		_ = c.Set("alice$$data1$$read$$", true, uint(120+i))
+	}
+	if n := len(c.expiries); n > 2*c.Len()+64 {
+		t.Errorf("%d expiries are tracked for %d items", n, c.Len())
+	}
+}
+
+// fakeClock is a Clock which only moves forward when it is advanced.
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestDefaultCacheSetWithTTL(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := NewDefaultCache(WithClock(clock))
+	_ = c.SetWithTTL("alice$$data1$$read$$", true, 1500*time.Millisecond)
+	_ = c.SetWithTTL("bob$$data2$$write$$", true, 0)
+
+	clock.Advance(1499 * time.Millisecond)
+	testGetCache(t, c, "alice$$data1$$read$$", true, nil)
+	if ttl, err := c.TTL("alice$$data1$$read$$"); err != nil || ttl != time.Millisecond {
+		t.Errorf("TTL: %v, %v, supposed to be 1ms", ttl, err)
+	}
+
+	clock.Advance(time.Millisecond)
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "bob$$data2$$write$$", true, nil)
+}
+
+func TestSetWithTTLFallback(t *testing.T) {
+	// A cache only implementing Set is given the survival time rounded up to whole seconds.
+	inner := NewDefaultCache()
+	c := NewShardedCache(1, func() persist.Cache { return struct{ persist.Cache }{inner} })
+	_ = c.SetWithTTL("alice$$data1$$read$$", true, 1500*time.Millisecond)
+	ttl, err := inner.TTL("alice$$data1$$read$$")
+	if err != nil || ttl <= 1500*time.Millisecond || ttl > 2*time.Second {
+		t.Errorf("TTL: %v, %v, supposed to be rounded up to 2s", ttl, err)
+	}
+}
+
+func TestDefaultCacheWithClock(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := NewDefaultCache(WithClock(clock), WithSweep(time.Millisecond))
+	defer c.Close()
+	_ = c.Set("alice$$data1$$read$$", true, uint(60))
+	_ = c.Set("bob$$data2$$write$$", false, uint(120))
+
+	clock.Advance(time.Minute)
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "bob$$data2$$write$$", false, nil)
+
+	// The sweeper reclaims the expired items by the clock too.
+	clock.Advance(time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for c.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("cache holds %d items after they expired, supposed to be swept", c.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if stats := c.EvictionStats(); stats.Expirations != 2 {
+		t.Errorf("stats: %+v, supposed to have 2 expirations", stats)
+	}
+}
+
+func TestDefaultCacheConstructors(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := NewDefaultCacheWithClock(clock)
+	_ = c.Set("alice$$data1$$read$$", true, uint(60))
+	clock.Advance(time.Minute)
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+
+	c = NewDefaultCacheWithLimit(1)
+	_ = c.Set("alice$$data1$$read$$", true)
+	if err := c.Set("bob$$data2$$write$$", true); err != persist.ErrCacheFull {
+		t.Errorf("Set of a new key at the limit returned %v, supposed to be %v", err, persist.ErrCacheFull)
+	}
+
+	c = NewDefaultCacheWithSweep(time.Millisecond)
+	defer c.Close()
+	if c.stop == nil {
+		t.Error("NewDefaultCacheWithSweep didn't start the sweeper")
+	}
+}
+
+func TestDefaultCacheFromEntries(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := NewDefaultCacheFromEntries(map[string]persist.CacheEntry{
+		"alice$$data1$$read$$": {Value: true, TTL: time.Minute},
+		"bob$$data2$$write$$":  {Value: false},
+	}, WithClock(clock))
+	testGetCache(t, c, "alice$$data1$$read$$", true, nil)
+	clock.Advance(time.Minute)
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "bob$$data2$$write$$", false, nil)
+
+	typed := NewTypedDefaultCacheFromEntries(map[string]persist.TypedCacheEntry[string]{
+		"alice": {Value: "admin"},
+	})
+	if value, err := typed.Get("alice"); value != "admin" || err != nil {
+		t.Errorf("Get: %q, %v, supposed to be admin", value, err)
+	}
+}
+
+func TestDefaultCacheTouch(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := NewDefaultCache(WithClock(clock))
+	var _ persist.TouchCache = c
+	_ = c.Set("alice$$data1$$read$$", true, uint(60))
+
+	clock.Advance(50 * time.Second)
+	if err := c.Touch("alice$$data1$$read$$", uint(60)); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(50 * time.Second)
+	testGetCache(t, c, "alice$$data1$$read$$", true, nil)
+	if age, _ := c.Age("alice$$data1$$read$$"); age != 100*time.Second {
+		t.Errorf("age: %v, supposed to be unchanged by Touch", age)
+	}
+
+	clock.Advance(time.Minute)
+	if err := c.Touch("alice$$data1$$read$$", uint(60)); err != persist.ErrNoSuchKey {
+		t.Errorf("Touch of an expired key returned %v, supposed to be %v", err, persist.ErrNoSuchKey)
+	}
+}
+
+func BenchmarkDefaultCacheFillClear(b *testing.B) {
+	keys := make([]string, 100000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user%d$$data1$$read$$", i)
+	}
+	c := NewDefaultCache()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, key := range keys {
+			_ = c.Set(key, true)
+		}
+		_ = c.Clear()
+	}
+}
+
+// BenchmarkDefaultCacheFullMisses sets new keys into a full cache of which no item has expired, refusing them all.
+func BenchmarkDefaultCacheFullMisses(b *testing.B) {
+	c := NewDefaultCache(WithLimit(100000))
+	for i := 0; i < 100000; i++ {
+		_ = c.Set(fmt.Sprintf("user%d$$data1$$read$$", i), true, uint(3600))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = c.Set(fmt.Sprintf("new%d$$data1$$read$$", i), true, uint(3600))
+	}
+}
+
+func TestDefaultCacheExpireOnRead(t *testing.T) {
+	for _, expireOnRead := range []bool{true, false} {
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		c := NewDefaultCache(WithClock(clock))
+		c.ExpireOnRead(expireOnRead)
+		_ = c.Set("alice$$data1$$read$$", true, uint(1))
+
+		clock.Advance(time.Second)
+		testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+		want := 0
+		if !expireOnRead {
+			// The expired item is left for the sweeper.
+			want = 1
+		}
+		if n := c.Len(); n != want {
+			t.Errorf("ExpireOnRead(%t): cache holds %d items after an expired read, supposed to be %d", expireOnRead, n, want)
+		}
+	}
+}
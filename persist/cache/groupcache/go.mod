@@ -0,0 +1,17 @@
+module github.com/casbin/casbin/v2/persist/cache/groupcache
+
+go 1.18
+
+require (
+	github.com/casbin/casbin/v2 v2.0.0
+	github.com/golang/groupcache v0.0.0-20241129210726-2c02b8208cf8
+)
+
+require (
+	github.com/Knetic/govaluate v3.0.1-0.20171022003610-9aa49832a739+incompatible // indirect
+	github.com/golang/protobuf v1.5.4 // indirect
+	golang.org/x/sync v0.1.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
+
+replace github.com/casbin/casbin/v2 => ../../../
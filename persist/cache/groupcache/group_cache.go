@@ -0,0 +1,152 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package groupcache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	gc "github.com/golang/groupcache"
+)
+
+// Loader evaluates the decision of key on a miss of the whole fleet, like CachedEnforcer.EvaluateKey.
+// It returns persist.ErrNoSuchKey for a key it can't evaluate, so that the enforcer evaluates the request itself.
+type Loader func(key string) (bool, error)
+
+// GroupCache is an implementation of persist.Cache backed by a groupcache group, so that the nodes of a fleet each
+// own a slice of the keys and serve them to their peers, without a central cache server. On a miss, Get asks the
+// peer owning the key, which evaluates the decision with its loader once for the whole fleet.
+// The peers are the ones registered with groupcache, like by groupcache.NewHTTPPool.
+//
+// The decisions of groupcache can be neither set nor deleted: Set is ignored, the decisions don't expire and are
+// only evicted to stay within the size of the group. Delete and Clear drop all the decisions by moving to a new
+// generation of the keys, so the nodes must agree on the generation to share the decisions: invalidate all of them
+// together, with the distributed invalidation of CachedEnforcer for instance, and set the generation of a node
+// joining the fleet with SetGeneration.
+//
+// The decisions evaluated by the loader of the node for its own Get are reported by GetLoad, so that CachedEnforcer
+// counts them as misses. The decisions a peer owning the key evaluated for the node can't be told from the ones it
+// had, so the node counts them as hits: the evaluations of the whole fleet are the LocalLoads of the stats of the
+// groups, see Group.
+type GroupCache struct {
+	group *gc.Group
+	// generation is accessed atomically.
+	generation uint64
+}
+
+// NewGroupCache creates a GroupCache on top of a new groupcache group named name, holding up to cacheBytes of
+// decisions evaluated with loader. Like groupcache.NewGroup, it panics if a group named name already exists.
+func NewGroupCache(name string, cacheBytes int64, loader Loader) *GroupCache {
+	c := &GroupCache{}
+	c.group = gc.NewGroup(name, cacheBytes, gc.GetterFunc(func(ctx context.Context, key string, dest gc.Sink) error {
+		// The key is prefixed with its generation, see groupKey.
+		if i := strings.IndexByte(key, '/'); i >= 0 {
+			key = key[i+1:]
+		}
+		res, err := loader(key)
+		if err != nil {
+			return err
+		}
+		if l, ok := ctx.Value(loadKey{}).(*load); ok && l.cache == c {
+			l.loaded = true
+		}
+		val := "0"
+		if res {
+			val = "1"
+		}
+		return dest.SetString(val)
+	}))
+	return c
+}
+
+// loadKey is the context key of the load of a GetLoad.
+type loadKey struct{}
+
+// load records whether the loader of cache evaluated the decision of a GetLoad.
+type load struct {
+	cache  *GroupCache
+	loaded bool
+}
+
+// Group returns the groupcache group of the cache, to read its stats for instance.
+func (c *GroupCache) Group() *gc.Group {
+	return c.group
+}
+
+// groupKey returns key in the current generation, as "<generation>/<key>".
+func (c *GroupCache) groupKey(key string) string {
+	return strconv.FormatUint(atomic.LoadUint64(&c.generation), 10) + "/" + key
+}
+
+// Generation returns the current generation of the keys, 0 for a new cache.
+func (c *GroupCache) Generation() uint64 {
+	return atomic.LoadUint64(&c.generation)
+}
+
+// SetGeneration sets the current generation of the keys, to join a fleet which invalidated its decisions before.
+func (c *GroupCache) SetGeneration(generation uint64) {
+	atomic.StoreUint64(&c.generation, generation)
+}
+
+// Set is ignored, the decisions are evaluated by the loader of the peer owning their key.
+func (c *GroupCache) Set(key string, value bool, extra ...interface{}) error {
+	return nil
+}
+
+// Get returns the decision for key, asking the peer owning key or evaluating it with the loader on a miss.
+func (c *GroupCache) Get(key string) (bool, error) {
+	return c.GetCtx(context.Background(), key)
+}
+
+// SetCtx is like Set.
+func (c *GroupCache) SetCtx(ctx context.Context, key string, value bool, extra ...interface{}) error {
+	return nil
+}
+
+// GetCtx is like Get, but gives ctx to the peer owning key.
+func (c *GroupCache) GetCtx(ctx context.Context, key string) (bool, error) {
+	res, _, err := c.GetLoad(ctx, key)
+	return res, err
+}
+
+// GetLoad is like GetCtx, but also reports whether the decision was evaluated by the loader of the node for this
+// call. A decision evaluated by the peer owning key is not, see GroupCache.
+func (c *GroupCache) GetLoad(ctx context.Context, key string) (bool, bool, error) {
+	l := &load{cache: c}
+	var val string
+	if err := c.group.Get(context.WithValue(ctx, loadKey{}, l), c.groupKey(key), gc.StringSink(&val)); err != nil {
+		return false, false, err
+	}
+	return val == "1", l.loaded, nil
+}
+
+// Delete drops all the decisions like Clear, as groupcache can't delete a single one.
+func (c *GroupCache) Delete(key string) error {
+	return c.Clear()
+}
+
+// Clear drops all the decisions by moving to the next generation of the keys, the decisions of the previous ones
+// are evicted as the new ones are loaded.
+func (c *GroupCache) Clear() error {
+	atomic.AddUint64(&c.generation, 1)
+	return nil
+}
+
+// IsConcurrent reports that the cache is safe for concurrent use, as required by the loads of groupcache.
+func (c *GroupCache) IsConcurrent() bool {
+	return true
+}
@@ -0,0 +1,195 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/persist"
+	gc "github.com/golang/groupcache"
+	pb "github.com/golang/groupcache/groupcachepb"
+)
+
+// testPeers holds the groups of the in-process fleet, indexed by node.
+var (
+	testPeers     [2]*gc.Group
+	testPeersOnce sync.Once
+)
+
+// testPicker routes the keys to the node owning them, by their hash.
+type testPicker struct {
+	self int
+}
+
+func (p testPicker) PickPeer(key string) (gc.ProtoGetter, bool) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	if owner := int(h.Sum32() % uint32(len(testPeers))); owner != p.self {
+		return testPeer{testPeers[owner]}, true
+	}
+	return nil, false
+}
+
+// testPeer gets the values from the group of another node, like an HTTP peer would.
+type testPeer struct {
+	group *gc.Group
+}
+
+func (p testPeer) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	return p.group.Get(ctx, in.GetKey(), gc.AllocatingByteSliceSink(&out.Value))
+}
+
+// newTestFleet creates two enforcers sharing their decisions through groupcache, and the counters of the
+// evaluations of their loaders.
+func newTestFleet(t *testing.T) ([2]*casbin.CachedEnforcer, [2]*GroupCache, *[2]int32) {
+	t.Helper()
+	testPeersOnce.Do(func() {
+		gc.RegisterPerGroupPeerPicker(func(groupName string) gc.PeerPicker {
+			for i, group := range testPeers {
+				if group != nil && group.Name() == groupName {
+					return testPicker{self: i}
+				}
+			}
+			return gc.NoPeers{}
+		})
+	})
+	var enforcers [2]*casbin.CachedEnforcer
+	var caches [2]*GroupCache
+	evaluations := new([2]int32)
+	for i := range enforcers {
+		i := i
+		e, err := casbin.NewCachedEnforcer("../../../examples/basic_model.conf", "../../../examples/basic_policy.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		caches[i] = NewGroupCache(t.Name()+"-"+strconv.Itoa(i), 1<<20, func(key string) (bool, error) {
+			atomic.AddInt32(&evaluations[i], 1)
+			return e.EvaluateKey(key)
+		})
+		testPeers[i] = caches[i].Group()
+		e.SetCache(caches[i])
+		enforcers[i] = e
+	}
+	return enforcers, caches, evaluations
+}
+
+func testEnforce(t *testing.T, e *casbin.CachedEnforcer, sub, obj, act string, res bool) {
+	t.Helper()
+	if myRes, err := e.Enforce(sub, obj, act); err != nil || myRes != res {
+		t.Errorf("%s, %s, %s: %t, %v, supposed to be %t", sub, obj, act, myRes, err, res)
+	}
+}
+
+func TestGroupCache(t *testing.T) {
+	enforcers, caches, evaluations := newTestFleet(t)
+	evaluated := func() int32 {
+		return atomic.LoadInt32(&evaluations[0]) + atomic.LoadInt32(&evaluations[1])
+	}
+
+	// Each decision is evaluated once for the whole fleet, by the node owning its key.
+	for _, e := range enforcers {
+		testEnforce(t, e, "alice", "data1", "read", true)
+		testEnforce(t, e, "bob", "data2", "write", true)
+		testEnforce(t, e, "alice", "data2", "read", false)
+		testEnforce(t, e, "alice", "data1", "read", true)
+	}
+	if n := evaluated(); n != 3 {
+		t.Errorf("the fleet evaluated %d decisions, supposed to be 3", n)
+	}
+	// The first node misses the decisions its loader evaluated for it, the ones of the peer are hits.
+	if stats := enforcers[0].CacheStats(); stats.Misses != uint64(atomic.LoadInt32(&evaluations[0])) || stats.Hits+stats.Misses != 4 {
+		t.Errorf("stats of the first node: %+v, supposed to have %d misses out of 4", stats, atomic.LoadInt32(&evaluations[0]))
+	}
+	if stats := enforcers[1].CacheStats(); stats.Misses != 0 || stats.Hits != 4 {
+		t.Errorf("stats of the second node: %+v, supposed to have 4 hits", stats)
+	}
+
+	// The fleet invalidates its decisions together when the policy changes.
+	for _, e := range enforcers {
+		if _, err := e.RemovePolicy("alice", "data1", "read"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if caches[0].Generation() != 1 || caches[1].Generation() != 1 {
+		t.Errorf("generations: %d and %d, supposed to be 1", caches[0].Generation(), caches[1].Generation())
+	}
+	for _, e := range enforcers {
+		testEnforce(t, e, "alice", "data1", "read", false)
+	}
+	if n := evaluated(); n != 4 {
+		t.Errorf("the fleet evaluated %d decisions, supposed to be 4", n)
+	}
+
+	// The keys the loader can't evaluate are evaluated by the enforcer.
+	if _, err := caches[0].Get("alice$$data1$$read$$"); !errors.Is(err, persist.ErrNoSuchKey) {
+		t.Errorf("Get of a key which can't be evaluated returned %v, supposed to be %v", err, persist.ErrNoSuchKey)
+	}
+	if res, err := enforcers[1].EnforceWithMatcher("r.sub == p.sub", "bob", "data1", "read"); err != nil || !res {
+		t.Errorf("EnforceWithMatcher: %t, %v, supposed to be true", res, err)
+	}
+}
+
+func TestGroupCacheSetGeneration(t *testing.T) {
+	_, caches, evaluations := newTestFleet(t)
+	if _, err := caches[0].Get("5:alice$$5:data1$$4:read$$"); err != nil {
+		t.Fatal(err)
+	}
+	_ = caches[1].Clear()
+	caches[0].SetGeneration(caches[1].Generation())
+	for _, c := range caches {
+		if res, err := c.Get("5:alice$$5:data1$$4:read$$"); err != nil || !res {
+			t.Errorf("Get: %t, %v, supposed to be true", res, err)
+		}
+	}
+	// The decision is evaluated again in the new generation only.
+	if n := atomic.LoadInt32(&evaluations[0]) + atomic.LoadInt32(&evaluations[1]); n != 2 {
+		t.Errorf("the fleet evaluated %d decisions, supposed to be 2", n)
+	}
+
+	var _ persist.ContextCache = caches[0]
+	var _ persist.LoadingCache = caches[0]
+	var _ persist.ConcurrentCache = caches[0]
+}
+
+func TestGroupCacheGetLoad(t *testing.T) {
+	_, caches, evaluations := newTestFleet(t)
+	for _, key := range []string{"5:alice$$5:data1$$4:read$$", "3:bob$$5:data2$$5:write$$", "5:alice$$5:data2$$4:read$$"} {
+		owner := 0
+		if _, ok := (testPicker{self: 0}).PickPeer(caches[0].groupKey(key)); ok {
+			owner = 1
+		}
+		before := atomic.LoadInt32(&evaluations[owner])
+		for i, c := range caches {
+			_, loaded, err := c.GetLoad(context.Background(), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Only the first Get of the owner is evaluated by its loader for it.
+			if want := owner == 0 && i == 0; loaded != want {
+				t.Errorf("%s: node %d loaded %t, supposed to be %t", key, i, loaded, want)
+			}
+		}
+		if n := atomic.LoadInt32(&evaluations[owner]) - before; n != 1 {
+			t.Errorf("%s: the owner evaluated it %d times, supposed to be once", key, n)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+type lfuEntry struct {
+	key  string
+	item cacheItem[bool]
+	freq int
+	elem *list.Element
+}
+
+// LFUCache is an in-memory implementation of persist.Cache holding at most capacity items,
+// the least frequently used item is evicted when a new one does not fit.
+// Ties are broken by evicting the least recently used of them.
+type LFUCache struct {
+	capacity int
+	m        map[string]*lfuEntry
+	// freqs holds a non-empty list per frequency, the most recently used entry at the front.
+	freqs   map[int]*list.List
+	minFreq int
+	mutex   sync.Mutex
+	// stats and onEvict are guarded by mutex.
+	stats   persist.EvictionStats
+	onEvict func(key string)
+}
+
+// NewLFUCache creates an empty LFUCache, a capacity less than 1 is treated as 1.
+func NewLFUCache(capacity int) *LFUCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &LFUCache{
+		capacity: capacity,
+		m:        make(map[string]*lfuEntry),
+		freqs:    make(map[int]*list.List),
+	}
+}
+
+// Set puts key and value into cache, the first parameter of extra is the survival time in seconds.
+// Setting an existing key counts as a use of it.
+func (c *LFUCache) Set(key string, value bool, extra ...interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	expiresAt, err := expireAt(time.Now(), extra...)
+	if err != nil {
+		return err
+	}
+	c.setItem(key, cacheItem[bool]{value: value, expiresAt: expiresAt})
+	return nil
+}
+
+// SetWithTTL puts key and value into cache, surviving for ttl, or always if ttl is 0 or less.
+// Setting an existing key counts as a use of it.
+func (c *LFUCache) SetWithTTL(key string, value bool, ttl time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.setItem(key, cacheItem[bool]{value: value, expiresAt: expireAfter(time.Now(), ttl)})
+	return nil
+}
+
+// setItem puts item for key, evicting the least frequently used item if the cache is full.
+func (c *LFUCache) setItem(key string, item cacheItem[bool]) {
+	if entry, ok := c.m[key]; ok {
+		entry.item = item
+		c.touch(entry)
+		return
+	}
+	if len(c.m) >= c.capacity {
+		c.evict()
+	}
+	entry := &lfuEntry{key: key, item: item, freq: 1}
+	entry.elem = c.list(1).PushFront(entry)
+	c.m[key] = entry
+	c.minFreq = 1
+}
+
+// Get returns the value for key and counts it as a use.
+func (c *LFUCache) Get(key string) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.m[key]
+	if !ok {
+		return false, persist.ErrNoSuchKey
+	}
+	if entry.item.expired(time.Now()) {
+		c.remove(entry)
+		c.stats.Expirations++
+		return false, persist.ErrNoSuchKey
+	}
+	c.touch(entry)
+	return entry.item.value, nil
+}
+
+// Delete removes key from cache.
+func (c *LFUCache) Delete(key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.m[key]
+	if !ok {
+		return persist.ErrNoSuchKey
+	}
+	c.remove(entry)
+	return nil
+}
+
+// Clear deletes all the items stored in cache and resets their frequencies.
+func (c *LFUCache) Clear() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.m = make(map[string]*lfuEntry)
+	c.freqs = make(map[int]*list.List)
+	c.minFreq = 0
+	return nil
+}
+
+// DeletePrefix removes all the keys starting with prefix.
+func (c *LFUCache) DeletePrefix(prefix string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, entry := range c.m {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(entry)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of items in cache, including the expired ones which are not reclaimed yet.
+func (c *LFUCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.m)
+}
+
+// Keys returns a snapshot of the keys of the unexpired items in cache.
+func (c *LFUCache) Keys() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	keys := make([]string, 0, len(c.m))
+	for key, entry := range c.m {
+		if !entry.item.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (c *LFUCache) list(freq int) *list.List {
+	l, ok := c.freqs[freq]
+	if !ok {
+		l = list.New()
+		c.freqs[freq] = l
+	}
+	return l
+}
+
+func (c *LFUCache) unlink(entry *lfuEntry) {
+	l := c.freqs[entry.freq]
+	l.Remove(entry.elem)
+	if l.Len() == 0 {
+		delete(c.freqs, entry.freq)
+		if c.minFreq == entry.freq {
+			c.minFreq++
+		}
+	}
+}
+
+func (c *LFUCache) touch(entry *lfuEntry) {
+	c.unlink(entry)
+	entry.freq++
+	entry.elem = c.list(entry.freq).PushFront(entry)
+}
+
+func (c *LFUCache) remove(entry *lfuEntry) {
+	c.unlink(entry)
+	delete(c.m, entry.key)
+}
+
+func (c *LFUCache) evict() {
+	l, ok := c.freqs[c.minFreq]
+	if !ok {
+		// minFreq is stale after a removal, find the lowest frequency in use.
+		c.minFreq = 0
+		for freq := range c.freqs {
+			if c.minFreq == 0 || freq < c.minFreq {
+				c.minFreq = freq
+			}
+		}
+		if l, ok = c.freqs[c.minFreq]; !ok {
+			return
+		}
+	}
+	entry := l.Back().Value.(*lfuEntry)
+	c.remove(entry)
+	c.stats.Evictions++
+	if c.onEvict != nil {
+		c.onEvict(entry.key)
+	}
+}
+
+// EvictionStats returns the number of items evicted for capacity and of expired items reclaimed on read.
+func (c *LFUCache) EvictionStats() persist.EvictionStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.stats
+}
+
+// SetOnEvict sets a function called with the key of every item evicted for capacity, nil removes it.
+// It is called with the lock of the cache held, so it must not call back into the cache.
+func (c *LFUCache) SetOnEvict(fn func(key string)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onEvict = fn
+}
+
+// IsConcurrent reports that the cache is safe for concurrent use.
+func (c *LFUCache) IsConcurrent() bool {
+	return true
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+func TestLFUCache(t *testing.T) {
+	c := NewLFUCache(2)
+	_ = c.Set("hot", true)
+	_ = c.Set("cold", false)
+	for i := 0; i < 5; i++ {
+		testGetCache(t, c, "hot", true, nil)
+	}
+
+	// cold is used less than hot, so it is evicted even though hot is older.
+	_ = c.Set("new", true)
+	testGetCache(t, c, "cold", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "hot", true, nil)
+	testGetCache(t, c, "new", true, nil)
+	if len(c.m) != 2 {
+		t.Errorf("cache holds %d items, supposed to be at most 2", len(c.m))
+	}
+	if stats := c.EvictionStats(); stats != (persist.EvictionStats{Evictions: 1}) {
+		t.Errorf("stats: %+v, supposed to have 1 eviction", stats)
+	}
+}
+
+func TestLFUCacheTie(t *testing.T) {
+	c := NewLFUCache(3)
+	_ = c.Set("alice", true)
+	_ = c.Set("bob", true)
+	_ = c.Set("carol", true)
+	// Setting an existing key also counts as a use.
+	_ = c.Set("alice", false)
+
+	// bob and carol are tied, bob is the least recently used.
+	_ = c.Set("dave", true)
+	testGetCache(t, c, "bob", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "alice", false, nil)
+	testGetCache(t, c, "carol", true, nil)
+	testGetCache(t, c, "dave", true, nil)
+}
+
+func TestLFUCacheClear(t *testing.T) {
+	c := NewLFUCache(2)
+	_ = c.Set("alice", true)
+	for i := 0; i < 5; i++ {
+		_, _ = c.Get("alice")
+	}
+	_ = c.Clear()
+	testGetCache(t, c, "alice", false, persist.ErrNoSuchKey)
+
+	// Frequencies are reset, so alice starts over on par with bob and is evicted first.
+	_ = c.Set("alice", true)
+	_ = c.Set("bob", true)
+	_ = c.Set("carol", true)
+	testGetCache(t, c, "alice", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "bob", true, nil)
+
+	if err := c.Delete("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete("bob"); err != persist.ErrNoSuchKey {
+		t.Errorf("Delete of a missing key returned %v, supposed to be %v", err, persist.ErrNoSuchKey)
+	}
+}
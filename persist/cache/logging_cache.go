@@ -0,0 +1,101 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+// LoggingCache is a persist.Cache which delegates to an inner cache and writes a JSON line to a sink
+// for every Set, Delete and Clear, like
+//
+//	{"op":"set","key":"5:alice$$5:data1$$4:read$$","value":true,"ttl":60}
+//
+// The results of the inner cache are returned unchanged, and errors writing to the sink are ignored.
+// LoggingCache only implements persist.Cache, so for instance CachedEnforcer.InvalidateCacheForSubject
+// clears the whole inner cache, which is logged as a single clear.
+type LoggingCache struct {
+	inner persist.Cache
+	sink  io.Writer
+	mutex sync.Mutex
+}
+
+type cacheLogEntry struct {
+	Op    string `json:"op"`
+	Key   string `json:"key,omitempty"`
+	Value *bool  `json:"value,omitempty"`
+	// TTL is in seconds.
+	TTL   *float64 `json:"ttl,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// NewLoggingCache creates a LoggingCache which delegates to inner and logs to sink.
+func NewLoggingCache(inner persist.Cache, sink io.Writer) *LoggingCache {
+	return &LoggingCache{inner: inner, sink: sink}
+}
+
+func (c *LoggingCache) log(entry cacheLogEntry, err error) {
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	line, _ := json.Marshal(entry)
+	line = append(line, '\n')
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, _ = c.sink.Write(line)
+}
+
+// Set puts key and value into the inner cache and logs it with the survival time in extra in seconds, see
+// persist.ParseTTL, or 0 if there is none. A survival time which can't be parsed is logged as the error of the Set
+// if the inner cache accepted it.
+func (c *LoggingCache) Set(key string, value bool, extra ...interface{}) error {
+	err := c.inner.Set(key, value, extra...)
+	d, ttlErr := persist.ParseTTL(extra...)
+	ttl := d.Seconds()
+	logErr := err
+	if logErr == nil {
+		logErr = ttlErr
+	}
+	c.log(cacheLogEntry{Op: "set", Key: key, Value: &value, TTL: &ttl}, logErr)
+	return err
+}
+
+// Get returns the result for key from the inner cache, it is not logged.
+func (c *LoggingCache) Get(key string) (bool, error) {
+	return c.inner.Get(key)
+}
+
+// Delete removes key from the inner cache and logs it.
+func (c *LoggingCache) Delete(key string) error {
+	err := c.inner.Delete(key)
+	c.log(cacheLogEntry{Op: "delete", Key: key}, err)
+	return err
+}
+
+// Clear deletes all the items stored in the inner cache and logs it.
+func (c *LoggingCache) Clear() error {
+	err := c.inner.Clear()
+	c.log(cacheLogEntry{Op: "clear"}, err)
+	return err
+}
+
+// IsConcurrent reports whether the cache is safe for concurrent use, which it is if the inner cache is.
+func (c *LoggingCache) IsConcurrent() bool {
+	return isConcurrent(c.inner)
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+func TestLoggingCache(t *testing.T) {
+	var sink bytes.Buffer
+	c := NewLoggingCache(NewLRUCache(4), &sink)
+
+	_ = c.Set("alice", true, uint(60))
+	_ = c.Set("bob", false)
+	_ = c.Set("carol", true, 30)
+	_ = c.Set("dave", true, 1500*time.Millisecond)
+	testGetCache(t, c, "alice", true, nil)
+	testGetCache(t, c, "erin", false, persist.ErrNoSuchKey)
+	_ = c.Delete("bob")
+	if err := c.Delete("bob"); err != persist.ErrNoSuchKey {
+		t.Errorf("Delete of a missing key returned %v, supposed to be %v", err, persist.ErrNoSuchKey)
+	}
+	_ = c.Clear()
+
+	want := []string{
+		`{"op":"set","key":"alice","value":true,"ttl":60}`,
+		`{"op":"set","key":"bob","value":false,"ttl":0}`,
+		`{"op":"set","key":"carol","value":true,"ttl":30}`,
+		`{"op":"set","key":"dave","value":true,"ttl":1.5}`,
+		`{"op":"delete","key":"bob"}`,
+		`{"op":"delete","key":"bob","error":"there's no such key existing in cache"}`,
+		`{"op":"clear"}`,
+	}
+	got := strings.Split(strings.TrimSuffix(sink.String(), "\n"), "\n")
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("log:\n%s\nsupposed to be:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLoggingCacheInvalidTTL(t *testing.T) {
+	var sink bytes.Buffer
+	// The logged cache ignores extra, the invalid survival time is still logged as an error.
+	c := NewLoggingCache(newMockFarCache(), &sink)
+	if err := c.Set("alice", true, "60"); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"op":"set","key":"alice","value":true,"ttl":0,"error":"` + persist.ErrInvalidTTL.Error() + `, not a string"}` + "\n"
+	if sink.String() != want {
+		t.Errorf("log: %s, supposed to be: %s", sink.String(), want)
+	}
+}
+
+func TestLoggingCacheIsConcurrent(t *testing.T) {
+	var _ persist.ConcurrentCache = &LoggingCache{}
+	if c := NewLoggingCache(NewDefaultCache(), &bytes.Buffer{}); !c.IsConcurrent() {
+		t.Error("a LoggingCache of a concurrent cache is supposed to be concurrent")
+	}
+	if c := NewLoggingCache(newMockFarCache(), &bytes.Buffer{}); c.IsConcurrent() {
+		t.Error("a LoggingCache of a cache which is not concurrent is not supposed to be concurrent")
+	}
+}
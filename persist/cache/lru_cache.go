@@ -0,0 +1,214 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+type lruEntry struct {
+	key  string
+	item cacheItem[bool]
+}
+
+// LRUCache is an in-memory implementation of persist.Cache holding at most capacity items,
+// the least recently used item is evicted when a new one does not fit.
+type LRUCache struct {
+	capacity int
+	ll       *list.List
+	m        map[string]*list.Element
+	mutex    sync.Mutex
+	// stats and onEvict are guarded by mutex.
+	stats   persist.EvictionStats
+	onEvict func(key string)
+}
+
+// NewLRUCache creates an empty LRUCache, a capacity less than 1 is treated as 1.
+func NewLRUCache(capacity int) *LRUCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &LRUCache{
+		capacity: capacity,
+		ll:       list.New(),
+		m:        make(map[string]*list.Element),
+	}
+}
+
+// Set puts key and value into cache, the first parameter of extra is the survival time in seconds.
+func (c *LRUCache) Set(key string, value bool, extra ...interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	expiresAt, err := expireAt(time.Now(), extra...)
+	if err != nil {
+		return err
+	}
+	c.setItem(key, cacheItem[bool]{value: value, expiresAt: expiresAt})
+	return nil
+}
+
+// SetWithTTL puts key and value into cache, surviving for ttl, or always if ttl is 0 or less.
+func (c *LRUCache) SetWithTTL(key string, value bool, ttl time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.setItem(key, cacheItem[bool]{value: value, expiresAt: expireAfter(time.Now(), ttl)})
+	return nil
+}
+
+// Touch resets the survival time of key, the first parameter of extra is the survival time in seconds.
+// It doesn't mark key as the most recently used.
+func (c *LRUCache) Touch(key string, extra ...interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	elem, ok := c.m[key]
+	if !ok || elem.Value.(*lruEntry).item.expired(now) {
+		return persist.ErrNoSuchKey
+	}
+	expiresAt, err := expireAt(now, extra...)
+	if err != nil {
+		return err
+	}
+	elem.Value.(*lruEntry).item.expiresAt = expiresAt
+	return nil
+}
+
+// setItem puts item for key as the most recently used, evicting the least recently used items over capacity.
+func (c *LRUCache) setItem(key string, item cacheItem[bool]) {
+	if elem, ok := c.m[key]; ok {
+		elem.Value.(*lruEntry).item = item
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.m[key] = c.ll.PushFront(&lruEntry{key: key, item: item})
+	for c.ll.Len() > c.capacity {
+		elem := c.ll.Back()
+		c.removeElement(elem)
+		c.stats.Evictions++
+		if c.onEvict != nil {
+			c.onEvict(elem.Value.(*lruEntry).key)
+		}
+	}
+}
+
+// Get returns the value for key and marks it as the most recently used.
+func (c *LRUCache) Get(key string) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.m[key]
+	if !ok {
+		return false, persist.ErrNoSuchKey
+	}
+	entry := elem.Value.(*lruEntry)
+	if entry.item.expired(time.Now()) {
+		c.removeElement(elem)
+		c.stats.Expirations++
+		return false, persist.ErrNoSuchKey
+	}
+	c.ll.MoveToFront(elem)
+	return entry.item.value, nil
+}
+
+// Has reports whether key exists in cache and has not expired, without marking it as the most recently used.
+func (c *LRUCache) Has(key string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.m[key]
+	return ok && !elem.Value.(*lruEntry).item.expired(time.Now())
+}
+
+// Delete removes key from cache.
+func (c *LRUCache) Delete(key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.m[key]
+	if !ok {
+		return persist.ErrNoSuchKey
+	}
+	c.removeElement(elem)
+	return nil
+}
+
+// Clear deletes all the items stored in cache.
+func (c *LRUCache) Clear() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ll.Init()
+	c.m = make(map[string]*list.Element)
+	return nil
+}
+
+// DeletePrefix removes all the keys starting with prefix.
+func (c *LRUCache) DeletePrefix(prefix string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, elem := range c.m {
+		if strings.HasPrefix(key, prefix) {
+			c.removeElement(elem)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of items in cache, including the expired ones which are not reclaimed yet.
+func (c *LRUCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ll.Len()
+}
+
+// Keys returns a snapshot of the keys of the unexpired items in cache, from the most to the least recently used.
+func (c *LRUCache) Keys() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	keys := make([]string, 0, c.ll.Len())
+	for elem := c.ll.Front(); elem != nil; elem = elem.Next() {
+		if entry := elem.Value.(*lruEntry); !entry.item.expired(now) {
+			keys = append(keys, entry.key)
+		}
+	}
+	return keys
+}
+
+func (c *LRUCache) removeElement(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.m, elem.Value.(*lruEntry).key)
+}
+
+// EvictionStats returns the number of items evicted for capacity and of expired items reclaimed on read.
+func (c *LRUCache) EvictionStats() persist.EvictionStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.stats
+}
+
+// SetOnEvict sets a function called with the key of every item evicted for capacity, nil removes it.
+// It is called with the lock of the cache held, so it must not call back into the cache.
+func (c *LRUCache) SetOnEvict(fn func(key string)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onEvict = fn
+}
+
+// IsConcurrent reports that the cache is safe for concurrent use.
+func (c *LRUCache) IsConcurrent() bool {
+	return true
+}
@@ -0,0 +1,111 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(2)
+	_ = c.Set("alice", true)
+	_ = c.Set("bob", false)
+
+	// alice becomes the most recently used, so bob is evicted for carol.
+	testGetCache(t, c, "alice", true, nil)
+	_ = c.Set("carol", true)
+
+	testGetCache(t, c, "bob", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "alice", true, nil)
+	testGetCache(t, c, "carol", true, nil)
+	if c.ll.Len() != 2 || len(c.m) != 2 {
+		t.Errorf("cache holds %d items, supposed to be at most 2", c.ll.Len())
+	}
+	if stats := c.EvictionStats(); stats != (persist.EvictionStats{Evictions: 1}) {
+		t.Errorf("stats: %+v, supposed to have 1 eviction", stats)
+	}
+
+	// Overwriting an existing key does not evict anything.
+	_ = c.Set("alice", false)
+	testGetCache(t, c, "alice", false, nil)
+	testGetCache(t, c, "carol", true, nil)
+
+	if err := c.Delete("carol"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete("carol"); err != persist.ErrNoSuchKey {
+		t.Errorf("Delete of a missing key returned %v, supposed to be %v", err, persist.ErrNoSuchKey)
+	}
+
+	_ = c.Clear()
+	testGetCache(t, c, "alice", false, persist.ErrNoSuchKey)
+	if c.ll.Len() != 0 || len(c.m) != 0 {
+		t.Error("cache should be empty after Clear")
+	}
+}
+
+func TestLRUCacheExpireTime(t *testing.T) {
+	c := NewLRUCache(2)
+	_ = c.Set("alice", true, uint(1))
+	_ = c.Set("bob", true, uint(0))
+
+	time.Sleep(1100 * time.Millisecond)
+
+	testGetCache(t, c, "alice", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "bob", true, nil)
+	if c.ll.Len() != 1 {
+		t.Error("expired item should be removed when it is read")
+	}
+	if stats := c.EvictionStats(); stats != (persist.EvictionStats{Expirations: 1}) {
+		t.Errorf("stats: %+v, supposed to have 1 expiration", stats)
+	}
+}
+
+func TestLRUCacheHas(t *testing.T) {
+	c := NewLRUCache(2)
+	_ = c.Set("alice$$data1$$read$$", true)
+	_ = c.Set("bob$$data2$$write$$", true)
+
+	// Has doesn't mark alice as recently used, so she is still evicted first.
+	if !c.Has("alice$$data1$$read$$") || c.Has("carol$$data3$$read$$") {
+		t.Error("Has is supposed to report the existing keys only")
+	}
+	_ = c.Set("carol$$data3$$read$$", true)
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "bob$$data2$$write$$", true, nil)
+}
+
+func TestLRUCacheTouch(t *testing.T) {
+	c := NewLRUCache(2)
+	var _ persist.TouchCache = c
+	_ = c.Set("alice$$data1$$read$$", true, uint(60))
+	_ = c.Set("bob$$data2$$write$$", true)
+
+	if err := c.Touch("alice$$data1$$read$$"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := c.m["alice$$data1$$read$$"].Value.(*lruEntry).item.expiresAt; !ttl.IsZero() {
+		t.Errorf("alice expires at %v, supposed to never expire once touched without survival time", ttl)
+	}
+	// Touch doesn't mark alice as recently used, so she is still evicted first.
+	_ = c.Set("carol$$data3$$read$$", true)
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+	if err := c.Touch("alice$$data1$$read$$"); err != persist.ErrNoSuchKey {
+		t.Errorf("Touch of a missing key returned %v, supposed to be %v", err, persist.ErrNoSuchKey)
+	}
+}
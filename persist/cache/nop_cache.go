@@ -0,0 +1,56 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+// NopCache is a persist.Cache which never retains anything, so every lookup misses.
+// It lets a CachedEnforcer go through its whole cached code path without storing decisions,
+// to measure the uncached path for instance.
+type NopCache struct{}
+
+// Set does nothing.
+func (NopCache) Set(key string, value bool, extra ...interface{}) error {
+	return nil
+}
+
+// SetWithTTL does nothing.
+func (NopCache) SetWithTTL(key string, value bool, ttl time.Duration) error {
+	return nil
+}
+
+// Get always returns ErrNoSuchKey.
+func (NopCache) Get(key string) (bool, error) {
+	return false, persist.ErrNoSuchKey
+}
+
+// Delete does nothing.
+func (NopCache) Delete(key string) error {
+	return nil
+}
+
+// Clear does nothing.
+func (NopCache) Clear() error {
+	return nil
+}
+
+// IsConcurrent reports that the cache is safe for concurrent use.
+func (NopCache) IsConcurrent() bool {
+	return true
+}
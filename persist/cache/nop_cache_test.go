@@ -0,0 +1,35 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+func TestNopCache(t *testing.T) {
+	var c persist.Cache = NopCache{}
+	if err := c.Set("alice$$data1$$read$$", true); err != nil {
+		t.Fatal(err)
+	}
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+	if err := c.Delete("alice$$data1$$read$$"); err != nil {
+		t.Errorf("Delete returned %v, supposed to be nil", err)
+	}
+	if err := c.Clear(); err != nil {
+		t.Errorf("Clear returned %v, supposed to be nil", err)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+// ReadThroughCache is a persist.Cache in front of an authoritative source of decisions, like a secondary store.
+// Get loads the misses of the inner cache from the source and populates the inner cache with them,
+// while Set, Delete and Clear only reach the inner cache.
+type ReadThroughCache struct {
+	inner   persist.Cache
+	loader  func(key string) (bool, bool, error)
+	loadTTL uint
+}
+
+// NewReadThroughCache creates a ReadThroughCache loading the misses of inner with loader, which returns the value
+// for key and whether it was found.
+func NewReadThroughCache(inner persist.Cache, loader func(key string) (bool, bool, error)) *ReadThroughCache {
+	return &ReadThroughCache{inner: inner, loader: loader}
+}
+
+// SetLoadTTL sets the survival time in seconds of the loaded items in the inner cache, 0 means they never expire,
+// which is the default.
+func (c *ReadThroughCache) SetLoadTTL(ttl uint) {
+	c.loadTTL = ttl
+}
+
+// Set puts key and value into the inner cache, extra is passed along.
+func (c *ReadThroughCache) Set(key string, value bool, extra ...interface{}) error {
+	return c.inner.Set(key, value, extra...)
+}
+
+// Get returns the result for key from the inner cache, or else loads it and puts it into the inner cache.
+// ErrNoSuchKey is returned if the loader doesn't find key either.
+func (c *ReadThroughCache) Get(key string) (bool, error) {
+	if res, err := c.inner.Get(key); !errors.Is(err, persist.ErrNoSuchKey) {
+		return res, err
+	}
+	res, found, err := c.loader(key)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		return false, persist.ErrNoSuchKey
+	}
+	// The loaded value is returned even if it can't be cached.
+	_ = c.inner.Set(key, res, c.loadTTL)
+	return res, nil
+}
+
+// Delete removes key from the inner cache, the source is left untouched.
+func (c *ReadThroughCache) Delete(key string) error {
+	return c.inner.Delete(key)
+}
+
+// Clear deletes all the items stored in the inner cache, the source is left untouched.
+func (c *ReadThroughCache) Clear() error {
+	return c.inner.Clear()
+}
+
+// IsConcurrent reports whether the cache is safe for concurrent use, which it is if the inner cache is,
+// the loader being then called concurrently too.
+func (c *ReadThroughCache) IsConcurrent() bool {
+	return isConcurrent(c.inner)
+}
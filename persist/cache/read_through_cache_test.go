@@ -0,0 +1,66 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+func TestReadThroughCache(t *testing.T) {
+	source := map[string]bool{"alice$$data1$$read$$": true, "bob$$data2$$write$$": false}
+	loads := 0
+	inner := NewDefaultCache()
+	c := NewReadThroughCache(inner, func(key string) (bool, bool, error) {
+		loads++
+		res, ok := source[key]
+		return res, ok, nil
+	})
+	c.SetLoadTTL(60)
+
+	// A miss is loaded and populates the inner cache.
+	testGetCache(t, c, "alice$$data1$$read$$", true, nil)
+	testGetCache(t, c, "bob$$data2$$write$$", false, nil)
+	testGetCache(t, inner, "alice$$data1$$read$$", true, nil)
+	if ttl, err := inner.TTL("alice$$data1$$read$$"); err != nil || ttl <= 0 {
+		t.Errorf("TTL of a loaded item: %v, %v, supposed to be the load TTL", ttl, err)
+	}
+	testGetCache(t, c, "alice$$data1$$read$$", true, nil)
+	if loads != 2 {
+		t.Errorf("%d loads, supposed to be 2", loads)
+	}
+
+	// A key the loader doesn't find stays missing.
+	testGetCache(t, c, "carol$$data3$$read$$", false, persist.ErrNoSuchKey)
+	testGetCache(t, inner, "carol$$data3$$read$$", false, persist.ErrNoSuchKey)
+
+	_ = c.Clear()
+	testGetCache(t, inner, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "alice$$data1$$read$$", true, nil)
+	if loads != 4 {
+		t.Errorf("%d loads, supposed to be 4", loads)
+	}
+}
+
+func TestReadThroughCacheIsConcurrent(t *testing.T) {
+	var _ persist.ConcurrentCache = &ReadThroughCache{}
+	if c := NewReadThroughCache(NewDefaultCache(), nil); !c.IsConcurrent() {
+		t.Error("a ReadThroughCache of a concurrent cache is supposed to be concurrent")
+	}
+	if c := NewReadThroughCache(newMockFarCache(), nil); c.IsConcurrent() {
+		t.Error("a ReadThroughCache of a cache which is not concurrent is not supposed to be concurrent")
+	}
+}
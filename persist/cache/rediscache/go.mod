@@ -0,0 +1,19 @@
+module github.com/casbin/casbin/v2/persist/cache/rediscache
+
+go 1.18
+
+require (
+	github.com/alicebob/miniredis/v2 v2.14.3
+	github.com/casbin/casbin/v2 v2.0.0
+	github.com/go-redis/redis/v8 v8.11.4
+)
+
+require (
+	github.com/Knetic/govaluate v3.0.1-0.20171022003610-9aa49832a739+incompatible // indirect
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/yuin/gopher-lua v0.0.0-20200816102855-ee81675732da // indirect
+)
+
+replace github.com/casbin/casbin/v2 => ../../../
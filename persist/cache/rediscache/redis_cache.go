@@ -0,0 +1,272 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrNoPrefix is returned by Clear and DeletePrefix when the keys to delete have no prefix, as they would then be
+// all the keys of the database, including the ones which are not cached decisions.
+var ErrNoPrefix = errors.New("the keys to delete have no prefix, refusing to delete all the keys of the database")
+
+// scanCount is the number of keys asked for per SCAN round trip in Clear and DeletePrefix.
+const scanCount = 1000
+
+// RedisCache is an implementation of persist.Cache backed by Redis, so that the cached decisions
+// can be shared by several instances. All the keys are stored under a prefix.
+type RedisCache struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisCache creates a RedisCache on top of an existing client, every key is stored under prefix.
+// Clear only deletes the keys under prefix, and fails with ErrNoPrefix if prefix is empty.
+// With a *redis.ClusterClient, the commands on several keys are split into one command per key, pipelined per node,
+// since the keys may lie in different slots, and Clear and DeletePrefix scan every master.
+func NewRedisCache(client redis.UniversalClient, prefix string) *RedisCache {
+	return &RedisCache{client: client, prefix: prefix}
+}
+
+// ttl returns the expiration of the survival time in extra, 0 if the key never expires, see persist.ParseTTL.
+func ttl(extra ...interface{}) (time.Duration, error) {
+	d, err := persist.ParseTTL(extra...)
+	if err != nil || d < 0 {
+		return 0, err
+	}
+	return d, nil
+}
+
+// Set puts key and value into cache, the first parameter of extra is the survival time in seconds.
+func (c *RedisCache) Set(key string, value bool, extra ...interface{}) error {
+	return c.SetCtx(context.Background(), key, value, extra...)
+}
+
+// SetCtx is like Set, but gives up once ctx is done.
+func (c *RedisCache) SetCtx(ctx context.Context, key string, value bool, extra ...interface{}) error {
+	val := "0"
+	if value {
+		val = "1"
+	}
+	expiration, err := ttl(extra...)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, c.prefix+key, val, expiration).Err()
+}
+
+// Get returns the value for key.
+func (c *RedisCache) Get(key string) (bool, error) {
+	return c.GetCtx(context.Background(), key)
+}
+
+// GetCtx is like Get, but gives up once ctx is done.
+func (c *RedisCache) GetCtx(ctx context.Context, key string) (bool, error) {
+	val, err := c.client.Get(ctx, c.prefix+key).Result()
+	if err == redis.Nil {
+		return false, persist.ErrNoSuchKey
+	}
+	if err != nil {
+		return false, err
+	}
+	return val == "1", nil
+}
+
+// TTL returns the remaining survival time of key, or 0 if it never expires.
+func (c *RedisCache) TTL(key string) (time.Duration, error) {
+	d, err := c.client.PTTL(context.Background(), c.prefix+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// PTTL replies -2 for a missing key and -1 for a key without expiry.
+	switch d {
+	case -2:
+		return 0, persist.ErrNoSuchKey
+	case -1:
+		return 0, nil
+	}
+	return d, nil
+}
+
+// Touch resets the survival time of key, the first parameter of extra is the survival time in seconds.
+func (c *RedisCache) Touch(key string, extra ...interface{}) error {
+	d, err := ttl(extra...)
+	if err != nil {
+		return err
+	}
+	var ok bool
+	if d > 0 {
+		ok, err = c.client.Expire(context.Background(), c.prefix+key, d).Result()
+	} else if ok, err = c.client.Persist(context.Background(), c.prefix+key).Result(); err == nil && !ok {
+		// PERSIST also replies 0 for an existing key without expiry.
+		var n int64
+		n, err = c.client.Exists(context.Background(), c.prefix+key).Result()
+		ok = n == 1
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return persist.ErrNoSuchKey
+	}
+	return nil
+}
+
+// Delete removes key from cache.
+func (c *RedisCache) Delete(key string) error {
+	n, err := c.client.Del(context.Background(), c.prefix+key).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return persist.ErrNoSuchKey
+	}
+	return nil
+}
+
+// SetMany puts all the entries into cache in a single round trip, extra is like for Set.
+func (c *RedisCache) SetMany(entries map[string]bool, extra ...interface{}) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	expiration, err := ttl(extra...)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, value := range entries {
+			val := "0"
+			if value {
+				val = "1"
+			}
+			pipe.Set(ctx, c.prefix+key, val, expiration)
+		}
+		return nil
+	})
+	return err
+}
+
+// GetMany returns the results for the keys existing in cache with a single pipeline of GET, the other keys are left out.
+func (c *RedisCache) GetMany(keys []string) (map[string]bool, error) {
+	results := make(map[string]bool, len(keys))
+	if len(keys) == 0 {
+		return results, nil
+	}
+	ctx := context.Background()
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, c.prefix+key)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		val, err := cmd.Result()
+		// GET replies nil for the missing keys.
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		results[keys[i]] = val == "1"
+	}
+	return results, nil
+}
+
+// DeleteMany removes the keys from cache with a single pipeline of DEL, it is not an error if some of them don't exist.
+func (c *RedisCache) DeleteMany(keys []string) error {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.prefix + key
+	}
+	return deleteKeys(context.Background(), c.client, prefixed)
+}
+
+// deleteKeys deletes the keys with one DEL each in a single pipeline, so that they may lie in different slots of a
+// cluster.
+func deleteKeys(ctx context.Context, client redis.Cmdable, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	return err
+}
+
+// Clear deletes all the keys under the prefix, leaving the other data in Redis untouched.
+func (c *RedisCache) Clear() error {
+	return c.DeletePrefix("")
+}
+
+// DeletePrefix removes all the keys starting with prefix, it fails with ErrNoPrefix if neither prefix nor the prefix
+// of the cache is set. With a *redis.ClusterClient, the keys are scanned on every master.
+func (c *RedisCache) DeletePrefix(prefix string) error {
+	if c.prefix+prefix == "" {
+		return ErrNoPrefix
+	}
+	ctx := context.Background()
+	match := escapePattern(c.prefix+prefix) + "*"
+	if cluster, ok := c.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return deleteMatching(ctx, node, match)
+		})
+	}
+	return deleteMatching(ctx, c.client, match)
+}
+
+// deleteMatching scans the keys matching the pattern match on the node of client and deletes them.
+func deleteMatching(ctx context.Context, client redis.Cmdable, match string) error {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, match, scanCount).Result()
+		if err != nil {
+			return err
+		}
+		if err := deleteKeys(ctx, client, keys); err != nil {
+			return err
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+var patternReplacer = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// escapePattern escapes the glob characters of s for a SCAN MATCH pattern.
+func escapePattern(s string) string {
+	return patternReplacer.Replace(s)
+}
+
+// IsConcurrent reports that the cache is safe for concurrent use.
+func (c *RedisCache) IsConcurrent() bool {
+	return true
+}
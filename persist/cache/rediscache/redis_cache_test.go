@@ -0,0 +1,385 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestRedisCache(t *testing.T, prefix string) (*RedisCache, *miniredis.Miniredis) {
+	t.Helper()
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewRedisCache(client, prefix), mr
+}
+
+func testGetCache(t *testing.T, c persist.Cache, key string, res bool, err error) {
+	t.Helper()
+	myRes, myErr := c.Get(key)
+	if myErr != err {
+		t.Errorf("%s: error %v, supposed to be %v", key, myErr, err)
+	}
+	if myRes != res {
+		t.Errorf("%s: %t, supposed to be %t", key, myRes, res)
+	}
+}
+
+func TestRedisCache(t *testing.T) {
+	c, mr := newTestRedisCache(t, "casbin:")
+	_ = c.Set("alice$$data1$$read$$", true)
+	_ = c.Set("bob$$data2$$write$$", false)
+
+	testGetCache(t, c, "alice$$data1$$read$$", true, nil)
+	testGetCache(t, c, "bob$$data2$$write$$", false, nil)
+	testGetCache(t, c, "alice$$data2$$read$$", false, persist.ErrNoSuchKey)
+	if val, _ := mr.Get("casbin:alice$$data1$$read$$"); val != "1" {
+		t.Errorf("stored value is %q, supposed to be %q", val, "1")
+	}
+
+	if err := c.Delete("alice$$data1$$read$$"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete("alice$$data1$$read$$"); err != persist.ErrNoSuchKey {
+		t.Errorf("Delete of a missing key returned %v, supposed to be %v", err, persist.ErrNoSuchKey)
+	}
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+}
+
+func TestRedisCacheTTLTypes(t *testing.T) {
+	c, mr := newTestRedisCache(t, "casbin:")
+	_ = c.Set("alice$$data1$$read$$", true, 60)
+	_ = c.Set("bob$$data2$$write$$", true, 90*time.Second)
+	if ttl := mr.TTL("casbin:alice$$data1$$read$$"); ttl != time.Minute {
+		t.Errorf("TTL is %v, supposed to be %v", ttl, time.Minute)
+	}
+	if ttl := mr.TTL("casbin:bob$$data2$$write$$"); ttl != 90*time.Second {
+		t.Errorf("TTL is %v, supposed to be %v", ttl, 90*time.Second)
+	}
+	if err := c.Set("carol$$data3$$read$$", true, "60"); !errors.Is(err, persist.ErrInvalidTTL) {
+		t.Errorf("Set returned %v, supposed to be %v", err, persist.ErrInvalidTTL)
+	}
+	if mr.Exists("casbin:carol$$data3$$read$$") {
+		t.Error("the key should not be set with an invalid survival time")
+	}
+}
+
+func TestRedisCacheTouch(t *testing.T) {
+	c, mr := newTestRedisCache(t, "casbin:")
+	var _ persist.TouchCache = c
+	_ = c.Set("alice$$data1$$read$$", true, uint(10))
+
+	mr.FastForward(5 * time.Second)
+	if err := c.Touch("alice$$data1$$read$$", uint(10)); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("casbin:alice$$data1$$read$$"); ttl != 10*time.Second {
+		t.Errorf("TTL is %v, supposed to be reset to %v", ttl, 10*time.Second)
+	}
+	if err := c.Touch("alice$$data1$$read$$"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("casbin:alice$$data1$$read$$"); ttl != 0 {
+		t.Errorf("TTL is %v, supposed to be removed", ttl)
+	}
+	// A key without expiry can be touched again.
+	if err := c.Touch("alice$$data1$$read$$"); err != nil {
+		t.Error(err)
+	}
+	if err := c.Touch("bob$$data2$$write$$", uint(10)); err != persist.ErrNoSuchKey {
+		t.Errorf("Touch of a missing key returned %v, supposed to be %v", err, persist.ErrNoSuchKey)
+	}
+	if err := c.Touch("bob$$data2$$write$$"); err != persist.ErrNoSuchKey {
+		t.Errorf("Touch of a missing key returned %v, supposed to be %v", err, persist.ErrNoSuchKey)
+	}
+}
+
+func TestRedisCacheExpireTime(t *testing.T) {
+	c, mr := newTestRedisCache(t, "casbin:")
+	_ = c.Set("alice$$data1$$read$$", true, uint(10))
+	_ = c.Set("bob$$data2$$write$$", true, uint(0))
+
+	if ttl := mr.TTL("casbin:alice$$data1$$read$$"); ttl != 10*time.Second {
+		t.Errorf("TTL is %v, supposed to be %v", ttl, 10*time.Second)
+	}
+	mr.FastForward(11 * time.Second)
+
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "bob$$data2$$write$$", true, nil)
+}
+
+func TestRedisCacheTTL(t *testing.T) {
+	c, _ := newTestRedisCache(t, "casbin:")
+	_ = c.Set("alice$$data1$$read$$", true, uint(10))
+	_ = c.Set("bob$$data2$$write$$", true)
+
+	if ttl, err := c.TTL("alice$$data1$$read$$"); err != nil || ttl != 10*time.Second {
+		t.Errorf("TTL is %v, %v, supposed to be %v", ttl, err, 10*time.Second)
+	}
+	if ttl, err := c.TTL("bob$$data2$$write$$"); err != nil || ttl != 0 {
+		t.Errorf("TTL is %v, %v, supposed to be 0", ttl, err)
+	}
+	if _, err := c.TTL("carol$$data3$$read$$"); err != persist.ErrNoSuchKey {
+		t.Errorf("TTL of a missing key returned %v, supposed to be %v", err, persist.ErrNoSuchKey)
+	}
+}
+
+func TestRedisCacheClear(t *testing.T) {
+	c, mr := newTestRedisCache(t, "casbin[1]:")
+	_ = mr.Set("unrelated", "data")
+	_ = mr.Set("casbin1:alice$$data1$$read$$", "1")
+	for _, key := range []string{"alice$$data1$$read$$", "bob$$data2$$write$$"} {
+		_ = c.Set(key, true)
+	}
+
+	if err := c.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "bob$$data2$$write$$", false, persist.ErrNoSuchKey)
+	// Keys outside the prefix, even ones the unescaped prefix would match, survive.
+	if !mr.Exists("unrelated") || !mr.Exists("casbin1:alice$$data1$$read$$") {
+		t.Error("Clear should only delete the keys under the prefix")
+	}
+}
+
+func TestRedisCacheClearWithoutPrefix(t *testing.T) {
+	c, mr := newTestRedisCache(t, "")
+	_ = mr.Set("unrelated", "data")
+	_ = c.Set("alice$$data1$$read$$", true)
+
+	if err := c.Clear(); err != ErrNoPrefix {
+		t.Errorf("Clear without a prefix returned %v, supposed to be %v", err, ErrNoPrefix)
+	}
+	if err := c.DeletePrefix(""); err != ErrNoPrefix {
+		t.Errorf("DeletePrefix of an empty prefix returned %v, supposed to be %v", err, ErrNoPrefix)
+	}
+	if !mr.Exists("unrelated") {
+		t.Error("Clear without a prefix deleted a key which is not a cached decision")
+	}
+	testGetCache(t, c, "alice$$data1$$read$$", true, nil)
+
+	if err := c.DeletePrefix("alice$$"); err != nil {
+		t.Fatal(err)
+	}
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+	if !mr.Exists("unrelated") {
+		t.Error("DeletePrefix deleted a key outside the prefix")
+	}
+}
+
+func TestRedisCacheCtx(t *testing.T) {
+	c, _ := newTestRedisCache(t, "casbin:")
+	var _ persist.ContextCache = c
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.SetCtx(ctx, "alice$$data1$$read$$", true); err != context.Canceled {
+		t.Errorf("SetCtx returned %v, supposed to be %v", err, context.Canceled)
+	}
+	if _, err := c.GetCtx(ctx, "alice$$data1$$read$$"); err != context.Canceled {
+		t.Errorf("GetCtx returned %v, supposed to be %v", err, context.Canceled)
+	}
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+}
+
+func TestRedisCacheDeletePrefix(t *testing.T) {
+	c, mr := newTestRedisCache(t, "casbin:")
+	var _ persist.PrefixCache = c
+	_ = mr.Set("alice$$data1$$read$$", "1")
+	_ = c.Set("alice$$data1$$read$$", true)
+	_ = c.Set("alice$$data2$$read$$", true)
+	_ = c.Set("bob$$data2$$write$$", true)
+
+	if err := c.DeletePrefix("alice$$"); err != nil {
+		t.Fatal(err)
+	}
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "alice$$data2$$read$$", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "bob$$data2$$write$$", true, nil)
+	if !mr.Exists("alice$$data1$$read$$") {
+		t.Error("DeletePrefix should only delete the keys under the prefix of the cache")
+	}
+}
+
+func TestRedisCacheBatch(t *testing.T) {
+	c, mr := newTestRedisCache(t, "casbin:")
+	var _ persist.BatchCache = c
+
+	entries := map[string]bool{"alice$$data1$$read$$": true, "bob$$data2$$write$$": false}
+	if err := c.SetMany(entries, uint(10)); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("casbin:bob$$data2$$write$$"); ttl != 10*time.Second {
+		t.Errorf("TTL is %v, supposed to be %v", ttl, 10*time.Second)
+	}
+
+	res, err := c.GetMany([]string{"alice$$data1$$read$$", "bob$$data2$$write$$", "carol$$data3$$read$$"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res["alice$$data1$$read$$"] != true || res["bob$$data2$$write$$"] != false {
+		t.Errorf("GetMany: %v, supposed to be %v", res, entries)
+	}
+
+	if err := c.DeleteMany([]string{"alice$$data1$$read$$", "carol$$data3$$read$$"}); err != nil {
+		t.Fatal(err)
+	}
+	testGetCache(t, c, "alice$$data1$$read$$", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "bob$$data2$$write$$", false, nil)
+}
+
+// testMultiKeyHook counts the commands on several keys, which fail with CROSSSLOT when the keys lie in different
+// slots of a cluster. It also replies to COMMAND, whose reply by miniredis the cluster client can't parse, so that the
+// keys are routed to their slot.
+type testMultiKeyHook struct {
+	multiKey int32
+}
+
+func (h *testMultiKeyHook) count(cmd redis.Cmder) {
+	switch cmd.Name() {
+	case "del", "mget":
+		if len(cmd.Args()) > 2 {
+			atomic.AddInt32(&h.multiKey, 1)
+		}
+	}
+}
+
+func (h *testMultiKeyHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	h.count(cmd)
+	return ctx, nil
+}
+
+func (h *testMultiKeyHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if cmd, ok := cmd.(*redis.CommandsInfoCmd); ok {
+		info := make(map[string]*redis.CommandInfo)
+		for _, name := range []string{"get", "set", "del", "pttl", "expire", "persist", "exists"} {
+			info[name] = &redis.CommandInfo{Name: name, FirstKeyPos: 1, LastKeyPos: 1, StepCount: 1}
+		}
+		cmd.SetVal(info)
+		cmd.SetErr(nil)
+	}
+	return nil
+}
+
+func (h *testMultiKeyHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	for _, cmd := range cmds {
+		h.count(cmd)
+	}
+	return ctx, nil
+}
+
+func (h *testMultiKeyHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestRedisCacheCluster(t *testing.T) {
+	var nodes []*miniredis.Miniredis
+	for i := 0; i < 2; i++ {
+		mr, err := miniredis.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(mr.Close)
+		nodes = append(nodes, mr)
+	}
+	hook := &testMultiKeyHook{}
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		// The addresses let the client ask for COMMAND before the first command.
+		Addrs: []string{nodes[0].Addr(), nodes[1].Addr()},
+		ClusterSlots: func(ctx context.Context) ([]redis.ClusterSlot, error) {
+			return []redis.ClusterSlot{
+				{Start: 0, End: 8191, Nodes: []redis.ClusterNode{{Addr: nodes[0].Addr()}}},
+				{Start: 8192, End: 16383, Nodes: []redis.ClusterNode{{Addr: nodes[1].Addr()}}},
+			}, nil
+		},
+		NewClient: func(opt *redis.Options) *redis.Client {
+			node := redis.NewClient(opt)
+			node.AddHook(hook)
+			return node
+		},
+	})
+	t.Cleanup(func() { _ = client.Close() })
+	c := NewRedisCache(client, "casbin:")
+
+	entries := make(map[string]bool)
+	var keys []string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("alice$$data%d$$read$$", i)
+		entries[key] = i%2 == 0
+		keys = append(keys, key)
+	}
+	_ = c.Set("bob$$data1$$read$$", true)
+	if err := c.SetMany(entries); err != nil {
+		t.Fatal(err)
+	}
+	for i, mr := range nodes {
+		if len(mr.Keys()) == 0 {
+			t.Fatalf("node %d holds no key, the keys are supposed to be spread over the nodes", i)
+		}
+	}
+
+	res, err := c.GetMany(append(keys, "carol$$data1$$read$$"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != len(entries) {
+		t.Errorf("GetMany: %v, supposed to be %v", res, entries)
+	}
+	for key, value := range entries {
+		if res[key] != value {
+			t.Errorf("GetMany: %s is %t, supposed to be %t", key, res[key], value)
+		}
+	}
+
+	if err := c.DeleteMany(keys[:10]); err != nil {
+		t.Fatal(err)
+	}
+	testGetCache(t, c, keys[0], false, persist.ErrNoSuchKey)
+	testGetCache(t, c, keys[10], true, nil)
+
+	if err := c.DeletePrefix("alice$$"); err != nil {
+		t.Fatal(err)
+	}
+	for _, mr := range nodes {
+		for _, key := range mr.Keys() {
+			if key != "casbin:bob$$data1$$read$$" {
+				t.Errorf("%s was left by DeletePrefix", key)
+			}
+		}
+	}
+	testGetCache(t, c, "bob$$data1$$read$$", true, nil)
+	if err := c.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	testGetCache(t, c, "bob$$data1$$read$$", false, persist.ErrNoSuchKey)
+
+	if n := atomic.LoadInt32(&hook.multiKey); n != 0 {
+		t.Errorf("%d commands were sent on several keys, which may lie in different slots", n)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+type cacheShard struct {
+	cache persist.Cache
+	mutex sync.Mutex
+	// hits and misses count the results of Get, they are guarded by mutex.
+	hits   uint64
+	misses uint64
+}
+
+// ShardStat holds the counters of a shard of a ShardedCache.
+type ShardStat struct {
+	// Len is the number of items in the shard, or -1 if the inner cache does not implement persist.LenCache.
+	Len int
+	// Hits is the number of Get calls which found their key in the shard.
+	Hits uint64
+	// Misses is the number of Get calls which didn't find their key in the shard.
+	Misses uint64
+}
+
+// ShardedCache is a persist.Cache spreading its keys over several inner caches, each behind its own lock,
+// so that the operations on keys of different shards don't contend. It is safe for concurrent use,
+// whether or not the inner caches are.
+type ShardedCache struct {
+	shards []*cacheShard
+}
+
+// NewShardedCache creates a ShardedCache of shards inner caches made by factory, a number of shards less than 1 is treated as 1.
+func NewShardedCache(shards int, factory func() persist.Cache) *ShardedCache {
+	if shards < 1 {
+		shards = 1
+	}
+	c := &ShardedCache{shards: make([]*cacheShard, shards)}
+	for i := range c.shards {
+		c.shards[i] = &cacheShard{cache: factory()}
+	}
+	return c
+}
+
+func (c *ShardedCache) shard(key string) *cacheShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
+
+// Set puts key and value into the shard of key, extra is passed along to the inner cache.
+func (c *ShardedCache) Set(key string, value bool, extra ...interface{}) error {
+	s := c.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.cache.Set(key, value, extra...)
+}
+
+// SetWithTTL puts key and value into the shard of key surviving for ttl, see persist.SetWithTTL.
+func (c *ShardedCache) SetWithTTL(key string, value bool, ttl time.Duration) error {
+	s := c.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return persist.SetWithTTL(s.cache, key, value, ttl)
+}
+
+// Get returns the result for key from the shard of key.
+func (c *ShardedCache) Get(key string) (bool, error) {
+	s := c.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	value, err := s.cache.Get(key)
+	if err == nil {
+		s.hits++
+	} else if errors.Is(err, persist.ErrNoSuchKey) {
+		s.misses++
+	}
+	return value, err
+}
+
+// Delete removes key from the shard of key.
+func (c *ShardedCache) Delete(key string) error {
+	s := c.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.cache.Delete(key)
+}
+
+// Clear deletes all the items stored in all the shards, the first error is returned.
+func (c *ShardedCache) Clear() error {
+	var firstErr error
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		err := s.cache.Clear()
+		s.mutex.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// IsConcurrent reports that the cache is safe for concurrent use.
+func (c *ShardedCache) IsConcurrent() bool {
+	return true
+}
+
+// Len returns the number of items in all the shards, or -1 if an inner cache does not implement persist.LenCache.
+func (c *ShardedCache) Len() int {
+	total := 0
+	for _, stat := range c.ShardStats() {
+		if stat.Len < 0 {
+			return -1
+		}
+		total += stat.Len
+	}
+	return total
+}
+
+// ShardStats returns a snapshot of the counters of each shard, in order, to detect keys spread unevenly.
+// Each shard is read under its own lock, so the snapshots of different shards may not be taken at the same time.
+func (c *ShardedCache) ShardStats() []ShardStat {
+	stats := make([]ShardStat, len(c.shards))
+	for i, s := range c.shards {
+		s.mutex.Lock()
+		stats[i] = ShardStat{Len: -1, Hits: s.hits, Misses: s.misses}
+		if lc, ok := s.cache.(persist.LenCache); ok {
+			stats[i].Len = lc.Len()
+		}
+		s.mutex.Unlock()
+	}
+	return stats
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+func TestShardedCache(t *testing.T) {
+	var inner []*DefaultCache
+	c := NewShardedCache(4, func() persist.Cache {
+		shard := NewDefaultCache()
+		inner = append(inner, shard)
+		return shard
+	})
+	if len(inner) != 4 {
+		t.Fatalf("%d shards made, supposed to be 4", len(inner))
+	}
+
+	for i := 0; i < 100; i++ {
+		_ = c.Set(fmt.Sprintf("user%d$$data1$$read$$", i), i%2 == 0)
+	}
+	testGetCache(t, c, "user42$$data1$$read$$", true, nil)
+	testGetCache(t, c, "user43$$data1$$read$$", false, nil)
+	testGetCache(t, c, "user100$$data1$$read$$", false, persist.ErrNoSuchKey)
+
+	total := 0
+	for i, shard := range inner {
+		if shard.Len() == 0 {
+			t.Errorf("shard %d is empty, the keys are supposed to be spread", i)
+		}
+		total += shard.Len()
+	}
+	if total != 100 {
+		t.Errorf("shards hold %d items, supposed to be 100", total)
+	}
+
+	if err := c.Delete("user42$$data1$$read$$"); err != nil {
+		t.Fatal(err)
+	}
+	testGetCache(t, c, "user42$$data1$$read$$", false, persist.ErrNoSuchKey)
+
+	_ = c.Clear()
+	for i, shard := range inner {
+		if shard.Len() != 0 {
+			t.Errorf("shard %d holds %d items after Clear", i, shard.Len())
+		}
+	}
+}
+
+func TestShardedCacheShardStats(t *testing.T) {
+	c := NewShardedCache(8, func() persist.Cache { return NewDefaultCache() })
+	var _ persist.LenCache = c
+	const keys = 8000
+	for i := 0; i < keys; i++ {
+		_ = c.Set(fmt.Sprintf("5:user%d$$5:data1$$4:read$$", i), true)
+	}
+	testGetCache(t, c, "5:user1$$5:data1$$4:read$$", true, nil)
+	testGetCache(t, c, "5:user1$$5:data2$$4:read$$", false, persist.ErrNoSuchKey)
+
+	stats := c.ShardStats()
+	if len(stats) != 8 {
+		t.Fatalf("%d shard stats, supposed to be 8", len(stats))
+	}
+	total := 0
+	var hits, misses uint64
+	for i, stat := range stats {
+		// An even spread puts 1000 keys per shard.
+		if stat.Len < 800 || stat.Len > 1200 {
+			t.Errorf("shard %d holds %d items, supposed to be about 1000", i, stat.Len)
+		}
+		total += stat.Len
+		hits += stat.Hits
+		misses += stat.Misses
+	}
+	if total != keys || c.Len() != keys {
+		t.Errorf("shards hold %d items, Len %d, supposed to be %d", total, c.Len(), keys)
+	}
+	if hits != 1 || misses != 1 {
+		t.Errorf("%d hits, %d misses, supposed to be 1 of each", hits, misses)
+	}
+
+	c = NewShardedCache(2, func() persist.Cache { return NopCache{} })
+	if stats := c.ShardStats(); stats[0].Len != -1 || c.Len() != -1 {
+		t.Errorf("stats: %+v, Len %d, supposed to be unknown without persist.LenCache", stats, c.Len())
+	}
+}
+
+func TestShardedCacheConcurrency(t *testing.T) {
+	// LRUCache shards are exercised through the locks of ShardedCache, run with -race.
+	c := NewShardedCache(8, func() persist.Cache { return NewLRUCache(16) })
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := fmt.Sprintf("user%d$$data%d$$read$$", i, j%32)
+				_ = c.Set(key, true)
+				_, _ = c.Get(key)
+				if j%100 == 0 {
+					_ = c.Clear()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// benchmarkParallelMisses stores a new key per operation from parallel goroutines, like a miss-heavy load.
+func benchmarkParallelMisses(b *testing.B, c persist.Cache) {
+	var mutex sync.Mutex
+	next := 0
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		mutex.Lock()
+		worker := next
+		next++
+		mutex.Unlock()
+		i := 0
+		for pb.Next() {
+			key := fmt.Sprintf("user%d$$data%d$$read$$", worker, i)
+			if _, err := c.Get(key); err == persist.ErrNoSuchKey {
+				_ = c.Set(key, true)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkDefaultCacheParallelMisses(b *testing.B) {
+	benchmarkParallelMisses(b, NewDefaultCache())
+}
+
+func BenchmarkShardedCacheParallelMisses(b *testing.B) {
+	benchmarkParallelMisses(b, NewShardedCache(32, func() persist.Cache { return NewDefaultCache() }))
+}
@@ -0,0 +1,224 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+// sizeEntryOverhead is the approximate number of bytes held by an item of SizeCappedCache besides its key:
+// its list element, its map slot, its value and its expiry.
+const sizeEntryOverhead = 160
+
+type sizeEntry struct {
+	key  string
+	item cacheItem[bool]
+}
+
+// SizeCappedCache is an in-memory implementation of persist.Cache holding items of at most maxBytes in total,
+// as estimated by the length of their key plus a fixed overhead per item. The least recently used items are
+// evicted until a new one fits, which suits keys of very different lengths better than a number of items.
+type SizeCappedCache struct {
+	maxBytes int64
+	ll       *list.List
+	m        map[string]*list.Element
+	mutex    sync.Mutex
+	// size, stats and onEvict are guarded by mutex.
+	size    int64
+	stats   persist.EvictionStats
+	onEvict func(key string)
+}
+
+// NewSizeCappedCache creates an empty SizeCappedCache of at most maxBytes, a cap less than the size of an item
+// with an empty key is treated as that size.
+func NewSizeCappedCache(maxBytes int64) *SizeCappedCache {
+	if maxBytes < sizeEntryOverhead {
+		maxBytes = sizeEntryOverhead
+	}
+	return &SizeCappedCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		m:        make(map[string]*list.Element),
+	}
+}
+
+// entrySize returns the estimated number of bytes held by the item of key.
+func entrySize(key string) int64 {
+	return int64(len(key)) + sizeEntryOverhead
+}
+
+// Set puts key and value into cache, the first parameter of extra is the survival time in seconds.
+// It returns persist.ErrCacheFull if the item alone is larger than the cap.
+func (c *SizeCappedCache) Set(key string, value bool, extra ...interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	expiresAt, err := expireAt(time.Now(), extra...)
+	if err != nil {
+		return err
+	}
+	return c.setItem(key, cacheItem[bool]{value: value, expiresAt: expiresAt})
+}
+
+// SetWithTTL puts key and value into cache, surviving for ttl, or always if ttl is 0 or less.
+// It returns persist.ErrCacheFull like Set.
+func (c *SizeCappedCache) SetWithTTL(key string, value bool, ttl time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.setItem(key, cacheItem[bool]{value: value, expiresAt: expireAfter(time.Now(), ttl)})
+}
+
+// setItem puts item for key as the most recently used, evicting the least recently used items over the cap.
+func (c *SizeCappedCache) setItem(key string, item cacheItem[bool]) error {
+	if elem, ok := c.m[key]; ok {
+		elem.Value.(*sizeEntry).item = item
+		c.ll.MoveToFront(elem)
+		return nil
+	}
+	size := entrySize(key)
+	if size > c.maxBytes {
+		return persist.ErrCacheFull
+	}
+	for c.size+size > c.maxBytes {
+		elem := c.ll.Back()
+		c.removeElement(elem)
+		c.stats.Evictions++
+		if c.onEvict != nil {
+			c.onEvict(elem.Value.(*sizeEntry).key)
+		}
+	}
+	c.m[key] = c.ll.PushFront(&sizeEntry{key: key, item: item})
+	c.size += size
+	return nil
+}
+
+// Get returns the value for key and marks it as the most recently used.
+func (c *SizeCappedCache) Get(key string) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.m[key]
+	if !ok {
+		return false, persist.ErrNoSuchKey
+	}
+	entry := elem.Value.(*sizeEntry)
+	if entry.item.expired(time.Now()) {
+		c.removeElement(elem)
+		c.stats.Expirations++
+		return false, persist.ErrNoSuchKey
+	}
+	c.ll.MoveToFront(elem)
+	return entry.item.value, nil
+}
+
+// Has reports whether key exists in cache and has not expired, without marking it as the most recently used.
+func (c *SizeCappedCache) Has(key string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.m[key]
+	return ok && !elem.Value.(*sizeEntry).item.expired(time.Now())
+}
+
+// Delete removes key from cache.
+func (c *SizeCappedCache) Delete(key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.m[key]
+	if !ok {
+		return persist.ErrNoSuchKey
+	}
+	c.removeElement(elem)
+	return nil
+}
+
+// Clear deletes all the items stored in cache.
+func (c *SizeCappedCache) Clear() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ll.Init()
+	c.m = make(map[string]*list.Element)
+	c.size = 0
+	return nil
+}
+
+// DeletePrefix removes all the keys starting with prefix.
+func (c *SizeCappedCache) DeletePrefix(prefix string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, elem := range c.m {
+		if strings.HasPrefix(key, prefix) {
+			c.removeElement(elem)
+		}
+	}
+	return nil
+}
+
+func (c *SizeCappedCache) removeElement(elem *list.Element) {
+	key := elem.Value.(*sizeEntry).key
+	c.ll.Remove(elem)
+	delete(c.m, key)
+	c.size -= entrySize(key)
+}
+
+// Len returns the number of items in cache, including the expired ones which are not reclaimed yet.
+func (c *SizeCappedCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ll.Len()
+}
+
+// Keys returns a snapshot of the keys of the unexpired items in cache, from the most to the least recently used.
+func (c *SizeCappedCache) Keys() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	keys := make([]string, 0, c.ll.Len())
+	for elem := c.ll.Front(); elem != nil; elem = elem.Next() {
+		if entry := elem.Value.(*sizeEntry); !entry.item.expired(now) {
+			keys = append(keys, entry.key)
+		}
+	}
+	return keys
+}
+
+// Size returns the estimated number of bytes held by the items in cache, which is at most the cap.
+func (c *SizeCappedCache) Size() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.size
+}
+
+// EvictionStats returns the number of items evicted for the cap and of expired items reclaimed on read.
+func (c *SizeCappedCache) EvictionStats() persist.EvictionStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.stats
+}
+
+// SetOnEvict sets a function called with the key of every item evicted for the cap, nil removes it.
+// It is called with the lock of the cache held, so it must not call back into the cache.
+func (c *SizeCappedCache) SetOnEvict(fn func(key string)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onEvict = fn
+}
+
+// IsConcurrent reports that the cache is safe for concurrent use.
+func (c *SizeCappedCache) IsConcurrent() bool {
+	return true
+}
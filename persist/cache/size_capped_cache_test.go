@@ -0,0 +1,110 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+func TestSizeCappedCache(t *testing.T) {
+	c := NewSizeCappedCache(3 * entrySize("alice"))
+	var _ persist.HasCache = c
+	_ = c.Set("alice", true)
+	_ = c.Set("bobby", false)
+	_ = c.Set("carol", true)
+	if n, size := c.Len(), c.Size(); n != 3 || size != 3*entrySize("alice") {
+		t.Fatalf("cache holds %d items of %d bytes, supposed to be 3 of %d", n, size, 3*entrySize("alice"))
+	}
+
+	// alice becomes the most recently used, so bobby and carol are evicted for a key of about two items.
+	testGetCache(t, c, "alice", true, nil)
+	long := strings.Repeat("x", sizeEntryOverhead)
+	if err := c.Set(long, true); err != nil {
+		t.Fatal(err)
+	}
+	testGetCache(t, c, "bobby", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "carol", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "alice", true, nil)
+	testGetCache(t, c, long, true, nil)
+	if size := c.Size(); size > 3*entrySize("alice") || size != entrySize("alice")+entrySize(long) {
+		t.Errorf("cache holds %d bytes, supposed to be the ones of alice and the long key, under the cap", size)
+	}
+	if stats := c.EvictionStats(); stats != (persist.EvictionStats{Evictions: 2}) {
+		t.Errorf("stats: %+v, supposed to have 2 evictions", stats)
+	}
+
+	// A key larger than the cap is rejected without evicting anything.
+	if err := c.Set(strings.Repeat("x", 3*sizeEntryOverhead), true); err != persist.ErrCacheFull {
+		t.Errorf("Set of an oversized key returned %v, supposed to be %v", err, persist.ErrCacheFull)
+	}
+	if c.Len() != 2 {
+		t.Errorf("cache holds %d items, supposed to be 2", c.Len())
+	}
+
+	if err := c.Delete("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if size := c.Size(); size != entrySize(long) {
+		t.Errorf("cache holds %d bytes after Delete, supposed to be %d", size, entrySize(long))
+	}
+	_ = c.Clear()
+	if c.Len() != 0 || c.Size() != 0 {
+		t.Error("cache should be empty after Clear")
+	}
+}
+
+func TestSizeCappedCacheKeys(t *testing.T) {
+	c := NewSizeCappedCache(3 * entrySize("alice$$data1$$read$$"))
+	var _ persist.KeysCache = c
+	var _ persist.PrefixCache = c
+	var _ persist.EvictNotifyCache = c
+	var evicted []string
+	c.SetOnEvict(func(key string) { evicted = append(evicted, key) })
+
+	_ = c.Set("alice$$data1$$read$$", true)
+	_ = c.Set("alice$$data2$$read$$", true)
+	_ = c.Set("bobby$$data2$$read$$", false)
+	_ = c.Set("carol$$data1$$read$$", true, uint(1))
+	if strings.Join(evicted, "|") != "alice$$data1$$read$$" {
+		t.Errorf("evicted %v, supposed to be the least recently used alice", evicted)
+	}
+	want := "carol$$data1$$read$$|bobby$$data2$$read$$|alice$$data2$$read$$"
+	if keys := strings.Join(c.Keys(), "|"); keys != want {
+		t.Errorf("keys: %s, supposed to be %s", keys, want)
+	}
+
+	if err := c.DeletePrefix("alice$$"); err != nil {
+		t.Fatal(err)
+	}
+	testGetCache(t, c, "alice$$data2$$read$$", false, persist.ErrNoSuchKey)
+	testGetCache(t, c, "bobby$$data2$$read$$", false, nil)
+	if size := c.Size(); size != 2*entrySize("alice$$data1$$read$$") {
+		t.Errorf("cache holds %d bytes after DeletePrefix, supposed to be %d", size, 2*entrySize("alice$$data1$$read$$"))
+	}
+	// Deletions are not evictions.
+	if len(evicted) != 1 {
+		t.Errorf("evicted %v, supposed to be only alice", evicted)
+	}
+
+	c.SetOnEvict(nil)
+	_ = c.Set("dave$$data1$$read$$", true)
+	_ = c.Set("erin$$data1$$read$$", true)
+	if len(evicted) != 1 {
+		t.Errorf("evicted %v after SetOnEvict(nil), supposed to be only alice", evicted)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+// DefaultPromoteTTL is the survival time in seconds of the items promoted into the near tier of a TieredCache,
+// when the far tier does not implement persist.TTLCache.
+const DefaultPromoteTTL uint = 60
+
+// TieredCache is a persist.Cache made of a near tier, usually in process, in front of a far tier,
+// usually shared like RedisCache. Get reads the near tier first and promotes the hits of the far tier into it,
+// while Set, Delete and Clear write through to both tiers.
+type TieredCache struct {
+	near       persist.Cache
+	far        persist.Cache
+	promoteTTL uint
+}
+
+// NewTieredCache creates a TieredCache with near in front of far.
+func NewTieredCache(near, far persist.Cache) *TieredCache {
+	return &TieredCache{near: near, far: far, promoteTTL: DefaultPromoteTTL}
+}
+
+// SetPromoteTTL sets the survival time in seconds of the items promoted into the near tier,
+// when the far tier can't report the remaining survival time of its items. 0 means they never expire.
+// It bounds how long the near tier may serve an item the far tier has already expired.
+func (c *TieredCache) SetPromoteTTL(ttl uint) {
+	c.promoteTTL = ttl
+}
+
+// Set puts key and value into both tiers, with the same survival time so the near tier never outlives the far one.
+func (c *TieredCache) Set(key string, value bool, extra ...interface{}) error {
+	if err := c.far.Set(key, value, extra...); err != nil {
+		return err
+	}
+	return c.near.Set(key, value, extra...)
+}
+
+// SetWithTTL puts key and value into both tiers surviving for ttl, see persist.SetWithTTL.
+func (c *TieredCache) SetWithTTL(key string, value bool, ttl time.Duration) error {
+	if err := persist.SetWithTTL(c.far, key, value, ttl); err != nil {
+		return err
+	}
+	return persist.SetWithTTL(c.near, key, value, ttl)
+}
+
+// Get returns the result for key from the near tier, or else from the far tier, promoting it into the near one.
+// A promoted item survives for its remaining survival time in the far tier if the far tier implements
+// persist.TTLCache, and for the promote TTL otherwise, so it may then outlive the far one by up to the promote TTL.
+func (c *TieredCache) Get(key string) (bool, error) {
+	if res, err := c.near.Get(key); !errors.Is(err, persist.ErrNoSuchKey) {
+		return res, err
+	}
+	res, err := c.far.Get(key)
+	if err != nil {
+		return res, err
+	}
+
+	if far, ok := c.far.(persist.TTLCache); ok {
+		remaining, err := far.TTL(key)
+		if err == nil {
+			_ = persist.SetWithTTL(c.near, key, res, remaining)
+		}
+		return res, nil
+	}
+	_ = c.near.Set(key, res, c.promoteTTL)
+	return res, nil
+}
+
+// Delete removes key from both tiers, ErrNoSuchKey is returned if neither has it.
+func (c *TieredCache) Delete(key string) error {
+	nearErr := c.near.Delete(key)
+	if nearErr != nil && !errors.Is(nearErr, persist.ErrNoSuchKey) {
+		return nearErr
+	}
+	farErr := c.far.Delete(key)
+	if errors.Is(farErr, persist.ErrNoSuchKey) && nearErr == nil {
+		return nil
+	}
+	return farErr
+}
+
+// Clear deletes all the items stored in both tiers.
+func (c *TieredCache) Clear() error {
+	if err := c.near.Clear(); err != nil {
+		return err
+	}
+	return c.far.Clear()
+}
+
+// IsConcurrent reports whether the cache is safe for concurrent use, which it is if both tiers are.
+func (c *TieredCache) IsConcurrent() bool {
+	return isConcurrent(c.near) && isConcurrent(c.far)
+}
+
+// isConcurrent reports whether c implements persist.ConcurrentCache and is safe for concurrent use.
+func isConcurrent(c persist.Cache) bool {
+	cc, ok := c.(persist.ConcurrentCache)
+	return ok && cc.IsConcurrent()
+}
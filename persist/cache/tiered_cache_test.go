@@ -0,0 +1,161 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+// mockFarCache is a far tier recording the survival times it is given, which can't report them back.
+type mockFarCache struct {
+	m     map[string]bool
+	ttls  map[string]interface{}
+	gets  int
+	clear int
+}
+
+func newMockFarCache() *mockFarCache {
+	return &mockFarCache{m: make(map[string]bool), ttls: make(map[string]interface{})}
+}
+
+func (c *mockFarCache) Set(key string, value bool, extra ...interface{}) error {
+	c.m[key] = value
+	c.ttls[key] = nil
+	if len(extra) > 0 {
+		c.ttls[key] = extra[0]
+	}
+	return nil
+}
+
+func (c *mockFarCache) Get(key string) (bool, error) {
+	c.gets++
+	res, ok := c.m[key]
+	if !ok {
+		return false, persist.ErrNoSuchKey
+	}
+	return res, nil
+}
+
+func (c *mockFarCache) Delete(key string) error {
+	if _, ok := c.m[key]; !ok {
+		return persist.ErrNoSuchKey
+	}
+	delete(c.m, key)
+	return nil
+}
+
+func (c *mockFarCache) Clear() error {
+	c.clear++
+	c.m = make(map[string]bool)
+	return nil
+}
+
+func TestTieredCache(t *testing.T) {
+	near, far := NewDefaultCache(), newMockFarCache()
+	c := NewTieredCache(near, far)
+
+	// Set writes through to both tiers with the same survival time.
+	_ = c.Set("alice", true, uint(10))
+	testGetCache(t, near, "alice", true, nil)
+	if far.m["alice"] != true || far.ttls["alice"] != uint(10) {
+		t.Errorf("far tier holds %t with TTL %v, supposed to be true with TTL 10", far.m["alice"], far.ttls["alice"])
+	}
+	testGetCache(t, c, "alice", true, nil)
+	if far.gets != 0 {
+		t.Error("a near tier hit should not read the far tier")
+	}
+
+	// A far tier hit is promoted, with the promote TTL as the far tier can't report its own.
+	_ = far.Set("bob", false)
+	testGetCache(t, c, "bob", false, nil)
+	testGetCache(t, near, "bob", false, nil)
+	if ttl, _ := near.TTL("bob"); ttl <= 0 || ttl > time.Duration(DefaultPromoteTTL)*time.Second {
+		t.Errorf("promoted TTL is %v, supposed to be at most %ds", ttl, DefaultPromoteTTL)
+	}
+	testGetCache(t, c, "bob", false, nil)
+	if far.gets != 1 {
+		t.Errorf("far tier read %d times, supposed to be once", far.gets)
+	}
+	testGetCache(t, c, "carol", false, persist.ErrNoSuchKey)
+
+	// Delete and Clear hit both tiers.
+	_ = far.Delete("alice")
+	if err := c.Delete("alice"); err != nil {
+		t.Errorf("Delete of a key held by the near tier only returned %v", err)
+	}
+	testGetCache(t, near, "alice", false, persist.ErrNoSuchKey)
+	if err := c.Delete("alice"); err != persist.ErrNoSuchKey {
+		t.Errorf("Delete of a missing key returned %v, supposed to be %v", err, persist.ErrNoSuchKey)
+	}
+	_ = c.Clear()
+	testGetCache(t, near, "bob", false, persist.ErrNoSuchKey)
+	if far.clear != 1 || len(far.m) != 0 {
+		t.Error("Clear should clear the far tier")
+	}
+}
+
+func TestTieredCachePromoteTTL(t *testing.T) {
+	near, far := NewDefaultCache(), NewDefaultCache()
+	c := NewTieredCache(near, far)
+
+	// The far tier reports the remaining survival time, which caps the promoted one.
+	_ = far.Set("alice", true, uint(5))
+	_ = far.Set("bob", true)
+	testGetCache(t, c, "alice", true, nil)
+	testGetCache(t, c, "bob", true, nil)
+	if ttl, _ := near.TTL("alice"); ttl <= 0 || ttl > 5*time.Second {
+		t.Errorf("promoted TTL is %v, supposed to be at most 5s", ttl)
+	}
+	if ttl, err := near.TTL("bob"); err != nil || ttl != 0 {
+		t.Errorf("promoted TTL is %v, %v, supposed to never expire like in the far tier", ttl, err)
+	}
+
+	// The promoted item survives exactly as long as in the far tier, even for less than a second.
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	near, far = NewDefaultCache(WithClock(clock)), NewDefaultCache(WithClock(clock))
+	c = NewTieredCache(near, far)
+	_ = far.Set("alice", true, uint(5))
+	clock.Advance(4500 * time.Millisecond)
+	testGetCache(t, c, "alice", true, nil)
+	if ttl, err := near.TTL("alice"); err != nil || ttl != 500*time.Millisecond {
+		t.Errorf("promoted TTL is %v, %v, supposed to be the remaining 500ms", ttl, err)
+	}
+	clock.Advance(500 * time.Millisecond)
+	testGetCache(t, near, "alice", false, persist.ErrNoSuchKey)
+
+	// Without the far tier's TTL, promoted items still expire.
+	near = NewDefaultCache()
+	mock := newMockFarCache()
+	c = NewTieredCache(near, mock)
+	c.SetPromoteTTL(1)
+	_ = mock.Set("carol", true)
+	testGetCache(t, c, "carol", true, nil)
+	time.Sleep(1100 * time.Millisecond)
+	_ = mock.Delete("carol")
+	testGetCache(t, c, "carol", false, persist.ErrNoSuchKey)
+}
+
+func TestTieredCacheIsConcurrent(t *testing.T) {
+	var _ persist.ConcurrentCache = &TieredCache{}
+	if c := NewTieredCache(NewDefaultCache(), NewLRUCache(10)); !c.IsConcurrent() {
+		t.Error("a TieredCache of concurrent tiers is supposed to be concurrent")
+	}
+	if c := NewTieredCache(NewDefaultCache(), newMockFarCache()); c.IsConcurrent() {
+		t.Error("a TieredCache with a tier which is not concurrent is not supposed to be concurrent")
+	}
+}
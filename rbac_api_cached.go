@@ -0,0 +1,95 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package casbin
+
+// AddRoleForUser adds a role for a user.
+// Returns false if the user already has the role (aka not affected), or else invalidates the cached decisions.
+func (e *CachedEnforcer) AddRoleForUser(user string, role string, domain ...string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.AddRoleForUser(user, role, domain...)
+	})
+}
+
+// AddRolesForUser adds roles for a user.
+// Returns false if the user already has the roles (aka not affected), or else invalidates the cached decisions.
+func (e *CachedEnforcer) AddRolesForUser(user string, roles []string, domain ...string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.AddRolesForUser(user, roles, domain...)
+	})
+}
+
+// DeleteRoleForUser deletes a role for a user.
+// Returns false if the user does not have the role (aka not affected), or else invalidates the cached decisions.
+func (e *CachedEnforcer) DeleteRoleForUser(user string, role string, domain ...string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.DeleteRoleForUser(user, role, domain...)
+	})
+}
+
+// DeleteRolesForUser deletes all roles for a user.
+// Returns false if the user does not have any roles (aka not affected), or else invalidates the cached decisions.
+func (e *CachedEnforcer) DeleteRolesForUser(user string, domain ...string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.DeleteRolesForUser(user, domain...)
+	})
+}
+
+// DeleteUser deletes a user.
+// Returns false if the user does not exist (aka not affected), or else invalidates the cached decisions.
+func (e *CachedEnforcer) DeleteUser(user string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.DeleteUser(user)
+	})
+}
+
+// DeleteRole deletes a role.
+// Returns false if the role does not exist (aka not affected), or else invalidates the cached decisions.
+func (e *CachedEnforcer) DeleteRole(role string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.DeleteRole(role)
+	})
+}
+
+// DeletePermission deletes a permission.
+// Returns false if the permission does not exist (aka not affected), or else invalidates the cached decisions.
+func (e *CachedEnforcer) DeletePermission(permission ...string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.DeletePermission(permission...)
+	})
+}
+
+// AddPermissionForUser adds a permission for a user or role.
+// Returns false if the user or role already has the permission (aka not affected), or else invalidates the cached decisions.
+func (e *CachedEnforcer) AddPermissionForUser(user string, permission ...string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.AddPermissionForUser(user, permission...)
+	})
+}
+
+// DeletePermissionForUser deletes a permission for a user or role.
+// Returns false if the user or role does not have the permission (aka not affected), or else invalidates the cached decisions.
+func (e *CachedEnforcer) DeletePermissionForUser(user string, permission ...string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.DeletePermissionForUser(user, permission...)
+	})
+}
+
+// DeletePermissionsForUser deletes permissions for a user or role.
+// Returns false if the user or role does not have any permissions (aka not affected), or else invalidates the cached decisions.
+func (e *CachedEnforcer) DeletePermissionsForUser(user string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.DeletePermissionsForUser(user)
+	})
+}
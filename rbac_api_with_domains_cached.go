@@ -0,0 +1,39 @@
+// Copyright 2021 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package casbin
+
+// AddRoleForUserInDomain adds a role for a user inside a domain.
+// Returns false if the user already has the role (aka not affected), or else invalidates the cached decisions.
+func (e *CachedEnforcer) AddRoleForUserInDomain(user string, role string, domain string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.AddRoleForUserInDomain(user, role, domain)
+	})
+}
+
+// DeleteRoleForUserInDomain deletes a role for a user inside a domain.
+// Returns false if the user does not have the role (aka not affected), or else invalidates the cached decisions.
+func (e *CachedEnforcer) DeleteRoleForUserInDomain(user string, role string, domain string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.DeleteRoleForUserInDomain(user, role, domain)
+	})
+}
+
+// DeleteRolesForUserInDomain deletes all roles for a user inside a domain.
+// Returns false if the user does not have any roles (aka not affected), or else invalidates the cached decisions.
+func (e *CachedEnforcer) DeleteRolesForUserInDomain(user string, domain string) (bool, error) {
+	return e.changePolicy(func() (bool, error) {
+		return e.Enforcer.DeleteRolesForUserInDomain(user, domain)
+	})
+}